@@ -0,0 +1,31 @@
+package auth
+
+import "strings"
+
+// PersonalAccessTokenPrefix starts every personal access token, so they can be told apart from
+// access tokens and recognised by secret scanners
+const PersonalAccessTokenPrefix = "chirpy_pat_"
+
+func MakePersonalAccessToken() (string, error) {
+	// Returns a new personal access token: the prefix, 32 random bytes in hex, then a CRC32 of both
+
+	return makePrefixedToken(PersonalAccessTokenPrefix)
+}
+
+func IsPersonalAccessToken(token string) bool {
+	// Reports whether a bearer token is meant to be a personal access token rather than a JWT
+
+	return strings.HasPrefix(token, PersonalAccessTokenPrefix)
+}
+
+func ValidPersonalAccessTokenFormat(token string) bool {
+	// Reports whether token has the personal access token prefix and a matching checksum
+
+	return validPrefixedToken(PersonalAccessTokenPrefix, token)
+}
+
+func HashPersonalAccessToken(key []byte, token string) string {
+	// Hashes a personal access token for storage with a server-side key
+
+	return keyedHash(key, token)
+}
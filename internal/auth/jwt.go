@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
@@ -9,22 +10,67 @@ import (
 	"time"
 )
 
-func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
-	// Makes a new JWT and returns it as a string
+// AccessClaims are the claims carried by access tokens
+type AccessClaims struct {
+	jwt.RegisteredClaims
+	Version int32 `json:"ver"`
+	// Scope and ClientID are only set on tokens issued to OAuth clients, Scope is space separated
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+}
+
+func (c *AccessClaims) Scopes() []string {
+	// Returns the scopes the token carries, every scope for a first-party token
+
+	if c.Scope == "" {
+		return AllScopes
+	}
+	return strings.Fields(c.Scope)
+}
+
+// RevocationChecker reports the state ValidateJWT needs to reject revoked tokens
+type RevocationChecker interface {
+	TokenVersion(ctx context.Context, userID uuid.UUID) (int32, error)
+	IsTokenDenied(ctx context.Context, jti string) (bool, error)
+}
+
+func MakeJWT(userID uuid.UUID, version int32, keys *KeySet, expiresIn time.Duration) (string, error) {
+	// Makes a new JWT signed with the key set's signing key and returns it as a string, version is the user's current token version
+
+	return MakeScopedJWT(userID, version, keys, expiresIn, "", nil)
+}
+
+func MakeScopedJWT(userID uuid.UUID, version int32, keys *KeySet, expiresIn time.Duration, clientID string, scopes []string) (string, error) {
+	// Makes a JWT like MakeJWT, limited to scopes on behalf of an OAuth client; nil scopes and an empty client make a first-party token
+
+	key, err := keys.SigningKey()
+	if err != nil {
+		return "", err
+	}
 
 	// Builds the JWT with specified claims and signing algorithm
 	newJWT := jwt.NewWithClaims(
-		jwt.SigningMethodHS256,
-		jwt.RegisteredClaims{
-			Issuer:    "chirpy",
-			IssuedAt:  &jwt.NumericDate{time.Now().UTC()},
-			ExpiresAt: &jwt.NumericDate{time.Now().UTC().Add(expiresIn)},
-			Subject:   userID.String(),
+		key.Method,
+		AccessClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    DefaultIssuer,
+				Audience:  jwt.ClaimStrings{DefaultAudience},
+				IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+				ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+				Subject:   userID.String(),
+				ID:        uuid.NewString(),
+			},
+			Version:  version,
+			Scope:    strings.Join(scopes, " "),
+			ClientID: clientID,
 		},
 	)
 
-	// Signs the JWT with the secret
-	JWT, err := newJWT.SignedString([]byte(tokenSecret))
+	// Tags the token with the key ID so verifiers can pick the matching key after a rotation
+	newJWT.Header["kid"] = key.ID
+
+	// Signs the JWT with the private key
+	JWT, err := newJWT.SignedString(key.Private)
 	if err != nil {
 		return "", err
 	}
@@ -33,42 +79,45 @@ func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (str
 	return JWT, nil
 }
 
-func ValidateJWT(tokenString string, tokenSecret string) (uuid.UUID, error) {
+func ValidateJWT(ctx context.Context, tokenString string, keys *KeySet, revocations RevocationChecker) (uuid.UUID, error) {
 	// Validates an input token string and returns the token bearer's UUID if it's valid
 
-	// Specifies the key function to pass the secret to the parser
-	keyFunc := func(token *jwt.Token) (any, error) {
-		return []byte(tokenSecret), nil
-	}
-
-	// Parses the token string
-	out, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, keyFunc)
+	clms, err := ParseJWT(ctx, tokenString, keys, revocations)
 	if err != nil {
 		return uuid.UUID{}, err
 	}
 
-	// Checks if the token if valid
-	if out.Valid {
-		// Checks if the claims fit the structure we want (just jwt.RegisteredClaims)
-		if clms, ok := out.Claims.(*jwt.RegisteredClaims); ok {
-			// Gets the UUID from the token's claims
-			uid, err := uuid.Parse(clms.Subject)
-			if err != nil {
-				return uuid.UUID{}, fmt.Errorf("Failed to parse UUID: %s", clms.Subject)
-			}
-			// Returns the UUID
-			return uid, nil
-		}
-		return uuid.UUID{}, fmt.Errorf("Failed to assert claims type")
+	// Gets the UUID from the token's claims
+	uid, err := uuid.Parse(clms.Subject)
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("Failed to parse UUID: %s", clms.Subject)
 	}
-	return uuid.UUID{}, fmt.Errorf("Invalid token")
+	return uid, nil
+}
+
+func ParseJWT(ctx context.Context, tokenString string, keys *KeySet, revocations RevocationChecker) (*AccessClaims, error) {
+	// Validates an input token string with the default rules and returns its claims, revocations may be nil to skip revocation checks
+
+	v := Validator{Keys: keys, Revocations: revocations}
+	return v.Parse(ctx, tokenString)
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
+	// Finds the token in the Authorization header, given either as "Bearer <token>" or bare
+
 	token := headers.Get("Authorization")
 	if token == "" {
 		return token, fmt.Errorf("Token not found")
 	}
+
+	// Checks the shape before indexing, so a header like "Bearer" with nothing after it is an error rather than a panic
 	tknFields := strings.Fields(token)
-	return tknFields[1], nil
+	switch {
+	case len(tknFields) == 1 && !strings.EqualFold(tknFields[0], "Bearer"):
+		return tknFields[0], nil
+	case len(tknFields) == 2 && strings.EqualFold(tknFields[0], "Bearer"):
+		return tknFields[1], nil
+	default:
+		return "", fmt.Errorf("Malformed authorization header")
+	}
 }
@@ -1,6 +1,11 @@
 package auth_test
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/roxensox/chirpy/internal/auth"
 	"net/http"
@@ -8,6 +13,13 @@ import (
 	"time"
 )
 
+func hmacKeys(secret string) *auth.KeySet {
+	keys := auth.NewKeySet()
+	keys.Add(auth.NewHMACKey("default", secret))
+	keys.SetSigningKey("default")
+	return keys
+}
+
 func TestJWT(t *testing.T) {
 	test_UUID, err := uuid.NewUUID()
 	if err != nil {
@@ -49,12 +61,12 @@ func TestJWT(t *testing.T) {
 	}
 
 	for i, tc := range test_cases {
-		jwt_str, err := auth.MakeJWT(tc.UID, tc.tokenSecret, tc.expiresIn)
+		jwt_str, err := auth.MakeJWT(tc.UID, 0, hmacKeys(tc.tokenSecret), tc.expiresIn)
 		if err != nil {
 			t.Errorf("Failed at MakeJWT:\n\tError: %v", err)
 			return
 		}
-		UID, err := auth.ValidateJWT(jwt_str, tc.compSecret)
+		UID, err := auth.ValidateJWT(context.Background(), jwt_str, hmacKeys(tc.compSecret), nil)
 		if err != nil {
 			if tc.tokenSecret == tc.compSecret && tc.expiresIn > 0 {
 				t.Errorf("Case #%d failed at ValidateJWT:\n\tError: %v", i, err)
@@ -68,9 +80,73 @@ func TestJWT(t *testing.T) {
 	}
 }
 
+type fakeRevocations struct {
+	version int32
+	denied  map[string]bool
+}
+
+func (f fakeRevocations) TokenVersion(ctx context.Context, userID uuid.UUID) (int32, error) {
+	return f.version, nil
+}
+
+func (f fakeRevocations) IsTokenDenied(ctx context.Context, jti string) (bool, error) {
+	return f.denied[jti], nil
+}
+
+func TestJWTRevocation(t *testing.T) {
+	testUID := uuid.New()
+	keys := hmacKeys("Pippin")
+	tkn, err := auth.MakeJWT(testUID, 2, keys, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed at MakeJWT:\n\tError: %v", err)
+	}
+	clms, err := auth.ParseJWT(context.Background(), tkn, keys, nil)
+	if err != nil {
+		t.Fatalf("Failed at ParseJWT:\n\tError: %v", err)
+	}
+	if clms.ID == "" {
+		t.Errorf("Token is missing a jti")
+	}
+
+	test_cases := []struct {
+		name        string
+		revocations fakeRevocations
+		revoked     bool
+	}{
+		{
+			name:        "current version",
+			revocations: fakeRevocations{version: 2},
+			revoked:     false,
+		},
+		{
+			name:        "stale version",
+			revocations: fakeRevocations{version: 3},
+			revoked:     true,
+		},
+		{
+			name:        "denylisted jti",
+			revocations: fakeRevocations{version: 2, denied: map[string]bool{clms.ID: true}},
+			revoked:     true,
+		},
+	}
+
+	for _, tc := range test_cases {
+		UID, err := auth.ValidateJWT(context.Background(), tkn, keys, tc.revocations)
+		if tc.revoked {
+			if !errors.Is(err, auth.ErrTokenRevoked) {
+				t.Errorf("Case %q: expected ErrTokenRevoked, got %v", tc.name, err)
+			}
+			continue
+		}
+		if err != nil || UID != testUID {
+			t.Errorf("Case %q: expected valid token, got %v", tc.name, err)
+		}
+	}
+}
+
 func TestGetBearerToken(t *testing.T) {
 	testUID, _ := uuid.NewUUID()
-	testjwt, _ := auth.MakeJWT(testUID, "Pippin", 5*time.Second)
+	testjwt, _ := auth.MakeJWT(testUID, 0, hmacKeys("Pippin"), 5*time.Second)
 	test_cases := []struct {
 		token    string
 		header   http.Header
@@ -125,3 +201,203 @@ func TestGetBearerToken(t *testing.T) {
 		}
 	}
 }
+
+func TestGetBearerTokenMalformed(t *testing.T) {
+	test_cases := map[string]string{
+		"missing":        "",
+		"scheme only":    "Bearer",
+		"other scheme":   "ApiKey abc",
+		"too many parts": "Bearer abc def",
+	}
+
+	for name, header := range test_cases {
+		t.Run(name, func(t *testing.T) {
+			h := http.Header{}
+			if header != "" {
+				h.Set("Authorization", header)
+			}
+			if tkn, err := auth.GetBearerToken(h); err == nil {
+				t.Errorf("Expected an error for %q, got token %q", header, tkn)
+			}
+		})
+	}
+
+	// The scheme is case-insensitive
+	tkn, err := auth.GetBearerToken(http.Header{"Authorization": []string{"bearer abc"}})
+	if err != nil || tkn != "abc" {
+		t.Errorf("Expected abc, got %q (%v)", tkn, err)
+	}
+}
+
+func signTestToken(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.Claims) string {
+	tkn := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		tkn.Header["kid"] = kid
+	}
+	out, err := tkn.SignedString(key)
+	if err != nil {
+		t.Fatalf("Failed to sign test token: %v", err)
+	}
+	return out
+}
+
+func TestValidator(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	keys := auth.NewKeySet()
+	keys.Add(&auth.SigningKey{ID: "ed", Method: jwt.SigningMethodEdDSA, Private: edKey, Public: edKey.Public()})
+	keys.SetSigningKey("ed")
+
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	UID := uuid.New()
+	now := time.Now()
+
+	claims := func(edit func(*auth.AccessClaims)) *auth.AccessClaims {
+		c := &auth.AccessClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    auth.DefaultIssuer,
+				Audience:  jwt.ClaimStrings{auth.DefaultAudience},
+				Subject:   UID.String(),
+				IssuedAt:  jwt.NewNumericDate(now),
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+				ID:        uuid.NewString(),
+			},
+		}
+		if edit != nil {
+			edit(c)
+		}
+		return c
+	}
+
+	test_cases := []struct {
+		name      string
+		validator auth.Validator
+		token     string
+		expected  error
+	}{
+		{
+			name:      "valid",
+			validator: auth.Validator{Keys: keys},
+			token:     signTestToken(t, jwt.SigningMethodEdDSA, "ed", edKey, claims(nil)),
+			expected:  nil,
+		},
+		{
+			name:      "expired",
+			validator: auth.Validator{Keys: keys},
+			token: signTestToken(t, jwt.SigningMethodEdDSA, "ed", edKey, claims(func(c *auth.AccessClaims) {
+				c.IssuedAt = jwt.NewNumericDate(now.Add(-time.Hour))
+				c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute))
+			})),
+			expected: auth.ErrTokenExpired,
+		},
+		{
+			name:      "expired within clock skew",
+			validator: auth.Validator{Keys: keys, ClockSkew: 2 * time.Minute},
+			token: signTestToken(t, jwt.SigningMethodEdDSA, "ed", edKey, claims(func(c *auth.AccessClaims) {
+				c.IssuedAt = jwt.NewNumericDate(now.Add(-time.Hour))
+				c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute))
+			})),
+			expected: nil,
+		},
+		{
+			name:      "clock skew is capped",
+			validator: auth.Validator{Keys: keys, ClockSkew: 24 * time.Hour},
+			token: signTestToken(t, jwt.SigningMethodEdDSA, "ed", edKey, claims(func(c *auth.AccessClaims) {
+				c.IssuedAt = jwt.NewNumericDate(now.Add(-2 * time.Hour))
+				c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Hour))
+			})),
+			expected: auth.ErrTokenExpired,
+		},
+		{
+			name:      "issued in the future",
+			validator: auth.Validator{Keys: keys},
+			token: signTestToken(t, jwt.SigningMethodEdDSA, "ed", edKey, claims(func(c *auth.AccessClaims) {
+				c.IssuedAt = jwt.NewNumericDate(now.Add(time.Minute))
+			})),
+			expected: auth.ErrTokenNotYetValid,
+		},
+		{
+			name:      "missing expiry",
+			validator: auth.Validator{Keys: keys},
+			token: signTestToken(t, jwt.SigningMethodEdDSA, "ed", edKey, claims(func(c *auth.AccessClaims) {
+				c.ExpiresAt = nil
+			})),
+			expected: auth.ErrMalformedToken,
+		},
+		{
+			name:      "signed with another key",
+			validator: auth.Validator{Keys: keys},
+			token:     signTestToken(t, jwt.SigningMethodEdDSA, "ed", otherKey, claims(nil)),
+			expected:  auth.ErrBadSignature,
+		},
+		{
+			name:      "unknown key ID",
+			validator: auth.Validator{Keys: keys},
+			token:     signTestToken(t, jwt.SigningMethodEdDSA, "missing", edKey, claims(nil)),
+			expected:  auth.ErrBadSignature,
+		},
+		{
+			name:      "algorithm not pinned",
+			validator: auth.Validator{Keys: keys, Algorithms: []string{"RS256"}},
+			token:     signTestToken(t, jwt.SigningMethodEdDSA, "ed", edKey, claims(nil)),
+			expected:  auth.ErrBadSignature,
+		},
+		{
+			name:      "algorithm swapped to HMAC",
+			validator: auth.Validator{Keys: keys},
+			token:     signTestToken(t, jwt.SigningMethodHS256, "ed", []byte(edKey.Public().(ed25519.PublicKey)), claims(nil)),
+			expected:  auth.ErrBadSignature,
+		},
+		{
+			name:      "unsigned",
+			validator: auth.Validator{Keys: keys},
+			token:     signTestToken(t, jwt.SigningMethodNone, "ed", jwt.UnsafeAllowNoneSignatureType, claims(nil)),
+			expected:  auth.ErrBadSignature,
+		},
+		{
+			name:      "wrong issuer",
+			validator: auth.Validator{Keys: keys},
+			token: signTestToken(t, jwt.SigningMethodEdDSA, "ed", edKey, claims(func(c *auth.AccessClaims) {
+				c.Issuer = "someone-else"
+			})),
+			expected: auth.ErrWrongIssuer,
+		},
+		{
+			name:      "wrong audience",
+			validator: auth.Validator{Keys: keys},
+			token: signTestToken(t, jwt.SigningMethodEdDSA, "ed", edKey, claims(func(c *auth.AccessClaims) {
+				c.Audience = jwt.ClaimStrings{"another-api"}
+			})),
+			expected: auth.ErrWrongAudience,
+		},
+		{
+			name:      "configured audience",
+			validator: auth.Validator{Keys: keys, Audience: "another-api"},
+			token: signTestToken(t, jwt.SigningMethodEdDSA, "ed", edKey, claims(func(c *auth.AccessClaims) {
+				c.Audience = jwt.ClaimStrings{"another-api"}
+			})),
+			expected: nil,
+		},
+		{
+			name:      "garbage",
+			validator: auth.Validator{Keys: keys},
+			token:     "not.a.token",
+			expected:  auth.ErrMalformedToken,
+		},
+	}
+
+	for _, tc := range test_cases {
+		got, err := tc.validator.Validate(context.Background(), tc.token)
+		if tc.expected == nil {
+			if err != nil || got != UID {
+				t.Errorf("Case %q: expected a valid token, got %v", tc.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, tc.expected) {
+			t.Errorf("Case %q: expected %v, got %v", tc.name, tc.expected, err)
+		}
+	}
+}
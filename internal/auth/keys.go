@@ -0,0 +1,283 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SigningKey is a key that can verify access tokens, and sign them if Private is set
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private any
+	Public  any
+}
+
+// KeySet holds every key access tokens may be verified with and picks the one new tokens are signed with.
+//
+// Rotating keys:
+//  1. Add the new key to the key directory with a file name that sorts after the current one
+//  2. Reload the key set (POST /admin/keys/reload or a restart); new tokens are signed with the new key
+//  3. The old key keeps verifying until every token it signed has expired, then it's dropped by Prune
+//  4. Remove the old key file at leisure
+//
+// Every key other than the signing key is retired on load, so verification-only keys never outlive
+// the longest token lifetime after a reload.
+type KeySet struct {
+	mu      sync.RWMutex
+	keys    map[string]*SigningKey
+	retired map[string]time.Time
+	signing string
+}
+
+func NewKeySet() *KeySet {
+	// Builds an empty key set
+
+	return &KeySet{
+		keys:    make(map[string]*SigningKey),
+		retired: make(map[string]time.Time),
+	}
+}
+
+func NewHMACKey(id, secret string) *SigningKey {
+	// Wraps a shared secret as an HS256 key, for deployments without asymmetric keys
+
+	return &SigningKey{
+		ID:      id,
+		Method:  jwt.SigningMethodHS256,
+		Private: []byte(secret),
+		Public:  []byte(secret),
+	}
+}
+
+func (ks *KeySet) Add(key *SigningKey) {
+	// Adds or replaces a key, keeping its retirement time if it was retired
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys[key.ID] = key
+}
+
+func (ks *KeySet) SetSigningKey(id string) error {
+	// Signs new tokens with the key id; the previous signing key stays around for verification
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	key, ok := ks.keys[id]
+	if !ok {
+		return fmt.Errorf("Unknown key: %s", id)
+	}
+	if key.Private == nil {
+		return fmt.Errorf("Key %s can't sign tokens", id)
+	}
+	if ks.signing != "" && ks.signing != id {
+		ks.retired[ks.signing] = time.Now()
+	}
+	ks.signing = id
+	delete(ks.retired, id)
+	return nil
+}
+
+func (ks *KeySet) Retire(id string) {
+	// Stops treating a key as current; it still verifies tokens until pruned
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if _, ok := ks.keys[id]; !ok || id == ks.signing {
+		return
+	}
+	if _, ok := ks.retired[id]; !ok {
+		ks.retired[id] = time.Now()
+	}
+}
+
+func (ks *KeySet) Prune(maxTokenAge time.Duration) {
+	// Drops keys retired longer ago than the longest token lifetime, since nothing they signed is still valid
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	for id, retiredAt := range ks.retired {
+		if time.Since(retiredAt) > maxTokenAge {
+			delete(ks.keys, id)
+			delete(ks.retired, id)
+		}
+	}
+}
+
+func (ks *KeySet) SigningKey() (*SigningKey, error) {
+	// Returns the key new tokens are signed with
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	key, ok := ks.keys[ks.signing]
+	if !ok {
+		return nil, fmt.Errorf("No signing key configured")
+	}
+	return key, nil
+}
+
+func (ks *KeySet) Lookup(id string) (*SigningKey, bool) {
+	// Returns the verification key with the given ID
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	key, ok := ks.keys[id]
+	return key, ok
+}
+
+func (ks *KeySet) IDs() []string {
+	// Returns the IDs of every key in the set, sorted
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	ids := make([]string, 0, len(ks.keys))
+	for id := range ks.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// JWK is a single public key in JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set document
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func (ks *KeySet) JWKS() JWKS {
+	// Returns the public half of every asymmetric key, shared secrets are never published
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	out := JWKS{Keys: make([]JWK, 0, len(ks.keys))}
+	for _, key := range ks.keys {
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			out.Keys = append(out.Keys, JWK{
+				Kty: "RSA",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			out.Keys = append(out.Keys, JWK{
+				Kty: "OKP",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	sort.Slice(out.Keys, func(i, j int) bool {
+		return out.Keys[i].Kid < out.Keys[j].Kid
+	})
+	return out
+}
+
+func LoadKeyFile(path string) (*SigningKey, error) {
+	// Reads a PEM encoded RSA or Ed25519 key, the file name without its extension becomes the key ID
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM data in %s", path)
+	}
+	id := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	var parsed any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("Unsupported PEM block %q in %s", block.Type, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %w", path, err)
+	}
+
+	// Private keys can sign and verify, public keys only verify tokens signed before a rotation
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &SigningKey{ID: id, Method: jwt.SigningMethodRS256, Private: k, Public: &k.PublicKey}, nil
+	case *rsa.PublicKey:
+		return &SigningKey{ID: id, Method: jwt.SigningMethodRS256, Public: k}, nil
+	case ed25519.PrivateKey:
+		return &SigningKey{ID: id, Method: jwt.SigningMethodEdDSA, Private: k, Public: k.Public().(ed25519.PublicKey)}, nil
+	case ed25519.PublicKey:
+		return &SigningKey{ID: id, Method: jwt.SigningMethodEdDSA, Public: k}, nil
+	}
+	return nil, fmt.Errorf("Unsupported key type %T in %s", parsed, path)
+}
+
+func (ks *KeySet) LoadDir(dir string) error {
+	// Loads every .pem file in dir, signs with the last private key by name and retires the rest
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	loaded := make(map[string]*SigningKey, len(paths))
+	signing := ""
+	for _, path := range paths {
+		key, err := LoadKeyFile(path)
+		if err != nil {
+			return err
+		}
+		loaded[key.ID] = key
+		if key.Private != nil {
+			signing = key.ID
+		}
+	}
+	if signing == "" {
+		return fmt.Errorf("No private keys found in %s", dir)
+	}
+
+	for _, key := range loaded {
+		ks.Add(key)
+	}
+	if err := ks.SetSigningKey(signing); err != nil {
+		return err
+	}
+
+	// Older keys, including ones that left the directory, keep verifying until their tokens have expired
+	for _, id := range ks.IDs() {
+		ks.Retire(id)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeKey(t *testing.T, dir, name string, key any) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+}
+
+func TestAsymmetricKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key: %v", err)
+	}
+
+	test_cases := []struct {
+		name string
+		key  any
+		alg  string
+	}{
+		{name: "rsa.pem", key: rsaKey, alg: "RS256"},
+		{name: "ed.pem", key: edKey, alg: "EdDSA"},
+	}
+
+	for _, tc := range test_cases {
+		dir := t.TempDir()
+		writeKey(t, dir, tc.name, tc.key)
+
+		keys := auth.NewKeySet()
+		if err := keys.LoadDir(dir); err != nil {
+			t.Fatalf("Failed to load %s: %v", tc.name, err)
+		}
+
+		UID := uuid.New()
+		tkn, err := auth.MakeJWT(UID, 0, keys, time.Minute)
+		if err != nil {
+			t.Fatalf("Failed to sign with %s: %v", tc.name, err)
+		}
+		got, err := auth.ValidateJWT(context.Background(), tkn, keys, nil)
+		if err != nil || got != UID {
+			t.Errorf("Failed to verify %s token: %v", tc.name, err)
+		}
+
+		jwks := keys.JWKS()
+		if len(jwks.Keys) != 1 || jwks.Keys[0].Alg != tc.alg {
+			t.Errorf("Unexpected JWKS for %s: %+v", tc.name, jwks)
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	_, oldKey, _ := ed25519.GenerateKey(rand.Reader)
+	writeKey(t, dir, "2026-01.pem", oldKey)
+
+	keys := auth.NewKeySet()
+	if err := keys.LoadDir(dir); err != nil {
+		t.Fatalf("Failed to load keys: %v", err)
+	}
+	UID := uuid.New()
+	oldTkn, _ := auth.MakeJWT(UID, 0, keys, time.Minute)
+
+	// Adds a newer key and reloads, the old token should still verify
+	_, newKey, _ := ed25519.GenerateKey(rand.Reader)
+	writeKey(t, dir, "2026-02.pem", newKey)
+	if err := keys.LoadDir(dir); err != nil {
+		t.Fatalf("Failed to reload keys: %v", err)
+	}
+	signing, _ := keys.SigningKey()
+	if signing.ID != "2026-02" {
+		t.Errorf("Expected the newest key to sign, got %s", signing.ID)
+	}
+	if _, err := auth.ValidateJWT(context.Background(), oldTkn, keys, nil); err != nil {
+		t.Errorf("Token signed before the rotation should still verify: %v", err)
+	}
+
+	// Once the old key is pruned its tokens stop verifying, new ones are unaffected
+	keys.Prune(0)
+	if _, err := auth.ValidateJWT(context.Background(), oldTkn, keys, nil); err == nil {
+		t.Errorf("Token signed with a pruned key should not verify")
+	}
+	newTkn, _ := auth.MakeJWT(UID, 0, keys, time.Minute)
+	if _, err := auth.ValidateJWT(context.Background(), newTkn, keys, nil); err != nil {
+		t.Errorf("Token signed with the current key should verify: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+)
+
+const (
+	// ClientSecretPrefix starts every OAuth client secret, so secret scanners can recognise one that leaks
+	ClientSecretPrefix = "chirpy_cs_"
+	// AuthorizationCodePrefix starts every OAuth authorization code
+	AuthorizationCodePrefix = "chirpy_ac_"
+)
+
+func MakeClientSecret() (string, error) {
+	// Returns a new OAuth client secret: the prefix, 32 random bytes in hex, then a CRC32 of both
+
+	return makePrefixedToken(ClientSecretPrefix)
+}
+
+func HashClientSecret(key []byte, secret string) string {
+	// Hashes a client secret for storage with a server-side key
+
+	return keyedHash(key, secret)
+}
+
+func MakeAuthorizationCode() (string, error) {
+	// Returns a new single-use OAuth authorization code
+
+	return makePrefixedToken(AuthorizationCodePrefix)
+}
+
+func PKCEChallenge(verifier string) string {
+	// Returns the S256 code challenge for a PKCE code verifier, as defined in RFC 7636
+
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func ValidPKCEValue(s string) bool {
+	// Reports whether s is a well-formed code verifier or challenge: 43 to 128 unreserved characters
+
+	if len(s) < 43 || len(s) > 128 {
+		return false
+	}
+	for _, r := range s {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		case r == '-', r == '.', r == '_', r == '~':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func VerifyPKCE(verifier, challenge string) bool {
+	// Reports whether verifier is the one the S256 challenge was made from
+
+	if !ValidPKCEValue(verifier) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(PKCEChallenge(verifier)), []byte(challenge)) == 1
+}
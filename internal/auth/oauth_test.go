@@ -0,0 +1,30 @@
+package auth_test
+
+import (
+	"github.com/roxensox/chirpy/internal/auth"
+	"strings"
+	"testing"
+)
+
+func TestPKCE(t *testing.T) {
+	// Example from RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if got := auth.PKCEChallenge(verifier); got != challenge {
+		t.Errorf("PKCEChallenge = %q, expected %q", got, challenge)
+	}
+	if !auth.VerifyPKCE(verifier, challenge) {
+		t.Errorf("Verifier should match its challenge")
+	}
+	if auth.VerifyPKCE(strings.Replace(verifier, "d", "e", 1), challenge) {
+		t.Errorf("A different verifier should not match")
+	}
+	// The plain method would accept the challenge itself as the verifier, S256 must not
+	if auth.VerifyPKCE(challenge, challenge) {
+		t.Errorf("The challenge should not verify itself")
+	}
+	if auth.VerifyPKCE("short", auth.PKCEChallenge("short")) {
+		t.Errorf("Verifiers under 43 characters should be rejected")
+	}
+}
@@ -0,0 +1,18 @@
+package auth
+
+// PasswordResetTokenPrefix starts every password reset token, so secret scanners can tell one apart
+// from a refresh token
+const PasswordResetTokenPrefix = "chirpy_pr_"
+
+func MakePasswordResetToken() (string, error) {
+	// Returns a new password reset token: the prefix, 32 random bytes in hex, then a CRC32 of both
+
+	return makePrefixedToken(PasswordResetTokenPrefix)
+}
+
+func HashPasswordResetToken(key []byte, token string) string {
+	// Hashes a password reset token for storage with a server-side key, so a leaked table can't be
+	// checked against guesses without it
+
+	return keyedHash(key, token)
+}
@@ -1,7 +1,9 @@
 package auth
 
 import (
+	"crypto/rand"
 	"github.com/alexedwards/argon2id"
+	"sync"
 )
 
 func HashPassword(password string) (string, error) {
@@ -11,3 +13,18 @@ func HashPassword(password string) (string, error) {
 func CheckPasswordHash(password, hash string) (bool, error) {
 	return argon2id.ComparePasswordAndHash(password, hash)
 }
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+func DummyPasswordHash() string {
+	// Returns a hash of a random password with the same parameters as real ones, for checking
+	// passwords against when there's no account so the time taken doesn't give that away
+
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = HashPassword(rand.Text())
+	})
+	return dummyHash
+}
@@ -1,19 +1,96 @@
 package auth
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"hash/crc32"
+	"strings"
+)
+
+// RefreshTokenPrefix starts every refresh token, so secret scanners can recognise one that leaks
+const RefreshTokenPrefix = "chirpy_rt_"
+
+const (
+	tokenBytes    = 32
+	tokenChecksum = 8
 )
 
 func MakeRefreshToken() (string, error) {
-	nums := make([]byte, 32)
+	// Returns a new refresh token: the prefix, 32 random bytes in hex, then a CRC32 of both
+
+	return makePrefixedToken(RefreshTokenPrefix)
+}
+
+func ValidRefreshTokenFormat(token string) bool {
+	// Reports whether token looks like a refresh token, so typos and junk can be turned away without a lookup;
+	// tokens issued before the prefix was added are bare hex
+
+	if !strings.HasPrefix(token, RefreshTokenPrefix) {
+		_, err := hex.DecodeString(token)
+		return err == nil && len(token) == 2*tokenBytes
+	}
+	return validPrefixedToken(RefreshTokenPrefix, token)
+}
+
+func HashRefreshToken(key []byte, token string) string {
+	// Hashes a refresh token for storage with a server-side key, so neither a leaked table nor a guess
+	// at the token can be checked without it
+
+	// Tokens issued before the prefix was added were migrated with a plain hash, since the migration can't see the key
+	if !strings.HasPrefix(token, RefreshTokenPrefix) {
+		return HashToken(token)
+	}
+	return keyedHash(key, token)
+}
+
+func makePrefixedToken(prefix string) (string, error) {
+	// Returns prefix, 32 random bytes in hex, then a CRC32 of both so a scanner can tell a real token from lookalikes
+
+	nums := make([]byte, tokenBytes)
 	_, err := rand.Read(nums)
 	if err != nil {
 		fmt.Println("Failed to generate number")
 		return "", err
 	}
 
-	outStr := hex.EncodeToString(nums)
-	return outStr, nil
+	outStr := prefix + hex.EncodeToString(nums)
+	return outStr + tokenCRC(outStr), nil
+}
+
+func validPrefixedToken(prefix, token string) bool {
+	// Reports whether token has the prefix, the right length and a matching checksum
+
+	body, ok := strings.CutPrefix(token, prefix)
+	if !ok || len(body) != 2*tokenBytes+tokenChecksum {
+		return false
+	}
+	if _, err := hex.DecodeString(body[:2*tokenBytes]); err != nil {
+		return false
+	}
+	split := len(token) - tokenChecksum
+	return hmac.Equal([]byte(token[split:]), []byte(tokenCRC(token[:split])))
+}
+
+func tokenCRC(body string) string {
+	// Returns the checksum appended to a prefixed token
+
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(body)))
+}
+
+func keyedHash(key []byte, token string) string {
+	// Returns the HMAC-SHA256 of token in hex
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func HashToken(token string) string {
+	// Hashes a random token for storage, so a leaked table can't be used to redeem the tokens in it
+
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
@@ -2,6 +2,7 @@ package auth_test
 
 import (
 	"github.com/roxensox/chirpy/internal/auth"
+	"strings"
 	"testing"
 )
 
@@ -11,3 +12,90 @@ func TestMakeRefreshToken(t *testing.T) {
 		t.Errorf("Failed to make refresh token")
 	}
 }
+
+func TestHashToken(t *testing.T) {
+	tkn, _ := auth.MakeRefreshToken()
+	if auth.HashToken(tkn) != auth.HashToken(tkn) {
+		t.Errorf("Hashing the same token twice gave different results")
+	}
+	if auth.HashToken(tkn) == tkn {
+		t.Errorf("Hash should not equal the token")
+	}
+}
+
+func TestRefreshTokenFormat(t *testing.T) {
+	tkn, _ := auth.MakeRefreshToken()
+	if !strings.HasPrefix(tkn, auth.RefreshTokenPrefix) {
+		t.Errorf("Token %q is missing the %q prefix", tkn, auth.RefreshTokenPrefix)
+	}
+
+	// Flips one character of the random part, which the checksum should catch
+	last := tkn[len(auth.RefreshTokenPrefix)]
+	flipped := byte('0')
+	if last == '0' {
+		flipped = '1'
+	}
+	typo := tkn[:len(auth.RefreshTokenPrefix)] + string(flipped) + tkn[len(auth.RefreshTokenPrefix)+1:]
+
+	test_cases := []struct {
+		token    string
+		expected bool
+	}{
+		{token: tkn, expected: true},
+		{token: typo, expected: false},
+		{token: tkn[:len(tkn)-1], expected: false},
+		{token: strings.Repeat("ab", 32), expected: true},
+		{token: strings.Repeat("zz", 32), expected: false},
+		{token: "", expected: false},
+	}
+
+	for _, c := range test_cases {
+		if got := auth.ValidRefreshTokenFormat(c.token); got != c.expected {
+			t.Errorf("ValidRefreshTokenFormat(%q) = %v, expected %v", c.token, got, c.expected)
+		}
+	}
+}
+
+func TestHashRefreshToken(t *testing.T) {
+	tkn, _ := auth.MakeRefreshToken()
+	key := []byte("first key")
+	if auth.HashRefreshToken(key, tkn) != auth.HashRefreshToken(key, tkn) {
+		t.Errorf("Hashing the same token twice gave different results")
+	}
+	if auth.HashRefreshToken(key, tkn) == auth.HashRefreshToken([]byte("second key"), tkn) {
+		t.Errorf("Hashes under different keys should differ")
+	}
+	if auth.HashRefreshToken(key, tkn) == auth.HashToken(tkn) {
+		t.Errorf("Keyed hash should differ from the plain hash")
+	}
+
+	// Tokens from before the prefix match the hash the migration stored
+	legacy := strings.Repeat("ab", 32)
+	if auth.HashRefreshToken(key, legacy) != auth.HashToken(legacy) {
+		t.Errorf("Legacy tokens should use the plain hash")
+	}
+}
+
+func TestTokenPrefixes(t *testing.T) {
+	// Each kind of token gets its own prefix, so a leaked one is labelled for what it is
+	test_cases := []struct {
+		make   func() (string, error)
+		prefix string
+	}{
+		{make: auth.MakeRefreshToken, prefix: auth.RefreshTokenPrefix},
+		{make: auth.MakePersonalAccessToken, prefix: auth.PersonalAccessTokenPrefix},
+		{make: auth.MakePasswordResetToken, prefix: auth.PasswordResetTokenPrefix},
+		{make: auth.MakeEmailVerificationToken, prefix: auth.EmailVerificationTokenPrefix},
+		{make: auth.MakeMFAChallengeToken, prefix: auth.MFAChallengeTokenPrefix},
+	}
+
+	for _, c := range test_cases {
+		tkn, err := c.make()
+		if err != nil {
+			t.Fatalf("Failed to make a %q token: %v", c.prefix, err)
+		}
+		if !strings.HasPrefix(tkn, c.prefix) {
+			t.Errorf("Token %q is missing the %q prefix", tkn, c.prefix)
+		}
+	}
+}
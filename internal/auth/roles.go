@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Roles are stored on each user and decide which privileged actions they may take, on top of whatever
+// their token's scopes allow
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Permissions name the privileged actions a role can be granted
+const (
+	// PermModerateChirps covers deleting and restoring other users' chirps
+	PermModerateChirps = "chirps:moderate"
+	// PermRestoreChirps covers the admin restore endpoint, which skips the author check entirely
+	PermRestoreChirps = "chirps:restore"
+	PermUnlockUsers   = "users:unlock"
+	PermManageRoles   = "users:roles"
+	PermViewMetrics   = "server:metrics"
+	// PermManageServer covers reloading signing keys and the moderation word list, and resetting a dev server
+	PermManageServer = "server:manage"
+)
+
+// rolePermissions is the policy: each role's permissions, with plain users having none
+var rolePermissions = map[string][]string{
+	RoleUser:      {},
+	RoleModerator: {PermModerateChirps},
+	RoleAdmin:     {PermModerateChirps, PermRestoreChirps, PermUnlockUsers, PermManageRoles, PermViewMetrics, PermManageServer},
+}
+
+func ValidRole(role string) error {
+	// Checks role is one users can be given
+
+	if _, ok := rolePermissions[role]; !ok {
+		return fmt.Errorf("Unknown role: %s", role)
+	}
+	return nil
+}
+
+func RoleCan(role, permission string) bool {
+	// Reports whether the policy grants role the permission; unknown roles are granted nothing
+
+	return slices.Contains(rolePermissions[role], permission)
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Scopes limit what a token may be used for. Access tokens from a password login carry all of them,
+// personal access tokens only the ones they were created with
+const (
+	ScopeChirpsRead  = "chirps:read"
+	ScopeChirpsWrite = "chirps:write"
+	// ScopeProfileWrite covers the username and who the user follows
+	ScopeProfileWrite = "profile:write"
+	// ScopeAccount covers credentials, sessions and tokens, and is never granted to a personal access token
+	ScopeAccount = "account"
+)
+
+// GrantableScopes are the scopes a personal access token may be created with
+var GrantableScopes = []string{ScopeChirpsRead, ScopeChirpsWrite, ScopeProfileWrite}
+
+// AllScopes are carried by access tokens from a password login
+var AllScopes = append(slices.Clone(GrantableScopes), ScopeAccount)
+
+var ErrInsufficientScope = errors.New("Token lacks the required scope")
+
+func ParseScopes(scopes []string) ([]string, error) {
+	// Checks requested scopes are grantable and returns them sorted without duplicates
+
+	if len(scopes) == 0 {
+		return nil, errors.New("At least one scope is required")
+	}
+	out := make([]string, 0, len(scopes))
+	for _, s := range scopes {
+		if !slices.Contains(GrantableScopes, s) {
+			return nil, fmt.Errorf("Unknown scope: %s", s)
+		}
+		out = append(out, s)
+	}
+	slices.Sort(out)
+	return slices.Compact(out), nil
+}
+
+func HasScope(granted []string, want string) bool {
+	// Reports whether want is among the granted scopes
+
+	return slices.Contains(granted, want)
+}
@@ -0,0 +1,36 @@
+package auth_test
+
+import (
+	"github.com/roxensox/chirpy/internal/auth"
+	"slices"
+	"testing"
+)
+
+func TestParseScopes(t *testing.T) {
+	test_cases := []struct {
+		scopes   []string
+		expected []string
+		wantErr  bool
+	}{
+		{
+			scopes:   []string{"chirps:write", "chirps:read", "chirps:write"},
+			expected: []string{"chirps:read", "chirps:write"},
+		},
+		{scopes: []string{"profile:write"}, expected: []string{"profile:write"}},
+		{scopes: nil, wantErr: true},
+		{scopes: []string{"chirps:delete"}, wantErr: true},
+		// Account management is only for interactive logins
+		{scopes: []string{auth.ScopeAccount}, wantErr: true},
+	}
+
+	for _, c := range test_cases {
+		got, err := auth.ParseScopes(c.scopes)
+		if (err != nil) != c.wantErr {
+			t.Errorf("ParseScopes(%v) error = %v, wantErr %v", c.scopes, err, c.wantErr)
+			continue
+		}
+		if !slices.Equal(got, c.expected) {
+			t.Errorf("ParseScopes(%v) = %v, expected %v", c.scopes, got, c.expected)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// TOTPPeriod is the length of each time step, as recommended by RFC 6238
+	TOTPPeriod = 30 * time.Second
+	// TOTPDigits is the number of digits in each code
+	TOTPDigits = 6
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func GenerateTOTPSecret() (string, error) {
+	// Makes a new random 160-bit secret, base32 encoded the way authenticator apps expect
+
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+func TOTPURI(issuer, account, secret string) string {
+	// Builds the otpauth:// URI authenticator apps scan as a QR code
+
+	label := url.PathEscape(issuer + ":" + account)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("digits", fmt.Sprint(TOTPDigits))
+	params.Set("period", fmt.Sprint(int(TOTPPeriod/time.Second)))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+func TOTPStep(t time.Time) int64 {
+	// Returns the time step t falls in
+
+	return t.Unix() / int64(TOTPPeriod/time.Second)
+}
+
+func TOTPCode(secret string, step int64) (string, error) {
+	// Computes the code for a time step using HOTP (RFC 4226) with HMAC-SHA1
+
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", fmt.Errorf("Invalid TOTP secret")
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation picks 4 bytes based on the low nibble of the last byte
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for range TOTPDigits {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod), nil
+}
+
+func ValidateTOTP(secret, code string, now time.Time, skew int64) (int64, bool) {
+	// Checks code against the current step and skew steps either side, returning the step that matched
+	// so callers can refuse to accept the same step twice
+
+	code = strings.TrimSpace(code)
+	if len(code) != TOTPDigits {
+		return 0, false
+	}
+
+	current := TOTPStep(now)
+	for step := current - skew; step <= current+skew; step++ {
+		expected, err := TOTPCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func MakeRecoveryCodes(n int) ([]string, error) {
+	// Makes n single-use recovery codes formatted like 1a2b-3c4d-5e6f-7a8b
+
+	codes := make([]string, 0, n)
+	for range n {
+		raw := make([]byte, 8)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		hex := fmt.Sprintf("%x", raw)
+		codes = append(codes, hex[0:4]+"-"+hex[4:8]+"-"+hex[8:12]+"-"+hex[12:16])
+	}
+	return codes, nil
+}
+
+func NormalizeRecoveryCode(code string) string {
+	// Strips the separators and case a user might type so codes hash the same way they were issued
+
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != 16 {
+		return code
+	}
+	return code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16]
+}
+
+// MFAChallengeTokenPrefix starts every token handed out between the password and second factor steps of a login
+const MFAChallengeTokenPrefix = "chirpy_mc_"
+
+func MakeMFAChallengeToken() (string, error) {
+	// Returns a new MFA challenge token: the prefix, 32 random bytes in hex, then a CRC32 of both
+
+	return makePrefixedToken(MFAChallengeTokenPrefix)
+}
@@ -0,0 +1,80 @@
+package auth_test
+
+import (
+	"encoding/base32"
+	"github.com/roxensox/chirpy/internal/auth"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// SHA1 vectors from RFC 6238 appendix B, truncated to 6 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	test_cases := []struct {
+		unix     int64
+		expected string
+	}{
+		{unix: 59, expected: "287082"},
+		{unix: 1111111109, expected: "081804"},
+		{unix: 1111111111, expected: "050471"},
+		{unix: 1234567890, expected: "005924"},
+		{unix: 2000000000, expected: "279037"},
+	}
+
+	for _, tc := range test_cases {
+		code, err := auth.TOTPCode(secret, auth.TOTPStep(time.Unix(tc.unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode failed: %v", err)
+		}
+		if code != tc.expected {
+			t.Errorf("At %d: got %s, want %s", tc.unix, code, tc.expected)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret failed: %v", err)
+	}
+	now := time.Now()
+	step := auth.TOTPStep(now)
+	code, _ := auth.TOTPCode(secret, step-1)
+
+	if got, ok := auth.ValidateTOTP(secret, code, now, 1); !ok || got != step-1 {
+		t.Errorf("Code from the previous step should be accepted within skew")
+	}
+	if _, ok := auth.ValidateTOTP(secret, code, now, 0); ok {
+		t.Errorf("Code from the previous step should be rejected without skew")
+	}
+	if _, ok := auth.ValidateTOTP(secret, "12345", now, 1); ok {
+		t.Errorf("Short codes should be rejected")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := auth.MakeRecoveryCodes(10)
+	if err != nil || len(codes) != 10 {
+		t.Fatalf("MakeRecoveryCodes failed: %v", err)
+	}
+	for _, c := range codes {
+		if auth.NormalizeRecoveryCode(" "+c[0:4]+c[5:9]+" "+c[10:14]+c[15:19]+" ") != c {
+			t.Errorf("Normalizing a retyped code should give back %s", c)
+		}
+	}
+}
+
+func TestEncryptSecret(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	sealed, err := auth.EncryptSecret(key, "JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatalf("EncryptSecret failed: %v", err)
+	}
+	if opened, err := auth.DecryptSecret(key, sealed); err != nil || opened != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("Round trip failed: %q, %v", opened, err)
+	}
+	if _, err := auth.DecryptSecret([]byte("fedcba9876543210fedcba9876543210"), sealed); err == nil {
+		t.Errorf("Decrypting with the wrong key should fail")
+	}
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"time"
+)
+
+const (
+	// DefaultIssuer is the iss claim MakeJWT sets and validators require unless configured otherwise
+	DefaultIssuer = "chirpy"
+	// DefaultAudience is the aud claim MakeJWT sets and validators require unless configured otherwise
+	DefaultAudience = "chirpy-api"
+	// MaxClockSkew caps Validator.ClockSkew so a misconfiguration can't keep expired tokens alive
+	MaxClockSkew = 5 * time.Minute
+)
+
+// DefaultAlgorithms are the signing algorithms accepted when a Validator doesn't pin its own
+var DefaultAlgorithms = []string{"EdDSA", "RS256", "HS256"}
+
+// Errors returned by Validator, so handlers can tell callers why their token was rejected
+var (
+	ErrTokenExpired     = errors.New("Token has expired")
+	ErrTokenNotYetValid = errors.New("Token is not valid yet")
+	ErrBadSignature     = errors.New("Token signature is invalid")
+	ErrWrongIssuer      = errors.New("Token was issued by someone else")
+	ErrWrongAudience    = errors.New("Token is not intended for this service")
+	ErrMalformedToken   = errors.New("Token is malformed")
+	ErrTokenRevoked     = errors.New("Token has been revoked")
+)
+
+// Validator checks access tokens against a fixed set of rules
+type Validator struct {
+	Keys        *KeySet
+	Algorithms  []string
+	Issuer      string
+	Audience    string
+	ClockSkew   time.Duration
+	Revocations RevocationChecker
+}
+
+func (v *Validator) Validate(ctx context.Context, tokenString string) (uuid.UUID, error) {
+	// Validates an input token string and returns the token bearer's UUID if it's valid
+
+	clms, err := v.Parse(ctx, tokenString)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	uid, err := uuid.Parse(clms.Subject)
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("%w: bad subject %q", ErrMalformedToken, clms.Subject)
+	}
+	return uid, nil
+}
+
+func (v *Validator) Parse(ctx context.Context, tokenString string) (*AccessClaims, error) {
+	// Validates an input token string and returns its claims, every error wraps one of the Err values above
+
+	// Fills in defaults for anything left unset
+	algorithms := v.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = DefaultAlgorithms
+	}
+	issuer := v.Issuer
+	if issuer == "" {
+		issuer = DefaultIssuer
+	}
+	audience := v.Audience
+	if audience == "" {
+		audience = DefaultAudience
+	}
+	skew := min(max(v.ClockSkew, 0), MaxClockSkew)
+
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(algorithms),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(audience),
+		jwt.WithLeeway(skew),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+
+	out, err := parser.ParseWithClaims(tokenString, &AccessClaims{}, v.keyFunc)
+	if err != nil {
+		return nil, classifyJWTError(err)
+	}
+	clms, ok := out.Claims.(*AccessClaims)
+	if !ok || !out.Valid {
+		return nil, ErrMalformedToken
+	}
+	if v.Revocations == nil {
+		return clms, nil
+	}
+
+	// Rejects tokens issued before the user's last version bump
+	uid, err := uuid.Parse(clms.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad subject %q", ErrMalformedToken, clms.Subject)
+	}
+	version, err := v.Revocations.TokenVersion(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	if clms.Version != version {
+		return nil, ErrTokenRevoked
+	}
+
+	// Rejects individually denylisted tokens
+	denied, err := v.Revocations.IsTokenDenied(ctx, clms.ID)
+	if err != nil {
+		return nil, err
+	}
+	if denied {
+		return nil, ErrTokenRevoked
+	}
+	return clms, nil
+}
+
+func (v *Validator) keyFunc(token *jwt.Token) (any, error) {
+	// Picks the verification key named by the token's kid header
+
+	var key *SigningKey
+	if kid, ok := token.Header["kid"].(string); ok {
+		found, ok := v.Keys.Lookup(kid)
+		if !ok {
+			return nil, fmt.Errorf("Unknown key: %s", kid)
+		}
+		key = found
+	} else {
+		// Tokens issued before key IDs were added can only have been signed with the original key
+		found, err := v.Keys.SigningKey()
+		if err != nil {
+			return nil, err
+		}
+		key = found
+	}
+
+	// The key decides the algorithm, never the token
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("Unexpected signing method: %s", token.Method.Alg())
+	}
+	return key.Public, nil
+}
+
+func classifyJWTError(err error) error {
+	// Maps the jwt library's errors onto the package's own, keeping the original for logging
+
+	var kind error
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		kind = ErrTokenExpired
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		kind = ErrTokenNotYetValid
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		kind = ErrWrongAudience
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		kind = ErrWrongIssuer
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		kind = ErrBadSignature
+	default:
+		kind = ErrMalformedToken
+	}
+	return fmt.Errorf("%w: %v", kind, err)
+}
@@ -0,0 +1,10 @@
+package auth
+
+// EmailVerificationTokenPrefix starts every email verification token
+const EmailVerificationTokenPrefix = "chirpy_ev_"
+
+func MakeEmailVerificationToken() (string, error) {
+	// Returns a new email verification token: the prefix, 32 random bytes in hex, then a CRC32 of both
+
+	return makePrefixedToken(EmailVerificationTokenPrefix)
+}
@@ -0,0 +1,244 @@
+package chirpyserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
+	"github.com/roxensox/chirpy/internal/database"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAPITokenLifetime = 90 * 24 * time.Hour
+	maxAPITokenLifetime     = 365 * 24 * time.Hour
+	// Last-used times are only written this often, so a busy bot doesn't cost a write per request
+	apiTokenTouchInterval = time.Minute
+)
+
+var (
+	errMissingToken = errors.New("Token not found")
+	errUnknownToken = errors.New("Token is not recognised")
+)
+
+func (cfg *ApiConfig) authenticate(req *http.Request, scope string) (Principal, error) {
+	// Identifies the caller from the request's bearer token, which may be an access token or a
+	// personal access token, after checking it carries scope (any scope if it's empty)
+
+	tkn, err := auth.GetBearerToken(req.Header)
+	if err != nil {
+		return Principal{}, errMissingToken
+	}
+
+	// Access tokens from a password login can do anything the user can, ones issued to OAuth clients only what was granted
+	if !auth.IsPersonalAccessToken(tkn) {
+		clms, err := cfg.validator().Parse(req.Context(), tkn)
+		if err != nil {
+			return Principal{}, err
+		}
+		if scope != "" && !auth.HasScope(clms.Scopes(), scope) {
+			return Principal{}, fmt.Errorf("%w: %s", auth.ErrInsufficientScope, scope)
+		}
+		UID, err := uuid.Parse(clms.Subject)
+		if err != nil {
+			return Principal{}, fmt.Errorf("%w: bad subject %q", auth.ErrMalformedToken, clms.Subject)
+		}
+		return Principal{UserID: UID, Scopes: clms.Scopes(), Claims: clms}, nil
+	}
+
+	// Checksum failures are typos or forgeries, neither is worth a lookup
+	if !auth.ValidPersonalAccessTokenFormat(tkn) {
+		return Principal{}, fmt.Errorf("%w: bad checksum", auth.ErrMalformedToken)
+	}
+	pat, err := cfg.DBConn.GetPersonalAccessToken(req.Context(), auth.HashPersonalAccessToken(cfg.TokenHashKey, tkn))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Principal{}, errUnknownToken
+	}
+	if err != nil {
+		return Principal{}, err
+	}
+
+	now := time.Now().UTC()
+	switch {
+	case pat.RevokedAt.Valid:
+		return Principal{}, auth.ErrTokenRevoked
+	case !now.Before(pat.ExpiresAt):
+		return Principal{}, auth.ErrTokenExpired
+	case scope != "" && !auth.HasScope(pat.Scopes, scope):
+		return Principal{}, fmt.Errorf("%w: %s", auth.ErrInsufficientScope, scope)
+	}
+
+	if !pat.LastUsedAt.Valid || now.Sub(pat.LastUsedAt.Time) >= apiTokenTouchInterval {
+		err := cfg.DBConn.TouchPersonalAccessToken(req.Context(), database.TouchPersonalAccessTokenParams{
+			ID:         pat.ID,
+			LastUsedAt: sql.NullTime{Time: now, Valid: true},
+		})
+		if err != nil {
+			log.Printf("Failed to record use of token %s: %v", pat.ID, err)
+		}
+	}
+	return Principal{UserID: pat.UserID, Scopes: pat.Scopes}, nil
+}
+
+func (cfg *ApiConfig) POSTAPITokens(writer http.ResponseWriter, req *http.Request) {
+	// Handles POST requests at the tokens endpoint, mints a personal access token for the caller
+
+	// Routed behind the account scope, which only an interactive login carries, so a leaked token can't be used to make more
+	UID, ok := currentUser(writer, req)
+	if !ok {
+		return
+	}
+
+	inObj := struct {
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		ExpiresIn string   `json:"expires_in"`
+	}{}
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&inObj); err != nil {
+		http.Error(writer, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(inObj.Name)
+	if name == "" || len(name) > 100 {
+		http.Error(writer, "Tokens need a name of at most 100 characters", http.StatusBadRequest)
+		return
+	}
+	scopes, err := auth.ParseScopes(inObj.Scopes)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Tokens always expire, by default after 90 days and never after more than a year
+	lifetime := defaultAPITokenLifetime
+	if inObj.ExpiresIn != "" {
+		lifetime, err = time.ParseDuration(inObj.ExpiresIn)
+		if err != nil || lifetime <= 0 || lifetime > maxAPITokenLifetime {
+			http.Error(writer, "expires_in must be a positive duration of at most 8760h", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tkn, err := auth.MakePersonalAccessToken()
+	if err != nil {
+		http.Error(writer, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	// Only the hash is stored, so this response is the one chance to see the token
+	now := time.Now().UTC()
+	pat, err := cfg.DBConn.CreatePersonalAccessToken(req.Context(), database.CreatePersonalAccessTokenParams{
+		ID:        uuid.New(),
+		UserID:    UID,
+		Name:      name,
+		TokenHash: auth.HashPersonalAccessToken(cfg.TokenHashKey, tkn),
+		Scopes:    scopes,
+		CreatedAt: now,
+		ExpiresAt: now.Add(lifetime),
+	})
+	if err != nil {
+		http.Error(writer, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+	cfg.logSecurityEvent(req.Context(), UID, "api_token_created", pat.ID.String()+" "+strings.Join(scopes, ","))
+
+	out := apiTokenFromDB(pat)
+	out.Token = tkn
+	outJson, err := json.Marshal(out)
+	if err != nil {
+		http.Error(writer, "Failed to marshal data", http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(201)
+	writer.Write(outJson)
+}
+
+func (cfg *ApiConfig) GETAPITokens(writer http.ResponseWriter, req *http.Request) {
+	// Handles GET requests at the tokens endpoint, lists the caller's live personal access tokens
+
+	UID, ok := currentUser(writer, req)
+	if !ok {
+		return
+	}
+
+	rows, err := cfg.DBConn.ListPersonalAccessTokens(req.Context(), database.ListPersonalAccessTokensParams{
+		UserID:    UID,
+		ExpiresAt: time.Now().UTC(),
+	})
+	if err != nil {
+		http.Error(writer, "Unable to get tokens", http.StatusInternalServerError)
+		return
+	}
+
+	out := make([]APIToken, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, apiTokenFromDB(r))
+	}
+
+	outJson, err := json.Marshal(out)
+	if err != nil {
+		http.Error(writer, "Failed to marshal data", http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(200)
+	writer.Write(outJson)
+}
+
+func (cfg *ApiConfig) DELETEAPIToken(writer http.ResponseWriter, req *http.Request) {
+	// Handles DELETE requests at tokens/{id}, revokes one of the caller's personal access tokens
+
+	UID, ok := currentUser(writer, req)
+	if !ok {
+		return
+	}
+
+	tokenID, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		http.Error(writer, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	// Scoped to the caller so other users' tokens can't be touched
+	revoked, err := cfg.DBConn.RevokePersonalAccessToken(req.Context(), database.RevokePersonalAccessTokenParams{
+		ID:        tokenID,
+		UserID:    UID,
+		RevokedAt: sql.NullTime{Time: time.Now().UTC(), Valid: true},
+	})
+	if err != nil {
+		http.Error(writer, "Failed to revoke token", http.StatusInternalServerError)
+		return
+	}
+	if revoked == 0 {
+		http.Error(writer, "Token not found", http.StatusNotFound)
+		return
+	}
+	cfg.logSecurityEvent(req.Context(), UID, "api_token_revoked", tokenID.String())
+
+	writer.WriteHeader(204)
+}
+
+func apiTokenFromDB(pat database.PersonalAccessToken) APIToken {
+	// Converts a stored token to its JSON form, which never includes the secret
+
+	out := APIToken{
+		ID:        pat.ID,
+		Name:      pat.Name,
+		Scopes:    pat.Scopes,
+		CreatedAt: pat.CreatedAt,
+		ExpiresAt: pat.ExpiresAt,
+	}
+	if pat.LastUsedAt.Valid {
+		out.LastUsedAt = &pat.LastUsedAt.Time
+	}
+	return out
+}
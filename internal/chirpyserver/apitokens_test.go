@@ -0,0 +1,96 @@
+package chirpyserver
+
+import (
+	"database/sql/driver"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthenticate(t *testing.T) {
+	key := []byte("token hash key")
+	userID := uuid.New()
+	now := time.Now().UTC()
+
+	keys := auth.NewKeySet()
+	keys.Add(auth.NewHMACKey("default", "secret"))
+	keys.SetSigningKey("default")
+	jwt, err := auth.MakeJWT(userID, 0, keys, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to make JWT: %v", err)
+	}
+
+	// Each personal access token gets its own row, looked up by its keyed hash
+	rows := map[string][]driver.Value{}
+	mint := func(scopes string, expiresAt time.Time, revokedAt any) string {
+		tkn, err := auth.MakePersonalAccessToken()
+		if err != nil {
+			t.Fatalf("Failed to make token: %v", err)
+		}
+		rows[auth.HashPersonalAccessToken(key, tkn)] = []driver.Value{
+			uuid.NewString(), userID.String(), "bot", "hash", scopes, now, expiresAt, nil, revokedAt,
+		}
+		return tkn
+	}
+	writer := mint("{chirps:read,chirps:write}", now.Add(time.Hour), nil)
+	expired := mint("{chirps:read}", now.Add(-time.Hour), nil)
+	revoked := mint("{chirps:read}", now.Add(time.Hour), now)
+	unknown, _ := auth.MakePersonalAccessToken()
+
+	_, conn := newFakeDB(map[string]fakeQuery{
+		"GetPersonalAccessToken": func(args []driver.NamedValue) (fakeResult, error) {
+			res := fakeResult{columns: []string{
+				"id", "user_id", "name", "token_hash", "scopes", "created_at", "expires_at", "last_used_at", "revoked_at",
+			}}
+			if row, ok := rows[args[0].Value.(string)]; ok {
+				res.rows = [][]driver.Value{row}
+			}
+			return res, nil
+		},
+		"TouchPersonalAccessToken": returns(fakeResult{affected: 1}),
+	})
+	cfg := &ApiConfig{DBConn: conn, Keys: keys, TokenHashKey: key}
+
+	test_cases := []struct {
+		name   string
+		header string
+		scope  string
+		err    error
+	}{
+		{name: "JWT carries every scope", header: "Bearer " + jwt, scope: auth.ScopeAccount},
+		{name: "granted scope", header: "Bearer " + writer, scope: auth.ScopeChirpsWrite},
+		{name: "missing scope", header: "Bearer " + writer, scope: auth.ScopeProfileWrite, err: auth.ErrInsufficientScope},
+		{name: "never account scope", header: "Bearer " + writer, scope: auth.ScopeAccount, err: auth.ErrInsufficientScope},
+		{name: "expired", header: "Bearer " + expired, scope: auth.ScopeChirpsRead, err: auth.ErrTokenExpired},
+		{name: "revoked", header: "Bearer " + revoked, scope: auth.ScopeChirpsRead, err: auth.ErrTokenRevoked},
+		{name: "unknown", header: "Bearer " + unknown, scope: auth.ScopeChirpsRead, err: errUnknownToken},
+		{name: "bad checksum", header: "Bearer " + strings.TrimSuffix(writer, writer[len(writer)-1:]) + "x", scope: auth.ScopeChirpsRead, err: auth.ErrMalformedToken},
+		{name: "no token", scope: auth.ScopeChirpsRead, err: errMissingToken},
+	}
+
+	for _, c := range test_cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if c.header != "" {
+				req.Header.Set("Authorization", c.header)
+			}
+			p, err := cfg.authenticate(req, c.scope)
+			if c.err != nil {
+				if !errors.Is(err, c.err) {
+					t.Errorf("Expected %v, got %v", c.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if p.UserID != userID {
+				t.Errorf("Expected user %s, got %s", userID, p.UserID)
+			}
+		})
+	}
+}
@@ -1,44 +1,165 @@
 package chirpyserver
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"github.com/google/uuid"
-	"github.com/roxensox/chirpy/internal/auth"
 	"github.com/roxensox/chirpy/internal/database"
-	"log"
 	"net/http"
-	"sort"
 	"time"
 )
 
+// errUserNotFound marks a chirp insert rejected because its author no longer exists
+var errUserNotFound = errors.New("User not found")
+
+func chirpFromDB(c database.Chirp) Chirp {
+	// Casts a database chirp to the output object with appropriate JSON fields
+
+	out := Chirp{
+		ID:        c.ID,
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+		Body:      c.Body,
+		UserID:    c.UserID,
+		Deleted:   c.DeletedAt.Valid,
+		LikeCount: c.LikeCount,
+		Entities:  extractEntities(c.Body),
+	}
+	if c.InReplyTo.Valid {
+		out.InReplyTo = &c.InReplyTo.UUID
+	}
+
+	// Deleted chirps only appear as tombstones holding their place in a thread
+	if out.Deleted {
+		out.Body = ""
+		out.Entities = []ChirpEntity{}
+	}
+	return out
+}
+
+func (cfg *ApiConfig) decorateChirps(req *http.Request, groups ...[]Chirp) error {
+	// Fills in the per-request details of each chirp: mention links and, for logged in callers, likes
+
+	if err := cfg.resolveMentions(req.Context(), groups...); err != nil {
+		return err
+	}
+	if viewer, ok := viewer(req); ok {
+		return cfg.markLikedByMe(req.Context(), viewer, groups...)
+	}
+	return nil
+}
+
+func (cfg *ApiConfig) chirpPage(req *http.Request, chirps []database.Chirp, limit int) ([]Chirp, string, error) {
+	// Trims the extra row fetched by the caller and decorates the chirps, returning them with the next page's cursor
+
+	nextCursor := ""
+	if len(chirps) > limit {
+		chirps = chirps[:limit]
+		last := chirps[len(chirps)-1]
+		nextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+
+	out := make([]Chirp, 0, len(chirps))
+	for _, c := range chirps {
+		out = append(out, chirpFromDB(c))
+	}
+
+	if err := cfg.decorateChirps(req, out); err != nil {
+		return nil, "", err
+	}
+	return out, nextCursor, nil
+}
+
+func (cfg *ApiConfig) writeChirpPage(writer http.ResponseWriter, req *http.Request, chirps []database.Chirp, limit int) {
+	// Writes one page of chirps wrapped with its next cursor
+
+	out, nextCursor, err := cfg.chirpPage(req, chirps, limit)
+	if err != nil {
+		http.Error(writer, "Unable to get chirp details", http.StatusInternalServerError)
+		return
+	}
+
+	outJson, err := json.Marshal(ChirpPage{
+		Chirps:     out,
+		NextCursor: nextCursor,
+	})
+	if err != nil {
+		http.Error(writer, "Failed to marshal data", http.StatusInternalServerError)
+		return
+	}
+
+	writer.WriteHeader(200)
+	writer.Write(outJson)
+}
+
 func (cfg *ApiConfig) POSTChirps(writer http.ResponseWriter, req *http.Request) {
 	// Handles a POST request to chirps endpoint, returns newly created chirp
 
 	// Creates anonymous struct for receiving input
 	inObj := struct {
-		Body   string `json:"body"`
-		UserID string `json:"user_id"`
+		Body      string `json:"body"`
+		UserID    string `json:"user_id"`
+		InReplyTo string `json:"in_reply_to"`
 	}{}
 
-	tkn, err := auth.GetBearerToken(req.Header)
-	if err != nil {
-		writer.WriteHeader(401)
-		writer.Write([]byte("Must be logged in"))
+	// Gets the caller's ID; RequireAuth has already checked their token's scope
+	UID, ok := currentUser(writer, req)
+	if !ok {
 		return
 	}
 
-	UID, err := auth.ValidateJWT(tkn, cfg.Secret)
-	if err != nil {
-		writer.WriteHeader(401)
-		writer.Write([]byte("Unauthorized"))
-		log.Println(err)
-		log.Println(tkn)
-		return
+	// Holds back unverified accounts if the server requires verification
+	if cfg.RequireVerifiedEmail {
+		verified, err := cfg.emailVerified(req.Context(), UID)
+		if err != nil {
+			writer.WriteHeader(500)
+			writer.Write([]byte("Failed to look up user"))
+			return
+		}
+		if !verified {
+			writer.WriteHeader(403)
+			writer.Write([]byte("Verify your email address before posting"))
+			return
+		}
 	}
 
 	// Creates a JSON decoder for the request and decodes it into inObj
 	decoder := json.NewDecoder(req.Body)
-	decoder.Decode(&inObj)
+	if err := decoder.Decode(&inObj); err != nil {
+		writeValidationError(writer, &chirpValidationError{
+			Code:    "malformed",
+			Message: "request body must be JSON",
+		})
+		return
+	}
+
+	// Validates the body and censors profanity before anything is stored
+	cleanedBody, err := validateChirpBody(inObj.Body, cfg.chirpMaxLength(req.Context(), UID), cfg.Moderation)
+	if err != nil {
+		writeValidationError(writer, err)
+		return
+	}
+
+	// Resolves the parent chirp if this chirp is a reply
+	var parentID uuid.NullUUID
+	if inObj.InReplyTo != "" {
+		parentUUID, err := uuid.Parse(inObj.InReplyTo)
+		if err != nil {
+			writer.WriteHeader(400)
+			writer.Write([]byte("Invalid in_reply_to ID"))
+			return
+		}
+
+		// Deleted chirps keep their place in a thread but can't gain new replies
+		parent, err := cfg.DBConn.GetExactChirp(req.Context(), parentUUID)
+		if err != nil {
+			writer.WriteHeader(404)
+			writer.Write([]byte("Parent chirp not found"))
+			return
+		}
+		parentID = uuid.NullUUID{UUID: parent.ID, Valid: true}
+	}
 
 	chirpID, err := uuid.NewUUID()
 	if err != nil {
@@ -50,28 +171,42 @@ func (cfg *ApiConfig) POSTChirps(writer http.ResponseWriter, req *http.Request)
 	// Builds query param object
 	params := database.CreateChirpParams{
 		UserID:    UID,
-		Body:      inObj.Body,
+		Body:      cleanedBody,
 		CreatedAt: time.Now().UTC(),
 		UpdatedAt: time.Now().UTC(),
 		ID:        chirpID,
+		InReplyTo: parentID,
 	}
 
-	// Queries the database to insert parameters
-	dbResp, err := cfg.DBConn.CreateChirp(req.Context(), params)
-	if err != nil {
+	// Inserts the chirp and its hashtags and mentions together so a failure never leaves a chirp missing from tag and mention feeds
+	var outObj Chirp
+	err = cfg.inTx(req.Context(), func(q *database.Queries) error {
+		dbResp, err := q.CreateChirp(req.Context(), params)
+		if err != nil {
+			return errUserNotFound
+		}
+		outObj = chirpFromDB(dbResp)
+		return storeChirpEntities(req.Context(), q, dbResp.ID, outObj.Entities)
+	})
+	if errors.Is(err, errUserNotFound) {
 		writer.WriteHeader(404)
 		writer.Write([]byte("User not found"))
 		return
 	}
+	if err != nil {
+		writer.WriteHeader(500)
+		writer.Write([]byte("Failed to store chirp"))
+		return
+	}
 
-	// Casts response to output object
-	outObj := Chirp{
-		ID:        dbResp.ID,
-		CreatedAt: dbResp.CreatedAt,
-		UpdatedAt: dbResp.UpdatedAt,
-		Body:      dbResp.Body,
-		UserID:    dbResp.UserID,
+	// Fills in mention links for the response
+	decorated := []Chirp{outObj}
+	if err := cfg.resolveMentions(req.Context(), decorated); err != nil {
+		writer.WriteHeader(500)
+		writer.Write([]byte("Failed to get chirp details"))
+		return
 	}
+	outObj = decorated[0]
 
 	// Marshals output object to JSON
 	outJson, err := json.Marshal(outObj)
@@ -87,22 +222,30 @@ func (cfg *ApiConfig) POSTChirps(writer http.ResponseWriter, req *http.Request)
 }
 
 func (cfg *ApiConfig) GETChirps(writer http.ResponseWriter, req *http.Request) {
-	// Handles a GET request to the chirps endpoint, returns all chirps
+	// Handles a GET request to the chirps endpoint, returns one page of chirps
 
 	// Sets content type in header
 	writer.Header().Set("Content-Type", "application/json")
 
-	// Initializes an empty slice of Chirp objects
-	out := make([]Chirp, 0)
+	// Reads the page size and starting cursor from the query
+	limit, cursor, err := parsePageParams(req.URL.Query())
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cursorTime, cursorID := cursor.nullParams()
 
 	// Gets the author ID as a string from the query, if there is one
 	auth_id := req.URL.Query().Get("author_id")
+	desc := req.URL.Query().Get("sort") == "desc"
 
 	// Declares variables used in conditional scope
 	var allChirps []database.Chirp
-	var err error
 	var auth_uuid uuid.UUID
 
+	// Fetches one extra row to find out whether another page follows
+	fetchLimit := limit + 1
+
 	// Proceeds as usual if no ID is provided
 	if auth_id != "" {
 		// Parses author ID to UUID
@@ -112,50 +255,35 @@ func (cfg *ApiConfig) GETChirps(writer http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		allChirps, err = cfg.DBConn.GetChirpsByAuthor(req.Context(), auth_uuid)
-		if err != nil {
-			http.Error(writer, "Unable to get chirps", http.StatusInternalServerError)
-			return
+		params := database.GetChirpsByAuthorPageParams{
+			UserID:          auth_uuid,
+			CursorCreatedAt: cursorTime,
+			CursorID:        cursorID,
+			PageLimit:       fetchLimit,
+		}
+		if desc {
+			allChirps, err = cfg.DBConn.GetChirpsByAuthorPageDesc(req.Context(), database.GetChirpsByAuthorPageDescParams(params))
+		} else {
+			allChirps, err = cfg.DBConn.GetChirpsByAuthorPage(req.Context(), params)
 		}
 	} else {
-		// Queries the DB with nullable auth_uuid
-		allChirps, err = cfg.DBConn.GetChirps(req.Context())
-		if err != nil {
-			http.Error(writer, "Unable to get chirps", http.StatusInternalServerError)
-			return
+		params := database.GetChirpsPageParams{
+			CursorCreatedAt: cursorTime,
+			CursorID:        cursorID,
+			PageLimit:       fetchLimit,
 		}
-	}
-
-	if req.URL.Query().Get("sort") == "desc" {
-		sort.Slice(allChirps, func(i, j int) bool {
-			return allChirps[i].CreatedAt.After(allChirps[j].CreatedAt)
-		})
-	}
-
-	// Iterates through the returned chirps
-	for _, c := range allChirps {
-		// Casts the db chirps to output object with appropriate JSON fields
-		ctoJSON := Chirp{
-			ID:        c.ID,
-			CreatedAt: c.UpdatedAt,
-			UpdatedAt: c.UpdatedAt,
-			Body:      c.Body,
-			UserID:    c.UserID,
+		if desc {
+			allChirps, err = cfg.DBConn.GetChirpsPageDesc(req.Context(), database.GetChirpsPageDescParams(params))
+		} else {
+			allChirps, err = cfg.DBConn.GetChirpsPage(req.Context(), params)
 		}
-		// Adds the chirp to out slice
-		out = append(out, ctoJSON)
 	}
-
-	// Marshals the out slice to JSON
-	outJson, err := json.Marshal(out)
 	if err != nil {
-		http.Error(writer, "Failed to marshal data", http.StatusInternalServerError)
+		http.Error(writer, "Unable to get chirps", http.StatusInternalServerError)
 		return
 	}
 
-	// Writes http response
-	writer.WriteHeader(200)
-	writer.Write(outJson)
+	cfg.writeChirpPage(writer, req, allChirps, int(limit))
 }
 
 func (cfg *ApiConfig) GETChirpByID(writer http.ResponseWriter, req *http.Request) {
@@ -181,13 +309,16 @@ func (cfg *ApiConfig) GETChirpByID(writer http.ResponseWriter, req *http.Request
 	}
 
 	// Casts the db response to a Chirp object for JSON marshaling
-	out := Chirp{
-		ID:        dbResp.ID,
-		CreatedAt: dbResp.CreatedAt,
-		UpdatedAt: dbResp.UpdatedAt,
-		Body:      dbResp.Body,
-		UserID:    dbResp.UserID,
+	out := chirpFromDB(dbResp)
+
+	// Fills in mention links and whether the caller has liked the chirp
+	decorated := []Chirp{out}
+	if err := cfg.decorateChirps(req, decorated); err != nil {
+		writer.WriteHeader(500)
+		writer.Write([]byte("Failed to get chirp details"))
+		return
 	}
+	out = decorated[0]
 
 	// Marshals chirp to JSON
 	outJson, err := json.Marshal(out)
@@ -224,31 +355,30 @@ func (cfg *ApiConfig) DELETEChirpByID(writer http.ResponseWriter, req *http.Requ
 		return
 	}
 
-	// Gets the access token from the request header
-	tkn, err := auth.GetBearerToken(req.Header)
-	if err != nil {
-		writer.WriteHeader(401)
-		writer.Write([]byte("Token not found"))
+	// Gets the caller's ID; RequireAuth has already checked their token's scope
+	UID, ok := currentUser(writer, req)
+	if !ok {
 		return
 	}
 
-	// Gets the user's ID by validating the token
-	UID, err := auth.ValidateJWT(tkn, cfg.Secret)
+	// Only the author or a moderator may delete the chirp
+	allowed, err := cfg.canModerate(req.Context(), UID, chirp.UserID)
 	if err != nil {
-		writer.WriteHeader(401)
-		writer.Write([]byte("Invalid token"))
+		writer.WriteHeader(500)
+		writer.Write([]byte("Unable to check permissions"))
 		return
 	}
-
-	// Compares the chirp's user ID with the token's
-	if chirp.UserID != UID {
+	if !allowed {
 		writer.WriteHeader(403)
 		writer.Write([]byte("Unauthorized"))
 		return
 	}
 
-	// Deletes the chirp
-	err = cfg.DBConn.DeleteChirp(req.Context(), CID)
+	// Marks the chirp deleted; it can be restored until the purge removes it
+	err = cfg.DBConn.SoftDeleteChirp(req.Context(), database.SoftDeleteChirpParams{
+		ID:        CID,
+		DeletedAt: sql.NullTime{Time: time.Now().UTC(), Valid: true},
+	})
 	if err != nil {
 		writer.WriteHeader(500)
 		writer.Write([]byte("Failed to delete chirp"))
@@ -0,0 +1,105 @@
+package chirpyserver
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
+	"github.com/roxensox/chirpy/internal/database"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPOSTChirpsEntities(t *testing.T) {
+	userID := uuid.New()
+	run := func(tagErr error) (int, *fakeDB) {
+		fake, conn := newFakeDB(map[string]fakeQuery{
+			"GetUserByID": returns(userResult(database.User{ID: userID, Role: auth.RoleUser})),
+			"CreateChirp": func(args []driver.NamedValue) (fakeResult, error) {
+				now := time.Now().UTC()
+				return chirpResult(database.Chirp{ID: uuid.New(), CreatedAt: now, UpdatedAt: now, Body: args[3].Value.(string), UserID: userID}), nil
+			},
+			"AddChirpTag": func([]driver.NamedValue) (fakeResult, error) {
+				return fakeResult{affected: 1}, tagErr
+			},
+			"GetChirpMentions": returns(fakeResult{columns: []string{"chirp_id", "username", "user_id"}}),
+		})
+		cfg := &ApiConfig{DBConn: conn, DB: fake.sqlDB}
+
+		req := httptest.NewRequest("POST", "/api/chirps", strings.NewReader(`{"body": "hello #gophers"}`))
+		req = req.WithContext(WithPrincipal(context.Background(), Principal{UserID: userID, Scopes: auth.AllScopes}))
+		rec := httptest.NewRecorder()
+		cfg.POSTChirps(rec, req)
+		return rec.Code, fake
+	}
+
+	code, fake := run(nil)
+	if code != 201 {
+		t.Errorf("Expected 201, got %d", code)
+	}
+	if commits, _ := fake.txCounts(); commits != 1 {
+		t.Errorf("Expected the chirp and its tags to commit together, got %d commits", commits)
+	}
+
+	// A chirp whose tags can't be stored isn't kept without them
+	code, fake = run(errors.New("connection reset"))
+	if code != 500 {
+		t.Errorf("Expected 500 when tags can't be stored, got %d", code)
+	}
+	if commits, rollbacks := fake.txCounts(); commits != 0 || rollbacks != 1 {
+		t.Errorf("Expected the chirp to roll back, got %d commits and %d rollbacks", commits, rollbacks)
+	}
+}
+
+func TestDELETEChirpByID(t *testing.T) {
+	author, other := uuid.New(), uuid.New()
+	chirp := database.Chirp{ID: uuid.New(), CreatedAt: time.Now().UTC(), UpdatedAt: time.Now().UTC(), Body: "hi", UserID: author}
+
+	var deleted bool
+	_, conn := newFakeDB(map[string]fakeQuery{
+		"GetExactChirp": func(args []driver.NamedValue) (fakeResult, error) {
+			if args[0].Value == chirp.ID.String() {
+				return chirpResult(chirp), nil
+			}
+			return chirpResult(), nil
+		},
+		"GetUserRole": returns(fakeResult{columns: []string{"role"}, rows: [][]driver.Value{{auth.RoleUser}}}),
+		"SoftDeleteChirp": func([]driver.NamedValue) (fakeResult, error) {
+			deleted = true
+			return fakeResult{affected: 1}, nil
+		},
+	})
+	cfg := &ApiConfig{DBConn: conn}
+
+	test_cases := []struct {
+		name    string
+		caller  uuid.UUID
+		chirpID uuid.UUID
+		status  int
+	}{
+		{name: "author", caller: author, chirpID: chirp.ID, status: 204},
+		{name: "someone else", caller: other, chirpID: chirp.ID, status: 403},
+		{name: "missing chirp", caller: author, chirpID: uuid.New(), status: 404},
+	}
+
+	for _, c := range test_cases {
+		t.Run(c.name, func(t *testing.T) {
+			deleted = false
+			req := httptest.NewRequest("DELETE", "/api/chirps/"+c.chirpID.String(), nil)
+			req.SetPathValue("chirpID", c.chirpID.String())
+			req = req.WithContext(WithPrincipal(req.Context(), Principal{UserID: c.caller, Scopes: auth.AllScopes}))
+			rec := httptest.NewRecorder()
+			cfg.DELETEChirpByID(rec, req)
+
+			if rec.Code != c.status {
+				t.Errorf("Expected status %d, got %d", c.status, rec.Code)
+			}
+			if deleted != (c.status == 204) {
+				t.Errorf("Expected deleted to be %v", c.status == 204)
+			}
+		})
+	}
+}
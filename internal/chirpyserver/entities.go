@@ -0,0 +1,210 @@
+package chirpyserver
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/database"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+func isEntityRune(r rune) bool {
+	// Reports whether r may appear in a hashtag or username
+
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func extractEntities(body string) []ChirpEntity {
+	// Finds #hashtags and @mentions in a chirp body
+	// Offsets are counted in runes, start inclusive and end exclusive
+
+	entities := make([]ChirpEntity, 0)
+	runes := []rune(body)
+
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '#' && runes[i] != '@' {
+			continue
+		}
+
+		// A sigil glued to a preceding word (like an email address) isn't an entity
+		if i > 0 && isEntityRune(runes[i-1]) {
+			continue
+		}
+
+		// Consumes the run of entity characters after the sigil
+		end := i + 1
+		for end < len(runes) && isEntityRune(runes[end]) {
+			end++
+		}
+		if end == i+1 {
+			continue
+		}
+
+		entityType := "hashtag"
+		if runes[i] == '@' {
+			entityType = "mention"
+		}
+		entities = append(entities, ChirpEntity{
+			Type:  entityType,
+			Text:  strings.ToLower(string(runes[i+1 : end])),
+			Start: i,
+			End:   end,
+		})
+		i = end - 1
+	}
+
+	return entities
+}
+
+func storeChirpEntities(ctx context.Context, q *database.Queries, chirpID uuid.UUID, entities []ChirpEntity) error {
+	// Records a new chirp's hashtags and resolves its mentions to users, using q so callers can run it inside their transaction
+
+	usernames := make([]string, 0)
+	for _, e := range entities {
+		if e.Type == "hashtag" {
+			err := q.AddChirpTag(ctx, database.AddChirpTagParams{
+				ChirpID: chirpID,
+				Tag:     e.Text,
+			})
+			if err != nil {
+				return err
+			}
+		} else {
+			usernames = append(usernames, e.Text)
+		}
+	}
+
+	if len(usernames) == 0 {
+		return nil
+	}
+
+	// Looks up every mentioned username at once; unknown names are left unlinked
+	users, err := q.GetUsersByUsernames(ctx, usernames)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		err := q.AddChirpMention(ctx, database.AddChirpMentionParams{
+			ChirpID:  chirpID,
+			UserID:   u.ID,
+			Username: u.Username.String,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cfg *ApiConfig) resolveMentions(ctx context.Context, groups ...[]Chirp) error {
+	// Fills in the user ID of each mention entity from the mentions recorded when the chirp was posted
+
+	ids := make([]uuid.UUID, 0)
+	for _, chirps := range groups {
+		for _, c := range chirps {
+			ids = append(ids, c.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	mentions, err := cfg.DBConn.GetChirpMentions(ctx, ids)
+	if err != nil {
+		return err
+	}
+	if len(mentions) == 0 {
+		return nil
+	}
+
+	// Indexes the mentions by chirp, then by username
+	byChirp := make(map[uuid.UUID]map[string]uuid.UUID)
+	for _, m := range mentions {
+		if byChirp[m.ChirpID] == nil {
+			byChirp[m.ChirpID] = make(map[string]uuid.UUID)
+		}
+		byChirp[m.ChirpID][m.Username] = m.UserID
+	}
+
+	for _, chirps := range groups {
+		for i := range chirps {
+			users := byChirp[chirps[i].ID]
+			for j, e := range chirps[i].Entities {
+				if userID, ok := users[e.Text]; ok && e.Type == "mention" {
+					chirps[i].Entities[j].UserID = &userID
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (cfg *ApiConfig) GETTagChirps(writer http.ResponseWriter, req *http.Request) {
+	// Handles GET requests at tags/{tag}/chirps, returns one page of chirps with the tag, newest first
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	// Normalizes the tag the same way extractEntities does, allowing a leading #
+	tag := strings.ToLower(strings.TrimPrefix(req.PathValue("tag"), "#"))
+	if tag == "" {
+		http.Error(writer, "Invalid tag", http.StatusBadRequest)
+		return
+	}
+
+	// Reads the page size and starting cursor from the query
+	limit, cursor, err := parsePageParams(req.URL.Query())
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cursorTime, cursorID := cursor.nullParams()
+
+	// Fetches one extra row to find out whether another page follows
+	chirps, err := cfg.DBConn.GetChirpsByTag(req.Context(), database.GetChirpsByTagParams{
+		Tag:             tag,
+		CursorCreatedAt: cursorTime,
+		CursorID:        cursorID,
+		PageLimit:       limit + 1,
+	})
+	if err != nil {
+		http.Error(writer, "Unable to get chirps", http.StatusInternalServerError)
+		return
+	}
+
+	cfg.writeChirpPage(writer, req, chirps, int(limit))
+}
+
+func (cfg *ApiConfig) GETUserMentions(writer http.ResponseWriter, req *http.Request) {
+	// Handles GET requests at users/{userID}/mentions, returns one page of chirps mentioning the user, newest first
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	userID, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		http.Error(writer, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	// Reads the page size and starting cursor from the query
+	limit, cursor, err := parsePageParams(req.URL.Query())
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cursorTime, cursorID := cursor.nullParams()
+
+	// Fetches one extra row to find out whether another page follows
+	chirps, err := cfg.DBConn.GetChirpsMentioningUser(req.Context(), database.GetChirpsMentioningUserParams{
+		UserID:          userID,
+		CursorCreatedAt: cursorTime,
+		CursorID:        cursorID,
+		PageLimit:       limit + 1,
+	})
+	if err != nil {
+		http.Error(writer, "Unable to get chirps", http.StatusInternalServerError)
+		return
+	}
+
+	cfg.writeChirpPage(writer, req, chirps, int(limit))
+}
@@ -0,0 +1,50 @@
+package chirpyserver
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractEntities(t *testing.T) {
+	test_cases := []struct {
+		body     string
+		expected []ChirpEntity
+	}{
+		{
+			body:     "no entities here",
+			expected: []ChirpEntity{},
+		},
+		{
+			body: "#Go is great @Pippin",
+			expected: []ChirpEntity{
+				{Type: "hashtag", Text: "go", Start: 0, End: 3},
+				{Type: "mention", Text: "pippin", Start: 13, End: 20},
+			},
+		},
+		// Email addresses and bare sigils aren't entities
+		{
+			body:     "mail me@example.com # @ !",
+			expected: []ChirpEntity{},
+		},
+		// Offsets count runes, not bytes
+		{
+			body: "héllo #café!",
+			expected: []ChirpEntity{
+				{Type: "hashtag", Text: "café", Start: 6, End: 11},
+			},
+		},
+		{
+			body: "#a#b",
+			expected: []ChirpEntity{
+				{Type: "hashtag", Text: "a", Start: 0, End: 2},
+			},
+		},
+	}
+
+	for i, tc := range test_cases {
+		out := extractEntities(tc.body)
+		if !reflect.DeepEqual(out, tc.expected) {
+			t.Errorf("Case #%d: expected %+v, got %+v", i, tc.expected, out)
+		}
+	}
+}
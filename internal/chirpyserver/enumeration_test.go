@@ -0,0 +1,170 @@
+package chirpyserver
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/roxensox/chirpy/internal/auth"
+	"github.com/roxensox/chirpy/internal/database"
+	"github.com/roxensox/chirpy/internal/mail"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingSender struct {
+	mu   sync.Mutex
+	sent []mail.Message
+}
+
+func (s *recordingSender) Send(_ context.Context, msg mail.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+// TestNoAccountEnumeration checks that login, signup and password reset give the same answer whether
+// or not the email belongs to an account
+func TestNoAccountEnumeration(t *testing.T) {
+	hash, err := auth.HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	existing := database.User{
+		ID:             uuid.New(),
+		CreatedAt:      time.Now().UTC(),
+		UpdatedAt:      time.Now().UTC(),
+		Email:          "taken@example.com",
+		HashedPassword: hash,
+	}
+	exec := returns(fakeResult{affected: 1})
+
+	test_cases := []struct {
+		name    string
+		handler func(cfg *ApiConfig) http.HandlerFunc
+		body    string
+		// Queries for the email with no account and for the one with an account
+		missing, present map[string]fakeQuery
+		// Emails each side should send
+		missingMail, presentMail int
+		// Status both sides should get
+		status int
+		// JSON fields that hold per-request values, like a new account's ID, and so differ anyway
+		varies []string
+	}{
+		{
+			name:    "login",
+			status:  401,
+			handler: func(cfg *ApiConfig) http.HandlerFunc { return cfg.POSTLogin },
+			body:    `{"email": "taken@example.com", "password": "wrong"}`,
+			missing: map[string]fakeQuery{
+				"GetUserByEmail": returns(userResult()),
+			},
+			present: map[string]fakeQuery{
+				"GetUserByEmail": returns(userResult(existing)),
+				"ClaimLoginAttempt": returns(fakeResult{
+					columns: []string{"failed_logins"},
+					rows:    [][]driver.Value{{int64(1)}},
+				}),
+			},
+		},
+		{
+			name:    "signup",
+			status:  201,
+			handler: func(cfg *ApiConfig) http.HandlerFunc { return cfg.POSTUsers },
+			body:    `{"email": "taken@example.com", "password": "hunter22"}`,
+			missing: map[string]fakeQuery{
+				"CreateUser": returns(fakeResult{
+					columns: []string{"id", "created_at", "updated_at", "email", "is_chirpy_red"},
+					rows: [][]driver.Value{{
+						existing.ID.String(), existing.CreatedAt, existing.UpdatedAt, existing.Email, false,
+					}},
+				}),
+				"CreateEmailVerificationToken": exec,
+			},
+			present: map[string]fakeQuery{
+				"CreateUser": fails(&pq.Error{Code: "23505", Message: "duplicate key value violates unique constraint"}),
+			},
+			missingMail: 1,
+			presentMail: 1,
+			varies:      []string{"id", "created_at", "updated_at"},
+		},
+		{
+			name:    "password reset",
+			status:  202,
+			handler: func(cfg *ApiConfig) http.HandlerFunc { return cfg.POSTPasswordReset },
+			body:    `{"email": "taken@example.com"}`,
+			missing: map[string]fakeQuery{
+				"GetUserByEmail": returns(userResult()),
+			},
+			present: map[string]fakeQuery{
+				"GetUserByEmail":           returns(userResult(existing)),
+				"CreatePasswordResetToken": exec,
+				"AddSecurityEvent":         exec,
+			},
+			presentMail: 1,
+		},
+	}
+
+	for _, c := range test_cases {
+		t.Run(c.name, func(t *testing.T) {
+			serve := func(queries map[string]fakeQuery, wantMail int) *httptest.ResponseRecorder {
+				_, conn := newFakeDB(queries)
+				sender := &recordingSender{}
+				cfg := &ApiConfig{DBConn: conn, Mailer: sender}
+
+				req := httptest.NewRequest("POST", "/", strings.NewReader(c.body))
+				rec := httptest.NewRecorder()
+				c.handler(cfg)(rec, req)
+
+				cfg.background.Wait()
+				if len(sender.sent) != wantMail {
+					t.Errorf("Sent %d emails, expected %d", len(sender.sent), wantMail)
+				}
+				return rec
+			}
+
+			missing := serve(c.missing, c.missingMail)
+			present := serve(c.present, c.presentMail)
+			if missing.Code != c.status {
+				t.Errorf("Expected status %d, got %d", c.status, missing.Code)
+			}
+			if missing.Code != present.Code {
+				t.Errorf("Status differs: %d without an account, %d with one", missing.Code, present.Code)
+			}
+			if !sameBody(missing.Body.Bytes(), present.Body.Bytes(), c.varies) {
+				t.Errorf("Body differs: %q without an account, %q with one", missing.Body, present.Body)
+			}
+			if !reflect.DeepEqual(missing.Header(), present.Header()) {
+				t.Errorf("Headers differ: %v without an account, %v with one", missing.Header(), present.Header())
+			}
+		})
+	}
+}
+
+func sameBody(a, b []byte, varies []string) bool {
+	// Compares two response bodies, ignoring the listed fields if both are JSON objects
+
+	var objA, objB map[string]any
+	if json.Unmarshal(a, &objA) != nil || json.Unmarshal(b, &objB) != nil {
+		return bytes.Equal(a, b)
+	}
+	for _, field := range varies {
+		_, inA := objA[field]
+		_, inB := objB[field]
+		if inA != inB {
+			return false
+		}
+		delete(objA, field)
+		delete(objB, field)
+	}
+	return reflect.DeepEqual(objA, objB)
+}
@@ -0,0 +1,185 @@
+package chirpyserver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/roxensox/chirpy/internal/database"
+	"io"
+	"strings"
+	"sync"
+)
+
+// fakeResult is what a fake query hands back: rows for queries, a count for execs
+type fakeResult struct {
+	columns  []string
+	rows     [][]driver.Value
+	affected int64
+}
+
+// fakeQuery answers one sqlc query given its arguments
+type fakeQuery func(args []driver.NamedValue) (fakeResult, error)
+
+// fakeDB is an in-memory database/sql driver that answers sqlc queries by name, so handlers
+// can be tested without Postgres
+type fakeDB struct {
+	mu        sync.Mutex
+	queries   map[string]fakeQuery
+	sqlDB     *sql.DB
+	commits   int
+	rollbacks int
+}
+
+func newFakeDB(queries map[string]fakeQuery) (*fakeDB, *database.Queries) {
+	// Builds a fake database and the sqlc queries that talk to it; the *sql.DB is in sqlDB for transactions
+
+	db := &fakeDB{queries: queries}
+	db.sqlDB = sql.OpenDB(db)
+	return db, database.New(db.sqlDB)
+}
+
+func (db *fakeDB) txCounts() (int, int) {
+	// Returns how many transactions were committed and rolled back
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commits, db.rollbacks
+}
+
+func (db *fakeDB) run(query string, args []driver.NamedValue) (fakeResult, error) {
+	// Dispatches a query on the name in its "-- name: X :kind" header
+
+	name := query
+	if rest, ok := strings.CutPrefix(query, "-- name: "); ok {
+		name, _, _ = strings.Cut(rest, " ")
+	}
+
+	db.mu.Lock()
+	handler, ok := db.queries[name]
+	db.mu.Unlock()
+	if !ok {
+		return fakeResult{}, fmt.Errorf("fakeDB: unexpected query %s", name)
+	}
+	return handler(args)
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{db}, nil }
+func (db *fakeDB) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("fakeDB: prepared statements aren't supported")
+}
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return fakeTx{c.db}, nil }
+
+// fakeTx only counts how transactions end; queries inside one take effect immediately
+type fakeTx struct{ db *fakeDB }
+
+func (tx fakeTx) Commit() error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	tx.db.commits++
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	tx.db.rollbacks++
+	return nil
+}
+
+func (c fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res, err := c.db.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{columns: res.columns, rows: res.rows}, nil
+}
+
+func (c fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res, err := c.db.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(res.affected), nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func nullable[T any](v T, valid bool) driver.Value {
+	// Returns v, or nil for a NULL column
+
+	if !valid {
+		return nil
+	}
+	return v
+}
+
+func userResult(users ...database.User) fakeResult {
+	// Returns users as rows in the column order of the users table
+
+	res := fakeResult{columns: []string{
+		"id", "created_at", "updated_at", "email", "hashed_password", "is_chirpy_red", "username",
+		"token_version", "email_verified_at", "totp_secret", "totp_enabled_at", "totp_last_step",
+		"failed_logins", "last_failed_login_at", "role",
+	}}
+	for _, u := range users {
+		res.rows = append(res.rows, []driver.Value{
+			u.ID.String(), u.CreatedAt, u.UpdatedAt, u.Email, u.HashedPassword, u.IsChirpyRed,
+			nullable(u.Username.String, u.Username.Valid), int64(u.TokenVersion),
+			nullable(u.EmailVerifiedAt.Time, u.EmailVerifiedAt.Valid),
+			nullable(u.TotpSecret.String, u.TotpSecret.Valid),
+			nullable(u.TotpEnabledAt.Time, u.TotpEnabledAt.Valid), u.TotpLastStep,
+			int64(u.FailedLogins), nullable(u.LastFailedLoginAt.Time, u.LastFailedLoginAt.Valid), u.Role,
+		})
+	}
+	return res
+}
+
+func returns(res fakeResult) fakeQuery {
+	// Answers every call with the same result
+
+	return func([]driver.NamedValue) (fakeResult, error) { return res, nil }
+}
+
+func fails(err error) fakeQuery {
+	// Fails every call with err
+
+	return func([]driver.NamedValue) (fakeResult, error) { return fakeResult{}, err }
+}
+
+func chirpResult(chirps ...database.Chirp) fakeResult {
+	// Returns chirps as rows in the column order of the chirps table
+
+	res := fakeResult{columns: []string{
+		"id", "created_at", "updated_at", "body", "user_id", "in_reply_to", "tombstoned", "like_count",
+		"search_vector", "deleted_at",
+	}}
+	for _, c := range chirps {
+		res.rows = append(res.rows, []driver.Value{
+			c.ID.String(), c.CreatedAt, c.UpdatedAt, c.Body, c.UserID.String(),
+			nullable(c.InReplyTo.UUID.String(), c.InReplyTo.Valid), c.Tombstoned, int64(c.LikeCount),
+			nil, nullable(c.DeletedAt.Time, c.DeletedAt.Valid),
+		})
+	}
+	return res
+}
@@ -0,0 +1,218 @@
+package chirpyserver
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/database"
+	"net/http"
+	"time"
+)
+
+func (cfg *ApiConfig) POSTFollow(writer http.ResponseWriter, req *http.Request) {
+	// Handles POST requests at users/{userID}/follow, makes the caller follow the user
+
+	// Gets the caller's ID; RequireAuth has already checked their token's scope
+	UID, ok := currentUser(writer, req)
+	if !ok {
+		return
+	}
+
+	// Parses the ID of the user to follow
+	followeeID, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		writer.WriteHeader(400)
+		writer.Write([]byte("Invalid user ID"))
+		return
+	}
+
+	if followeeID == UID {
+		writer.WriteHeader(400)
+		writer.Write([]byte("Cannot follow yourself"))
+		return
+	}
+
+	// Ensures the user to follow exists
+	if _, err := cfg.DBConn.GetUserByID(req.Context(), followeeID); err != nil {
+		writer.WriteHeader(404)
+		writer.Write([]byte("User not found"))
+		return
+	}
+
+	// Records the follow, following twice is a no-op
+	err = cfg.DBConn.FollowUser(req.Context(), database.FollowUserParams{
+		FollowerID: UID,
+		FolloweeID: followeeID,
+		CreatedAt:  time.Now().UTC(),
+	})
+	if err != nil {
+		writer.WriteHeader(500)
+		writer.Write([]byte("Failed to follow user"))
+		return
+	}
+
+	writer.WriteHeader(204)
+}
+
+func (cfg *ApiConfig) DELETEFollow(writer http.ResponseWriter, req *http.Request) {
+	// Handles DELETE requests at users/{userID}/follow, makes the caller unfollow the user
+
+	// Gets the caller's ID; RequireAuth has already checked their token's scope
+	UID, ok := currentUser(writer, req)
+	if !ok {
+		return
+	}
+
+	// Parses the ID of the user to unfollow
+	followeeID, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		writer.WriteHeader(400)
+		writer.Write([]byte("Invalid user ID"))
+		return
+	}
+
+	// Removes the follow, unfollowing someone not followed is a no-op
+	err = cfg.DBConn.UnfollowUser(req.Context(), database.UnfollowUserParams{
+		FollowerID: UID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		writer.WriteHeader(500)
+		writer.Write([]byte("Failed to unfollow user"))
+		return
+	}
+
+	writer.WriteHeader(204)
+}
+
+func (cfg *ApiConfig) GETFollowers(writer http.ResponseWriter, req *http.Request) {
+	// Handles GET requests at users/{userID}/followers, returns one page of followers, newest first
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	userID, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		http.Error(writer, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	// Reads the page size and starting cursor from the query
+	limit, cursor, err := parsePageParams(req.URL.Query())
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cursorTime, cursorID := cursor.nullParams()
+
+	// Fetches one extra row to find out whether another page follows
+	rows, err := cfg.DBConn.GetFollowers(req.Context(), database.GetFollowersParams{
+		FolloweeID:      userID,
+		CursorCreatedAt: cursorTime,
+		CursorID:        cursorID,
+		PageLimit:       limit + 1,
+	})
+	if err != nil {
+		http.Error(writer, "Unable to get followers", http.StatusInternalServerError)
+		return
+	}
+
+	out := make([]Follow, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, Follow{UserID: r.FollowerID, FollowedAt: r.CreatedAt})
+	}
+	writeFollowPage(writer, out, int(limit))
+}
+
+func (cfg *ApiConfig) GETFollowing(writer http.ResponseWriter, req *http.Request) {
+	// Handles GET requests at users/{userID}/following, returns one page of followed users, newest first
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	userID, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		http.Error(writer, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	// Reads the page size and starting cursor from the query
+	limit, cursor, err := parsePageParams(req.URL.Query())
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cursorTime, cursorID := cursor.nullParams()
+
+	// Fetches one extra row to find out whether another page follows
+	rows, err := cfg.DBConn.GetFollowing(req.Context(), database.GetFollowingParams{
+		FollowerID:      userID,
+		CursorCreatedAt: cursorTime,
+		CursorID:        cursorID,
+		PageLimit:       limit + 1,
+	})
+	if err != nil {
+		http.Error(writer, "Unable to get followed users", http.StatusInternalServerError)
+		return
+	}
+
+	out := make([]Follow, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, Follow{UserID: r.FolloweeID, FollowedAt: r.CreatedAt})
+	}
+	writeFollowPage(writer, out, int(limit))
+}
+
+func writeFollowPage(writer http.ResponseWriter, follows []Follow, limit int) {
+	// Trims the extra row fetched by the caller and writes the page with its next cursor
+
+	nextCursor := ""
+	if len(follows) > limit {
+		follows = follows[:limit]
+		last := follows[len(follows)-1]
+		nextCursor = encodeCursor(last.FollowedAt, last.UserID)
+	}
+
+	outJson, err := json.Marshal(FollowPage{
+		Users:      follows,
+		NextCursor: nextCursor,
+	})
+	if err != nil {
+		http.Error(writer, "Failed to marshal data", http.StatusInternalServerError)
+		return
+	}
+
+	writer.WriteHeader(200)
+	writer.Write(outJson)
+}
+
+func (cfg *ApiConfig) GETTimeline(writer http.ResponseWriter, req *http.Request) {
+	// Handles GET requests at the timeline endpoint, returns chirps from followed users, newest first
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	// Gets the caller's ID; RequireAuth has already checked their token's scope
+	UID, ok := currentUser(writer, req)
+	if !ok {
+		return
+	}
+
+	// Reads the page size and starting cursor from the query
+	limit, cursor, err := parsePageParams(req.URL.Query())
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cursorTime, cursorID := cursor.nullParams()
+
+	// Fetches one extra row to find out whether another page follows
+	chirps, err := cfg.DBConn.GetTimeline(req.Context(), database.GetTimelineParams{
+		FollowerID:      UID,
+		CursorCreatedAt: cursorTime,
+		CursorID:        cursorID,
+		PageLimit:       limit + 1,
+	})
+	if err != nil {
+		http.Error(writer, "Unable to get timeline", http.StatusInternalServerError)
+		return
+	}
+
+	cfg.writeChirpPage(writer, req, chirps, int(limit))
+}
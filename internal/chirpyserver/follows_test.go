@@ -0,0 +1,244 @@
+package chirpyserver
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
+	"github.com/roxensox/chirpy/internal/database"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// follow is a row of the follows table
+type follow struct {
+	follower, followee string
+	createdAt          time.Time
+}
+
+func keysetPage[T any](rows []T, key func(T) (time.Time, string), args []driver.NamedValue) []T {
+	// Orders rows newest first and applies the (created_at, id) cursor and limit in args[1:4], as the paged queries do
+
+	slices.SortFunc(rows, func(a, b T) int {
+		at, aid := key(a)
+		bt, bid := key(b)
+		if c := bt.Compare(at); c != 0 {
+			return c
+		}
+		if bid > aid {
+			return 1
+		}
+		return -1
+	})
+	out := []T{}
+	for _, r := range rows {
+		t, id := key(r)
+		if args[1].Value != nil {
+			cursorTime, cursorID := args[1].Value.(time.Time), args[2].Value.(string)
+			if t.After(cursorTime) || (t.Equal(cursorTime) && id >= cursorID) {
+				continue
+			}
+		}
+		out = append(out, r)
+	}
+	return out[:min(len(out), int(args[3].Value.(int64)))]
+}
+
+func TestFollows(t *testing.T) {
+	alice, bob, carol, dave := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	users := map[string]bool{alice.String(): true, bob.String(): true, carol.String(): true, dave.String(): true}
+
+	// Two chirps each from bob and carol, a minute apart, and one from dave who alice doesn't follow
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	var chirps []database.Chirp
+	for i, author := range []uuid.UUID{bob, carol, bob, carol, dave} {
+		created := start.Add(time.Duration(i) * time.Minute)
+		chirps = append(chirps, database.Chirp{ID: uuid.New(), CreatedAt: created, UpdatedAt: created, Body: "chirp", UserID: author})
+	}
+
+	var follows []follow
+	clock := start
+	_, conn := newFakeDB(map[string]fakeQuery{
+		"GetUserByID": func(args []driver.NamedValue) (fakeResult, error) {
+			if !users[args[0].Value.(string)] {
+				return userResult(), nil
+			}
+			return userResult(database.User{ID: uuid.MustParse(args[0].Value.(string))}), nil
+		},
+		"FollowUser": func(args []driver.NamedValue) (fakeResult, error) {
+			for _, f := range follows {
+				if f.follower == args[0].Value && f.followee == args[1].Value {
+					return fakeResult{}, nil
+				}
+			}
+			// Spaces follows out so their order is unambiguous
+			clock = clock.Add(time.Second)
+			follows = append(follows, follow{args[0].Value.(string), args[1].Value.(string), clock})
+			return fakeResult{affected: 1}, nil
+		},
+		"UnfollowUser": func(args []driver.NamedValue) (fakeResult, error) {
+			follows = slices.DeleteFunc(follows, func(f follow) bool {
+				return f.follower == args[0].Value && f.followee == args[1].Value
+			})
+			return fakeResult{}, nil
+		},
+		"GetFollowers": func(args []driver.NamedValue) (fakeResult, error) {
+			var rows []follow
+			for _, f := range follows {
+				if f.followee == args[0].Value {
+					rows = append(rows, f)
+				}
+			}
+			res := fakeResult{columns: []string{"follower_id", "created_at"}}
+			for _, f := range keysetPage(rows, func(f follow) (time.Time, string) { return f.createdAt, f.follower }, args) {
+				res.rows = append(res.rows, []driver.Value{f.follower, f.createdAt})
+			}
+			return res, nil
+		},
+		"GetFollowing": func(args []driver.NamedValue) (fakeResult, error) {
+			var rows []follow
+			for _, f := range follows {
+				if f.follower == args[0].Value {
+					rows = append(rows, f)
+				}
+			}
+			res := fakeResult{columns: []string{"followee_id", "created_at"}}
+			for _, f := range keysetPage(rows, func(f follow) (time.Time, string) { return f.createdAt, f.followee }, args) {
+				res.rows = append(res.rows, []driver.Value{f.followee, f.createdAt})
+			}
+			return res, nil
+		},
+		"GetTimeline": func(args []driver.NamedValue) (fakeResult, error) {
+			var rows []database.Chirp
+			for _, c := range chirps {
+				for _, f := range follows {
+					if f.follower == args[0].Value && f.followee == c.UserID.String() {
+						rows = append(rows, c)
+					}
+				}
+			}
+			return chirpResult(keysetPage(rows, func(c database.Chirp) (time.Time, string) { return c.CreatedAt, c.ID.String() }, args)...), nil
+		},
+		"GetChirpMentions": returns(fakeResult{columns: []string{"chirp_id", "username", "user_id"}}),
+		"GetLikedChirpIDs": returns(fakeResult{columns: []string{"chirp_id"}}),
+	})
+	cfg := &ApiConfig{DBConn: conn}
+
+	as := func(userID uuid.UUID, method, path string) *http.Request {
+		req := httptest.NewRequest(method, path, nil)
+		return req.WithContext(WithPrincipal(context.Background(), Principal{UserID: userID, Scopes: auth.AllScopes}))
+	}
+	followUser := func(follower, followee uuid.UUID) int {
+		req := as(follower, "POST", "/api/users/"+followee.String()+"/follow")
+		req.SetPathValue("userID", followee.String())
+		rec := httptest.NewRecorder()
+		cfg.POSTFollow(rec, req)
+		return rec.Code
+	}
+	list := func(handler http.HandlerFunc, userID uuid.UUID, query string) FollowPage {
+		req := httptest.NewRequest("GET", "/api/users/"+userID.String()+"/followers?"+query, nil)
+		req.SetPathValue("userID", userID.String())
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != 200 {
+			t.Fatalf("Expected 200 listing follows, got %d", rec.Code)
+		}
+		var page FollowPage
+		json.NewDecoder(rec.Body).Decode(&page)
+		return page
+	}
+	ids := func(page FollowPage) []uuid.UUID {
+		out := []uuid.UUID{}
+		for _, f := range page.Users {
+			out = append(out, f.UserID)
+		}
+		return out
+	}
+
+	// Following yourself or a user that doesn't exist is refused
+	if status := followUser(alice, alice); status != 400 {
+		t.Errorf("Expected 400 following yourself, got %d", status)
+	}
+	if status := followUser(alice, uuid.New()); status != 404 {
+		t.Errorf("Expected 404 following an unknown user, got %d", status)
+	}
+
+	// Following twice is a no-op
+	for _, followee := range []uuid.UUID{bob, carol, bob} {
+		if status := followUser(alice, followee); status != 204 {
+			t.Errorf("Expected 204 following %s, got %d", followee, status)
+		}
+	}
+	if len(follows) != 2 {
+		t.Errorf("Expected following twice to record one follow, got %d follows", len(follows))
+	}
+	followUser(dave, bob)
+
+	// Both lists come back newest first
+	if got := ids(list(cfg.GETFollowing, alice, "")); !slices.Equal(got, []uuid.UUID{carol, bob}) {
+		t.Errorf("Expected alice to follow carol then bob, got %v", got)
+	}
+	if got := ids(list(cfg.GETFollowers, bob, "")); !slices.Equal(got, []uuid.UUID{dave, alice}) {
+		t.Errorf("Expected bob's followers to be dave then alice, got %v", got)
+	}
+
+	// A page that fills up hands back a cursor to the rest
+	page := list(cfg.GETFollowers, bob, "limit=1")
+	if got := ids(page); !slices.Equal(got, []uuid.UUID{dave}) || page.NextCursor == "" {
+		t.Fatalf("Expected dave and a cursor on the first page, got %v and %q", got, page.NextCursor)
+	}
+	page = list(cfg.GETFollowers, bob, "limit=1&cursor="+page.NextCursor)
+	if got := ids(page); !slices.Equal(got, []uuid.UUID{alice}) || page.NextCursor != "" {
+		t.Errorf("Expected alice and no cursor on the last page, got %v and %q", got, page.NextCursor)
+	}
+
+	// The timeline holds only followed users' chirps, newest first, paged by cursor
+	timeline := func(query string) ChirpPage {
+		rec := httptest.NewRecorder()
+		cfg.GETTimeline(rec, as(alice, "GET", "/api/timeline?"+query))
+		if rec.Code != 200 {
+			t.Fatalf("Expected 200 getting the timeline, got %d", rec.Code)
+		}
+		var page ChirpPage
+		json.NewDecoder(rec.Body).Decode(&page)
+		return page
+	}
+	var seen []uuid.UUID
+	query := "limit=3"
+	for pages := 0; ; pages++ {
+		if pages > 2 {
+			t.Fatalf("Too many timeline pages")
+		}
+		page := timeline(query)
+		for _, c := range page.Chirps {
+			seen = append(seen, c.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query = "limit=3&cursor=" + page.NextCursor
+	}
+	expected := []uuid.UUID{chirps[3].ID, chirps[2].ID, chirps[1].ID, chirps[0].ID}
+	if !slices.Equal(seen, expected) {
+		t.Errorf("Expected the timeline %v, got %v", expected, seen)
+	}
+
+	// Unfollowing drops the user from the list and their chirps from the timeline
+	req := as(alice, "DELETE", "/api/users/"+carol.String()+"/follow")
+	req.SetPathValue("userID", carol.String())
+	rec := httptest.NewRecorder()
+	cfg.DELETEFollow(rec, req)
+	if rec.Code != 204 {
+		t.Errorf("Expected 204 unfollowing, got %d", rec.Code)
+	}
+	if got := ids(list(cfg.GETFollowing, alice, "")); !slices.Equal(got, []uuid.UUID{bob}) {
+		t.Errorf("Expected alice to follow only bob, got %v", got)
+	}
+	if page := timeline(""); len(page.Chirps) != 2 {
+		t.Errorf("Expected only bob's two chirps on the timeline, got %d", len(page.Chirps))
+	}
+}
@@ -0,0 +1,129 @@
+package chirpyserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/roxensox/chirpy/internal/auth"
+	"log"
+	"net/http"
+	"time"
+)
+
+// How long access tokens stay valid, and so how long a retired signing key must keep verifying
+const accessTokenLifetime = time.Hour
+
+func (cfg *ApiConfig) GETJWKS(writer http.ResponseWriter, req *http.Request) {
+	// Handles GET requests at .well-known/jwks.json, publishes the public keys access tokens can be verified with
+
+	outJson, err := json.Marshal(cfg.Keys.JWKS())
+	if err != nil {
+		http.Error(writer, "Failed to marshal data", http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "public, max-age=300")
+	writer.WriteHeader(200)
+	writer.Write(outJson)
+}
+
+func (cfg *ApiConfig) POSTReloadKeys(writer http.ResponseWriter, req *http.Request) {
+	// Handles POST requests at admin/keys/reload, re-reads the key directory to rotate signing keys
+
+	if cfg.KeysDir == "" {
+		http.Error(writer, "Signing keys are not loaded from a directory", http.StatusNotFound)
+		return
+	}
+
+	// The current keys stay in place if the directory can't be loaded
+	if err := cfg.Keys.LoadDir(cfg.KeysDir); err != nil {
+		log.Printf("Failed to reload signing keys: %v", err)
+		http.Error(writer, "Failed to reload signing keys", http.StatusInternalServerError)
+		return
+	}
+
+	outJson, err := json.Marshal(struct {
+		Keys []string `json:"keys"`
+	}{
+		Keys: cfg.Keys.IDs(),
+	})
+	if err != nil {
+		http.Error(writer, "Failed to marshal data", http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(200)
+	writer.Write(outJson)
+}
+
+func (cfg *ApiConfig) RunKeyPruner(ctx context.Context, interval time.Duration) {
+	// Drops retired signing keys every interval once no unexpired token can refer to them, until ctx is cancelled
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		cfg.Keys.Prune(accessTokenLifetime)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *ApiConfig) validator() *auth.Validator {
+	// Returns the configured token validator, or one with the default rules over the config's keys
+
+	if cfg.Validator != nil {
+		return cfg.Validator
+	}
+	v := &auth.Validator{Keys: cfg.Keys}
+	if cfg.Revocations != nil {
+		v.Revocations = cfg.Revocations
+	}
+	return v
+}
+
+func writeTokenError(writer http.ResponseWriter, err error) {
+	// Writes a 401 that tells the caller why their access token was rejected, or a 403 if it lacks a scope
+
+	msg := "Invalid token"
+	switch {
+	case errors.Is(err, errMissingToken):
+		writer.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(writer, "Token not found", http.StatusUnauthorized)
+		return
+	case errors.Is(err, auth.ErrInsufficientScope):
+		writer.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+		http.Error(writer, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, errUnknownToken):
+		msg = "Invalid token"
+	case errors.Is(err, auth.ErrTokenExpired):
+		msg = "Token expired"
+	case errors.Is(err, auth.ErrTokenNotYetValid):
+		msg = "Token not valid yet"
+	case errors.Is(err, auth.ErrBadSignature):
+		msg = "Invalid token signature"
+	case errors.Is(err, auth.ErrWrongIssuer):
+		msg = "Token has the wrong issuer"
+	case errors.Is(err, auth.ErrWrongAudience):
+		msg = "Token has the wrong audience"
+	case errors.Is(err, auth.ErrTokenRevoked):
+		msg = "Token revoked"
+	case errors.Is(err, auth.ErrMalformedToken):
+		msg = "Malformed token"
+	default:
+		// Anything else is a failure looking up revocation state, not a problem with the token
+		log.Printf("Failed to validate token: %v", err)
+		http.Error(writer, "Unable to validate token", http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="`+msg+`"`)
+	http.Error(writer, msg, http.StatusUnauthorized)
+}
@@ -0,0 +1,113 @@
+package chirpyserver
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/database"
+	"net/http"
+	"time"
+)
+
+func (cfg *ApiConfig) POSTChirpLike(writer http.ResponseWriter, req *http.Request) {
+	// Handles POST requests at chirps/{chirpID}/likes, likes the chirp as the caller
+
+	// Gets the caller's ID; RequireAuth has already checked their token's scope
+	UID, ok := currentUser(writer, req)
+	if !ok {
+		return
+	}
+
+	// Parses the chirp ID from the path
+	CID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		writer.WriteHeader(400)
+		writer.Write([]byte("Invalid chirp ID"))
+		return
+	}
+
+	// Ensures the chirp exists and hasn't been deleted
+	if _, err := cfg.DBConn.GetExactChirp(req.Context(), CID); err != nil {
+		writer.WriteHeader(404)
+		writer.Write([]byte("Chirp not found"))
+		return
+	}
+
+	// Records the like, which a trigger counts; liking twice is a no-op
+	_, err = cfg.DBConn.LikeChirp(req.Context(), database.LikeChirpParams{
+		ChirpID:   CID,
+		UserID:    UID,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		writer.WriteHeader(500)
+		writer.Write([]byte("Failed to like chirp"))
+		return
+	}
+
+	writer.WriteHeader(204)
+}
+
+func (cfg *ApiConfig) DELETEChirpLike(writer http.ResponseWriter, req *http.Request) {
+	// Handles DELETE requests at chirps/{chirpID}/likes, removes the caller's like
+
+	// Gets the caller's ID; RequireAuth has already checked their token's scope
+	UID, ok := currentUser(writer, req)
+	if !ok {
+		return
+	}
+
+	// Parses the chirp ID from the path
+	CID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		writer.WriteHeader(400)
+		writer.Write([]byte("Invalid chirp ID"))
+		return
+	}
+
+	// Removes the like, which a trigger uncounts; unliking twice is a no-op
+	_, err = cfg.DBConn.UnlikeChirp(req.Context(), database.UnlikeChirpParams{
+		ChirpID: CID,
+		UserID:  UID,
+	})
+	if err != nil {
+		writer.WriteHeader(500)
+		writer.Write([]byte("Failed to unlike chirp"))
+		return
+	}
+
+	writer.WriteHeader(204)
+}
+
+func (cfg *ApiConfig) markLikedByMe(ctx context.Context, viewer uuid.UUID, groups ...[]Chirp) error {
+	// Sets LikedByMe in place on each chirp the viewer has liked with a single query
+
+	ids := make([]uuid.UUID, 0)
+	for _, chirps := range groups {
+		for _, c := range chirps {
+			ids = append(ids, c.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	liked, err := cfg.DBConn.GetLikedChirpIDs(ctx, database.GetLikedChirpIDsParams{
+		UserID:   viewer,
+		ChirpIds: ids,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Builds a lookup of liked IDs and flags the matching chirps
+	likedSet := make(map[uuid.UUID]bool, len(liked))
+	for _, id := range liked {
+		likedSet[id] = true
+	}
+	for _, chirps := range groups {
+		for i := range chirps {
+			chirps[i].LikedByMe = likedSet[chirps[i].ID]
+		}
+	}
+	return nil
+}
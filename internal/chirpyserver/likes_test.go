@@ -0,0 +1,128 @@
+package chirpyserver
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
+	"github.com/roxensox/chirpy/internal/database"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChirpLikes(t *testing.T) {
+	alice, bob := uuid.New(), uuid.New()
+	now := time.Now().UTC()
+	chirp := database.Chirp{ID: uuid.New(), CreatedAt: now, UpdatedAt: now, Body: "likeable", UserID: uuid.New()}
+
+	// Keeps likes as a set and like_count in step with it, as the trigger does
+	likes := map[string]bool{}
+	key := func(args []driver.NamedValue) string { return args[0].Value.(string) + "/" + args[1].Value.(string) }
+	_, conn := newFakeDB(map[string]fakeQuery{
+		"GetExactChirp": func(args []driver.NamedValue) (fakeResult, error) {
+			if args[0].Value != chirp.ID.String() {
+				return chirpResult(), nil
+			}
+			return chirpResult(chirp), nil
+		},
+		"LikeChirp": func(args []driver.NamedValue) (fakeResult, error) {
+			if likes[key(args)] {
+				return fakeResult{}, nil
+			}
+			likes[key(args)] = true
+			chirp.LikeCount++
+			return fakeResult{affected: 1}, nil
+		},
+		"UnlikeChirp": func(args []driver.NamedValue) (fakeResult, error) {
+			if !likes[key(args)] {
+				return fakeResult{}, nil
+			}
+			delete(likes, key(args))
+			chirp.LikeCount--
+			return fakeResult{affected: 1}, nil
+		},
+		"GetLikedChirpIDs": func(args []driver.NamedValue) (fakeResult, error) {
+			res := fakeResult{columns: []string{"chirp_id"}}
+			if likes[chirp.ID.String()+"/"+args[0].Value.(string)] {
+				res.rows = [][]driver.Value{{chirp.ID.String()}}
+			}
+			return res, nil
+		},
+		"GetChirpMentions": returns(fakeResult{columns: []string{"chirp_id", "username", "user_id"}}),
+	})
+	cfg := &ApiConfig{DBConn: conn}
+
+	request := func(userID uuid.UUID, method string, chirpID uuid.UUID) *http.Request {
+		req := httptest.NewRequest(method, "/api/chirps/"+chirpID.String()+"/likes", nil)
+		req.SetPathValue("chirpID", chirpID.String())
+		if userID == uuid.Nil {
+			return req
+		}
+		return req.WithContext(WithPrincipal(context.Background(), Principal{UserID: userID, Scopes: auth.AllScopes}))
+	}
+	like := func(userID uuid.UUID, chirpID uuid.UUID) int {
+		rec := httptest.NewRecorder()
+		cfg.POSTChirpLike(rec, request(userID, "POST", chirpID))
+		return rec.Code
+	}
+	unlike := func(userID uuid.UUID) int {
+		rec := httptest.NewRecorder()
+		cfg.DELETEChirpLike(rec, request(userID, "DELETE", chirp.ID))
+		return rec.Code
+	}
+	get := func(userID uuid.UUID) Chirp {
+		rec := httptest.NewRecorder()
+		cfg.GETChirpByID(rec, request(userID, "GET", chirp.ID))
+		if rec.Code != 200 {
+			t.Fatalf("Expected 200 getting the chirp, got %d", rec.Code)
+		}
+		var out Chirp
+		json.NewDecoder(rec.Body).Decode(&out)
+		return out
+	}
+
+	// Liking a chirp that doesn't exist is refused
+	if status := like(alice, uuid.New()); status != 404 {
+		t.Errorf("Expected 404 liking an unknown chirp, got %d", status)
+	}
+
+	// Liking twice counts once
+	for range 2 {
+		if status := like(alice, chirp.ID); status != 204 {
+			t.Errorf("Expected 204 liking the chirp, got %d", status)
+		}
+	}
+	if got := get(uuid.Nil); got.LikeCount != 1 {
+		t.Errorf("Expected a duplicate like to count once, got %d likes", got.LikeCount)
+	}
+
+	// Only the caller who liked the chirp sees liked_by_me, and anonymous callers never do
+	if get(uuid.Nil).LikedByMe {
+		t.Errorf("Expected liked_by_me to be false for an anonymous caller")
+	}
+	if !get(alice).LikedByMe {
+		t.Errorf("Expected liked_by_me to be true for alice")
+	}
+	if get(bob).LikedByMe {
+		t.Errorf("Expected liked_by_me to be false for bob")
+	}
+
+	// Unliking a chirp the caller never liked is a no-op
+	if status := unlike(bob); status != 204 {
+		t.Errorf("Expected 204 unliking a chirp bob never liked, got %d", status)
+	}
+	if got := get(uuid.Nil); got.LikeCount != 1 {
+		t.Errorf("Expected bob's unlike to leave one like, got %d", got.LikeCount)
+	}
+
+	// Unliking takes the like back
+	if status := unlike(alice); status != 204 {
+		t.Errorf("Expected 204 unliking the chirp, got %d", status)
+	}
+	if got := get(alice); got.LikeCount != 0 || got.LikedByMe {
+		t.Errorf("Expected no likes after alice unliked, got %d and liked_by_me %v", got.LikeCount, got.LikedByMe)
+	}
+}
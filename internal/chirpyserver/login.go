@@ -2,6 +2,7 @@ package chirpyserver
 
 import (
 	"encoding/json"
+	"github.com/google/uuid"
 	"github.com/roxensox/chirpy/internal/auth"
 	"github.com/roxensox/chirpy/internal/database"
 	"net/http"
@@ -21,55 +22,99 @@ func (cfg *ApiConfig) POSTLogin(writer http.ResponseWriter, req *http.Request) {
 	decoder := json.NewDecoder(req.Body)
 	decoder.Decode(&inObj)
 
-	// Queries the user from the database
+	// Reserves the attempt against the client IP before spending time on a password hash, turning it away if
+	// it has failed too often; the reservation stands as the failure unless the password turns out to be right
+	now := time.Now().UTC()
+	throttle := cfg.loginThrottle()
+	ip := clientIP(req)
+	if wait := throttle.claimIP(ip, now); wait > 0 {
+		writeRetryAfter(writer, wait)
+		return
+	}
+
+	// Queries the user from the database and reserves the attempt against the account too; unknown emails go
+	// through the same steps as wrong passwords so neither the response nor its timing reveals whether an account exists
 	user, err := cfg.DBConn.GetUserByEmail(req.Context(), inObj.Email)
-	// Validates the password
-	validPass, err2 := auth.CheckPasswordHash(inObj.Password, user.HashedPassword)
+	found := err == nil
+	var failures int32
+	var wait time.Duration
+	if found {
+		failures, wait, err = cfg.claimAccountAttempt(req.Context(), user, now)
+		if err != nil {
+			throttle.refundIP(ip)
+			writer.WriteHeader(500)
+			writer.Write([]byte("Failed to record login attempt"))
+			return
+		}
+	} else {
+		wait = throttle.claimUnknown(inObj.Email, now)
+	}
+	if wait > 0 {
+		// The attempt never happened, so it isn't held against the IP
+		throttle.refundIP(ip)
+		writeRetryAfter(writer, wait)
+		return
+	}
 
-	if err != nil || !validPass {
+	// Validates the password, against a dummy hash when there's no account so the work done is the same
+	hash := user.HashedPassword
+	if !found {
+		hash = auth.DummyPasswordHash()
+	}
+	validPass, err := auth.CheckPasswordHash(inObj.Password, hash)
+	if err != nil || !found || !validPass {
+		if found && throttle.Account.LockoutAttempts > 0 && int(failures) == throttle.Account.LockoutAttempts {
+			cfg.logSecurityEvent(req.Context(), user.ID, "account_locked", ip)
+		}
 		writer.WriteHeader(401)
 		writer.Write([]byte("Incorrect email or password"))
 		return
 	}
 
-	if err2 != nil {
-		writer.WriteHeader(500)
-		writer.Write([]byte("Failed to compare passwords"))
+	// A correct password gives back the reservations. Accounts with two-factor authentication finish logging
+	// in at login/mfa and keep their earlier failures until the code is right too, so knowing the password
+	// doesn't reset the lockout that limits guessing the code
+	throttle.refundIP(ip)
+	if user.TotpEnabledAt.Valid {
+		if err := cfg.DBConn.RefundLoginAttempt(req.Context(), user.ID); err != nil {
+			writer.WriteHeader(500)
+			writer.Write([]byte("Failed to record login attempt"))
+			return
+		}
+		cfg.startMFAChallenge(writer, req, user.ID)
 		return
 	}
-
-	jwt, err := auth.MakeJWT(user.ID, cfg.Secret, time.Hour)
-
-	ref_token, err := auth.MakeRefreshToken()
-	if err != nil {
+	if _, err := cfg.DBConn.ResetFailedLogins(req.Context(), user.ID); err != nil {
 		writer.WriteHeader(500)
-		writer.Write([]byte("Failed to generate refresh token"))
+		writer.Write([]byte("Failed to record login attempt"))
 		return
 	}
 
-	refTokenParams := database.AddRefreshTokenParams{
-		UserID:    user.ID,
-		Token:     ref_token,
-		CreatedAt: time.Now().UTC(),
-		UpdatedAt: time.Now().UTC(),
-		ExpiresAt: time.Now().UTC().Add(60 * 24 * time.Hour),
-	}
+	cfg.issueSession(writer, req, user)
+}
+
+func (cfg *ApiConfig) issueSession(writer http.ResponseWriter, req *http.Request, user database.User) {
+	// Writes a new access token and refresh token pair for an authenticated user, starting a new session
 
-	err = cfg.DBConn.AddRefreshToken(req.Context(), refTokenParams)
+	// Every login starts a new session family for this device
+	jwt, ref_token, err := cfg.startSession(req, user.ID, user.TokenVersion, uuid.New(), sessionGrant{})
 	if err != nil {
 		writer.WriteHeader(500)
-		writer.Write([]byte("Failed to add refresh token"))
+		writer.Write([]byte("Failed to create session"))
 		return
 	}
 
 	out := User{
-		Email:        user.Email,
-		ID:           user.ID,
-		CreatedAt:    user.CreatedAt,
-		UpdatedAt:    user.UpdatedAt,
-		Token:        jwt,
-		RefreshToken: ref_token,
-		IsChirpyRed:  user.IsChirpyRed,
+		Email:         user.Email,
+		ID:            user.ID,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		Token:         jwt,
+		RefreshToken:  ref_token,
+		IsChirpyRed:   user.IsChirpyRed,
+		Username:      user.Username.String,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+		Role:          user.Role,
 	}
 
 	outJson, err := json.Marshal(out)
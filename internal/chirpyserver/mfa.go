@@ -0,0 +1,405 @@
+package chirpyserver
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
+	"github.com/roxensox/chirpy/internal/database"
+	"net/http"
+	"time"
+)
+
+const (
+	mfaChallengeLifetime = 5 * time.Minute
+	mfaChallengeAttempts = 5
+	recoveryCodeCount    = 10
+	totpAllowedStepsSkew = 1
+	totpIssuer           = "Chirpy"
+)
+
+func (cfg *ApiConfig) POSTEnrollTOTP(writer http.ResponseWriter, req *http.Request) {
+	// Handles POST requests at mfa/totp, generates a TOTP secret that becomes active once confirmed
+
+	// Gets the caller's ID; RequireAuth has already checked their token's scope
+	UID, ok := currentUser(writer, req)
+	if !ok {
+		return
+	}
+
+	if len(cfg.MFAKey) == 0 {
+		http.Error(writer, "Two-factor authentication is not configured", http.StatusNotImplemented)
+		return
+	}
+
+	user, err := cfg.DBConn.GetUserByID(req.Context(), UID)
+	if err != nil {
+		http.Error(writer, "User not found", http.StatusNotFound)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		http.Error(writer, "Failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+	sealed, err := auth.EncryptSecret(cfg.MFAKey, secret)
+	if err != nil {
+		http.Error(writer, "Failed to encrypt secret", http.StatusInternalServerError)
+		return
+	}
+
+	// Replaces any unconfirmed secret, but never one that's already active
+	updated, err := cfg.DBConn.SetPendingTOTPSecret(req.Context(), database.SetPendingTOTPSecretParams{
+		ID:         UID,
+		TotpSecret: sql.NullString{String: sealed, Valid: true},
+		UpdatedAt:  time.Now().UTC(),
+	})
+	if err != nil {
+		http.Error(writer, "Failed to store secret", http.StatusInternalServerError)
+		return
+	}
+	if updated == 0 {
+		http.Error(writer, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	outJson, err := json.Marshal(struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}{
+		Secret: secret,
+		URI:    auth.TOTPURI(totpIssuer, user.Email, secret),
+	})
+	if err != nil {
+		http.Error(writer, "Failed to marshal data", http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(200)
+	writer.Write(outJson)
+}
+
+func (cfg *ApiConfig) POSTConfirmTOTP(writer http.ResponseWriter, req *http.Request) {
+	// Handles POST requests at mfa/totp/confirm, turns on TOTP once the caller proves their app is set up
+
+	// Gets the caller's ID; RequireAuth has already checked their token's scope
+	UID, ok := currentUser(writer, req)
+	if !ok {
+		return
+	}
+
+	inObj := struct {
+		Code string `json:"code"`
+	}{}
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&inObj); err != nil || inObj.Code == "" {
+		http.Error(writer, "A code is required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := cfg.DBConn.GetUserByID(req.Context(), UID)
+	if err != nil {
+		http.Error(writer, "User not found", http.StatusNotFound)
+		return
+	}
+	if user.TotpEnabledAt.Valid {
+		http.Error(writer, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if !user.TotpSecret.Valid {
+		http.Error(writer, "Start enrollment first", http.StatusBadRequest)
+		return
+	}
+
+	valid, err := cfg.checkTOTP(req.Context(), user, inObj.Code)
+	if err != nil {
+		http.Error(writer, "Failed to check code", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		http.Error(writer, "Incorrect code", http.StatusUnauthorized)
+		return
+	}
+
+	now := time.Now().UTC()
+	err = cfg.DBConn.EnableTOTP(req.Context(), database.EnableTOTPParams{
+		ID:            UID,
+		TotpEnabledAt: sql.NullTime{Time: now, Valid: true},
+	})
+	if err != nil {
+		http.Error(writer, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	// Recovery codes are only ever shown here, only their hashes are stored
+	codes, err := cfg.replaceRecoveryCodes(req.Context(), UID)
+	if err != nil {
+		http.Error(writer, "Failed to create recovery codes", http.StatusInternalServerError)
+		return
+	}
+	cfg.logSecurityEvent(req.Context(), UID, "mfa_enabled", clientIP(req))
+
+	outJson, err := json.Marshal(struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		RecoveryCodes: codes,
+	})
+	if err != nil {
+		http.Error(writer, "Failed to marshal data", http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(200)
+	writer.Write(outJson)
+}
+
+func (cfg *ApiConfig) DELETETOTP(writer http.ResponseWriter, req *http.Request) {
+	// Handles DELETE requests at mfa/totp, turns off two-factor authentication given a current code
+
+	// Gets the caller's ID; RequireAuth has already checked their token's scope
+	UID, ok := currentUser(writer, req)
+	if !ok {
+		return
+	}
+
+	inObj := struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}{}
+	decoder := json.NewDecoder(req.Body)
+	decoder.Decode(&inObj)
+
+	user, err := cfg.DBConn.GetUserByID(req.Context(), UID)
+	if err != nil {
+		http.Error(writer, "User not found", http.StatusNotFound)
+		return
+	}
+	if !user.TotpEnabledAt.Valid {
+		http.Error(writer, "Two-factor authentication is not enabled", http.StatusConflict)
+		return
+	}
+
+	// A stolen access token alone isn't enough to turn it off
+	valid, err := cfg.checkSecondFactor(req.Context(), user, inObj.Code, inObj.RecoveryCode)
+	if err != nil {
+		http.Error(writer, "Failed to check code", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		http.Error(writer, "Incorrect code", http.StatusUnauthorized)
+		return
+	}
+
+	err = cfg.DBConn.DisableTOTP(req.Context(), database.DisableTOTPParams{
+		ID:        UID,
+		UpdatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		http.Error(writer, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	if err := cfg.DBConn.DeleteRecoveryCodes(req.Context(), UID); err != nil {
+		http.Error(writer, "Failed to remove recovery codes", http.StatusInternalServerError)
+		return
+	}
+	cfg.logSecurityEvent(req.Context(), UID, "mfa_disabled", clientIP(req))
+
+	writer.WriteHeader(204)
+}
+
+func (cfg *ApiConfig) startMFAChallenge(writer http.ResponseWriter, req *http.Request, userID uuid.UUID) {
+	// Writes a short-lived challenge token the client exchanges, with a code, at login/mfa
+
+	tkn, err := auth.MakeMFAChallengeToken()
+	if err != nil {
+		http.Error(writer, "Failed to create challenge", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now().UTC()
+	err = cfg.DBConn.CreateMFAChallenge(req.Context(), database.CreateMFAChallengeParams{
+		TokenHash: auth.HashToken(tkn),
+		UserID:    userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(mfaChallengeLifetime),
+	})
+	if err != nil {
+		http.Error(writer, "Failed to create challenge", http.StatusInternalServerError)
+		return
+	}
+
+	outJson, err := json.Marshal(struct {
+		MFARequired bool      `json:"mfa_required"`
+		MFAToken    string    `json:"mfa_token"`
+		ExpiresAt   time.Time `json:"expires_at"`
+	}{
+		MFARequired: true,
+		MFAToken:    tkn,
+		ExpiresAt:   now.Add(mfaChallengeLifetime),
+	})
+	if err != nil {
+		http.Error(writer, "Failed to marshal data", http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(200)
+	writer.Write(outJson)
+}
+
+func (cfg *ApiConfig) POSTLoginMFA(writer http.ResponseWriter, req *http.Request) {
+	// Handles POST requests at login/mfa, trades a challenge token and a TOTP or recovery code for a session
+
+	inObj := struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}{}
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&inObj); err != nil || inObj.MFAToken == "" {
+		http.Error(writer, "An MFA token is required", http.StatusBadRequest)
+		return
+	}
+
+	// Challenges expire quickly and only allow a few guesses; each guess is counted in the same statement
+	// that checks the limit, so parallel requests can't all slip under it before any is recorded
+	tokenHash := auth.HashToken(inObj.MFAToken)
+	challenge, err := cfg.DBConn.ClaimMFAAttempt(req.Context(), database.ClaimMFAAttemptParams{
+		TokenHash:   tokenHash,
+		ExpiresAt:   time.Now().UTC(),
+		MaxAttempts: mfaChallengeAttempts,
+	})
+	if err != nil {
+		http.Error(writer, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := cfg.DBConn.GetUserByID(req.Context(), challenge.UserID)
+	if err != nil {
+		http.Error(writer, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+
+	// Wrong codes count as failed logins, so guessing across many challenges still runs into the account lockout
+	throttle := cfg.loginThrottle()
+	failures, wait, err := cfg.claimAccountAttempt(req.Context(), user, time.Now().UTC())
+	if err != nil {
+		http.Error(writer, "Failed to record attempt", http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		writeRetryAfter(writer, wait)
+		return
+	}
+
+	ok, err := cfg.checkSecondFactor(req.Context(), user, inObj.Code, inObj.RecoveryCode)
+	if err != nil {
+		http.Error(writer, "Failed to check code", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		cfg.logSecurityEvent(req.Context(), user.ID, "mfa_failed", clientIP(req))
+		if throttle.Account.LockoutAttempts > 0 && int(failures) == throttle.Account.LockoutAttempts {
+			cfg.logSecurityEvent(req.Context(), user.ID, "account_locked", clientIP(req))
+		}
+		http.Error(writer, "Incorrect code", http.StatusUnauthorized)
+		return
+	}
+
+	// Uses up the challenge so it can't start a second session
+	consumed, err := cfg.DBConn.ConsumeMFAChallenge(req.Context(), database.ConsumeMFAChallengeParams{
+		TokenHash: tokenHash,
+		UsedAt:    sql.NullTime{Time: time.Now().UTC(), Valid: true},
+	})
+	if err != nil {
+		http.Error(writer, "Failed to complete login", http.StatusInternalServerError)
+		return
+	}
+	if consumed == 0 {
+		http.Error(writer, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+
+	// Both factors have now passed, which clears the account's failures
+	if _, err := cfg.DBConn.ResetFailedLogins(req.Context(), user.ID); err != nil {
+		http.Error(writer, "Failed to record attempt", http.StatusInternalServerError)
+		return
+	}
+
+	cfg.issueSession(writer, req, user)
+}
+
+func (cfg *ApiConfig) checkSecondFactor(ctx context.Context, user database.User, code, recoveryCode string) (bool, error) {
+	// Accepts either a current TOTP code or an unused recovery code, which is then used up
+
+	if code != "" {
+		return cfg.checkTOTP(ctx, user, code)
+	}
+	if recoveryCode == "" {
+		return false, nil
+	}
+	used, err := cfg.DBConn.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{
+		CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(recoveryCode)),
+		UserID:   user.ID,
+		UsedAt:   sql.NullTime{Time: time.Now().UTC(), Valid: true},
+	})
+	if err != nil {
+		return false, err
+	}
+	return used == 1, nil
+}
+
+func (cfg *ApiConfig) checkTOTP(ctx context.Context, user database.User, code string) (bool, error) {
+	// Checks a TOTP code against the user's secret, refusing codes from a step that was already used
+
+	if !user.TotpSecret.Valid || len(cfg.MFAKey) == 0 {
+		return false, nil
+	}
+	secret, err := auth.DecryptSecret(cfg.MFAKey, user.TotpSecret.String)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := auth.ValidateTOTP(secret, code, time.Now(), totpAllowedStepsSkew)
+	if !ok {
+		return false, nil
+	}
+
+	// Recording the step atomically stops the same code being replayed within its window
+	advanced, err := cfg.DBConn.AdvanceTOTPStep(ctx, database.AdvanceTOTPStepParams{
+		ID:           user.ID,
+		TotpLastStep: step,
+	})
+	if err != nil {
+		return false, err
+	}
+	return advanced == 1, nil
+}
+
+func (cfg *ApiConfig) replaceRecoveryCodes(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	// Replaces the user's recovery codes with a fresh set and returns them in plain text
+
+	codes, err := auth.MakeRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.DBConn.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	for _, code := range codes {
+		err := cfg.DBConn.AddRecoveryCode(ctx, database.AddRecoveryCodeParams{
+			CodeHash:  auth.HashToken(code),
+			UserID:    userID,
+			CreatedAt: now,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
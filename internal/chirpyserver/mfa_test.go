@@ -0,0 +1,150 @@
+package chirpyserver
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
+	"github.com/roxensox/chirpy/internal/database"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoginMFAAttemptLimit(t *testing.T) {
+	user := database.User{ID: uuid.New(), Role: auth.RoleUser}
+	now := time.Now().UTC()
+
+	// Counts guesses the way ClaimMFAAttempt does, refusing once the limit is reached
+	var mu sync.Mutex
+	attempts, checked := 0, 0
+	_, conn := newFakeDB(map[string]fakeQuery{
+		"ClaimMFAAttempt": func(args []driver.NamedValue) (fakeResult, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			res := fakeResult{columns: []string{"token_hash", "user_id", "created_at", "expires_at", "attempts", "used_at"}}
+			if int64(attempts) < args[2].Value.(int64) {
+				attempts++
+				res.rows = [][]driver.Value{{args[0].Value, user.ID.String(), now, now.Add(time.Minute), int64(attempts), nil}}
+			}
+			return res, nil
+		},
+		"GetUserByID":       returns(userResult(user)),
+		"ClaimLoginAttempt": returns(fakeResult{columns: []string{"failed_logins"}, rows: [][]driver.Value{{int64(1)}}}),
+		"UseRecoveryCode": func([]driver.NamedValue) (fakeResult, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			checked++
+			return fakeResult{}, nil
+		},
+		"AddSecurityEvent": returns(fakeResult{affected: 1}),
+	})
+	cfg := &ApiConfig{DBConn: conn}
+
+	// Many wrong guesses at once still only get the allowed number checked
+	var wg sync.WaitGroup
+	for range 4 * mfaChallengeAttempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			cfg.POSTLoginMFA(rec, httptest.NewRequest("POST", "/api/login/mfa",
+				strings.NewReader(`{"mfa_token": "challenge", "recovery_code": "wrong"}`)))
+			if rec.Code != 401 {
+				t.Errorf("Expected 401 for a wrong code, got %d", rec.Code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if checked != mfaChallengeAttempts {
+		t.Errorf("Expected %d codes to be checked, got %d", mfaChallengeAttempts, checked)
+	}
+}
+
+func TestMFAFailuresLockAccount(t *testing.T) {
+	hash, err := auth.HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	now := time.Now().UTC()
+
+	// An account with two-factor authentication whose failed login count is kept across requests
+	var mu sync.Mutex
+	user := database.User{
+		ID:             uuid.New(),
+		Email:          "mfa@example.com",
+		HashedPassword: hash,
+		TotpEnabledAt:  sql.NullTime{Time: now, Valid: true},
+		Role:           auth.RoleUser,
+	}
+	current := func([]driver.NamedValue) (fakeResult, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return userResult(user), nil
+	}
+	var resets int
+	_, conn := newFakeDB(map[string]fakeQuery{
+		"GetUserByEmail":     current,
+		"GetUserByID":        current,
+		"ClaimLoginAttempt":  claimLoginAttempt(&mu, &user),
+		"CreateMFAChallenge": returns(fakeResult{affected: 1}),
+		"RefundLoginAttempt": func([]driver.NamedValue) (fakeResult, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			user.FailedLogins = max(user.FailedLogins-1, 0)
+			return fakeResult{affected: 1}, nil
+		},
+		"ResetFailedLogins": func([]driver.NamedValue) (fakeResult, error) {
+			resets++
+			return fakeResult{affected: 1}, nil
+		},
+		"ClaimMFAAttempt": func(args []driver.NamedValue) (fakeResult, error) {
+			return fakeResult{
+				columns: []string{"token_hash", "user_id", "created_at", "expires_at", "attempts", "used_at"},
+				rows:    [][]driver.Value{{args[0].Value, user.ID.String(), now, now.Add(time.Minute), int64(1), nil}},
+			}, nil
+		},
+		"UseRecoveryCode":  returns(fakeResult{}),
+		"AddSecurityEvent": returns(fakeResult{affected: 1}),
+	})
+	policy := BackoffPolicy{FreeAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour, Window: time.Hour}
+	cfg := &ApiConfig{DBConn: conn, LoginThrottle: NewLoginThrottle(policy, DefaultIPBackoff)}
+
+	login := func() (int, string) {
+		rec := httptest.NewRecorder()
+		cfg.POSTLogin(rec, httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"email": "mfa@example.com", "password": "correct horse"}`)))
+		out := struct {
+			MFAToken string `json:"mfa_token"`
+		}{}
+		json.Unmarshal(rec.Body.Bytes(), &out)
+		return rec.Code, out.MFAToken
+	}
+
+	// Each round knows the password and gets a fresh challenge, but the wrong codes still add up
+	for round := range policy.FreeAttempts {
+		code, challenge := login()
+		if code != 200 || challenge == "" {
+			t.Fatalf("Round %d: expected a challenge for the right password, got %d", round+1, code)
+		}
+		rec := httptest.NewRecorder()
+		cfg.POSTLoginMFA(rec, httptest.NewRequest("POST", "/api/login/mfa",
+			strings.NewReader(`{"mfa_token": "`+challenge+`", "recovery_code": "wrong"}`)))
+		if rec.Code != 401 {
+			t.Errorf("Round %d: expected 401 for a wrong code, got %d", round+1, rec.Code)
+		}
+	}
+
+	if int(user.FailedLogins) != policy.FreeAttempts {
+		t.Errorf("Expected %d failures counted, got %d", policy.FreeAttempts, user.FailedLogins)
+	}
+	if resets != 0 {
+		t.Errorf("Expected the right password alone not to clear the failures")
+	}
+	if code, _ := login(); code != 429 {
+		t.Errorf("Expected the account to be throttled before another challenge, got %d", code)
+	}
+}
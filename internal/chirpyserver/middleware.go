@@ -0,0 +1,86 @@
+package chirpyserver
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
+	"net/http"
+)
+
+type principalKey struct{}
+
+// Principal is the caller a request was authenticated as
+type Principal struct {
+	UserID uuid.UUID
+	// What the credential may do; every scope for a password login
+	Scopes []string
+	// The access token's claims, nil when the caller used a personal access token
+	Claims *auth.AccessClaims
+}
+
+func (cfg *ApiConfig) RequireAuth(scope string, next http.HandlerFunc) http.HandlerFunc {
+	// Wraps next so it only runs for callers whose token carries scope, with the caller in the request context.
+	// An empty scope accepts any valid token
+
+	return func(writer http.ResponseWriter, req *http.Request) {
+		p, err := cfg.authenticate(req, scope)
+		if err != nil {
+			writeTokenError(writer, err)
+			return
+		}
+		next(writer, req.WithContext(WithPrincipal(req.Context(), p)))
+	}
+}
+
+func (cfg *ApiConfig) OptionalAuth(scope string, next http.HandlerFunc) http.HandlerFunc {
+	// Wraps next so callers whose token carries scope are put in the request context; everyone else,
+	// including callers with a stale or unsuitable token, is served anonymously
+
+	return func(writer http.ResponseWriter, req *http.Request) {
+		if p, err := cfg.authenticate(req, scope); err == nil {
+			req = req.WithContext(WithPrincipal(req.Context(), p))
+		}
+		next(writer, req)
+	}
+}
+
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	// Returns a copy of ctx carrying p
+
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	// Returns the caller stored in ctx by RequireAuth or OptionalAuth, if there is one
+
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+func UserIDFrom(ctx context.Context) (uuid.UUID, bool) {
+	// Returns the ID of the caller stored in ctx, if there is one
+
+	p, ok := PrincipalFrom(ctx)
+	return p.UserID, ok
+}
+
+func currentUser(writer http.ResponseWriter, req *http.Request) (uuid.UUID, bool) {
+	// Returns the caller's ID for handlers behind RequireAuth, answering 401 itself if the route was
+	// registered without it so a wiring mistake fails closed
+
+	UID, ok := UserIDFrom(req.Context())
+	if !ok {
+		writeTokenError(writer, errMissingToken)
+	}
+	return UID, ok
+}
+
+func viewer(req *http.Request) (uuid.UUID, bool) {
+	// Returns the caller's ID if they're signed in with a token that may read chirps
+
+	p, ok := PrincipalFrom(req.Context())
+	if !ok || !auth.HasScope(p.Scopes, auth.ScopeChirpsRead) {
+		return uuid.UUID{}, false
+	}
+	return p.UserID, true
+}
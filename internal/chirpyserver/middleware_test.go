@@ -0,0 +1,81 @@
+package chirpyserver
+
+import (
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	keys := auth.NewKeySet()
+	keys.Add(auth.NewHMACKey("default", "secret"))
+	keys.SetSigningKey("default")
+	cfg := &ApiConfig{Keys: keys}
+
+	userID := uuid.New()
+	login, _ := auth.MakeJWT(userID, 0, keys, time.Hour)
+	readOnly, _ := auth.MakeScopedJWT(userID, 0, keys, time.Hour, uuid.NewString(), []string{auth.ScopeChirpsRead})
+	expired, _ := auth.MakeJWT(userID, 0, keys, -time.Minute)
+
+	// Records what the wrapped handler saw
+	var (
+		called bool
+		seen   uuid.UUID
+		found  bool
+	)
+	next := func(writer http.ResponseWriter, req *http.Request) {
+		called = true
+		seen, found = UserIDFrom(req.Context())
+		writer.WriteHeader(204)
+	}
+
+	test_cases := []struct {
+		name    string
+		handler http.HandlerFunc
+		token   string
+		status  int
+		called  bool
+		found   bool
+	}{
+		{name: "required, no token", handler: cfg.RequireAuth(auth.ScopeChirpsWrite, next), status: 401},
+		{name: "required, expired", handler: cfg.RequireAuth(auth.ScopeChirpsWrite, next), token: expired, status: 401},
+		{name: "required, missing scope", handler: cfg.RequireAuth(auth.ScopeChirpsWrite, next), token: readOnly, status: 403},
+		{name: "required, granted", handler: cfg.RequireAuth(auth.ScopeChirpsWrite, next), token: login, status: 204, called: true, found: true},
+		{name: "required, any scope", handler: cfg.RequireAuth("", next), token: readOnly, status: 204, called: true, found: true},
+		{name: "optional, no token", handler: cfg.OptionalAuth(auth.ScopeChirpsRead, next), status: 204, called: true},
+		{name: "optional, expired", handler: cfg.OptionalAuth(auth.ScopeChirpsRead, next), token: expired, status: 204, called: true},
+		{name: "optional, missing scope", handler: cfg.OptionalAuth(auth.ScopeAccount, next), token: readOnly, status: 204, called: true},
+		{name: "optional, granted", handler: cfg.OptionalAuth(auth.ScopeChirpsRead, next), token: readOnly, status: 204, called: true, found: true},
+	}
+
+	for _, c := range test_cases {
+		t.Run(c.name, func(t *testing.T) {
+			called, seen, found = false, uuid.UUID{}, false
+			req := httptest.NewRequest("GET", "/", nil)
+			if c.token != "" {
+				req.Header.Set("Authorization", "Bearer "+c.token)
+			}
+			rec := httptest.NewRecorder()
+			c.handler(rec, req)
+
+			if rec.Code != c.status {
+				t.Errorf("Expected status %d, got %d", c.status, rec.Code)
+			}
+			if called != c.called {
+				t.Errorf("Expected handler called to be %v", c.called)
+			}
+			if found != c.found || (found && seen != userID) {
+				t.Errorf("Expected principal %v, got %v (%s)", c.found, found, seen)
+			}
+		})
+	}
+
+	// A handler registered without the middleware refuses rather than acting as nobody
+	rec := httptest.NewRecorder()
+	if _, ok := currentUser(rec, httptest.NewRequest("GET", "/", nil)); ok || rec.Code != 401 {
+		t.Errorf("Expected currentUser to fail with 401, got %d", rec.Code)
+	}
+}
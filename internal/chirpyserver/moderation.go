@@ -0,0 +1,38 @@
+package chirpyserver
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+func (cfg *ApiConfig) POSTReloadModeration(writer http.ResponseWriter, req *http.Request) {
+	// Handles POST requests at admin/moderation/reload, reloads the flagged word list from its source
+
+	if cfg.Moderation == nil {
+		http.Error(writer, "Moderation is not configured", http.StatusNotFound)
+		return
+	}
+
+	// Reloads the list; the old list stays active if this fails
+	count, err := cfg.Moderation.Reload(req.Context())
+	if err != nil {
+		log.Printf("Failed to reload moderation words: %v", err)
+		http.Error(writer, "Failed to reload moderation words", http.StatusInternalServerError)
+		return
+	}
+
+	outJson, err := json.Marshal(struct {
+		Words int `json:"words"`
+	}{
+		Words: count,
+	})
+	if err != nil {
+		http.Error(writer, "Failed to marshal data", http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(200)
+	writer.Write(outJson)
+}
@@ -0,0 +1,397 @@
+package chirpyserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
+	"github.com/roxensox/chirpy/internal/database"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	authorizationCodeLifetime = 5 * time.Minute
+	maxRedirectURIs           = 10
+)
+
+// oauthError is an error from RFC 6749, sent back to the client rather than shown to the user
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *oauthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// authorizationRequest is a parsed request to the authorize endpoint
+type authorizationRequest struct {
+	client        database.OauthClient
+	redirectURI   string
+	scopes        []string
+	state         string
+	codeChallenge string
+}
+
+func validRedirectURI(s string) bool {
+	// Reports whether s can be registered as a redirect URI: absolute, without a fragment, and https unless it's local
+
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" || u.Fragment != "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+	return false
+}
+
+func (cfg *ApiConfig) POSTOAuthClients(writer http.ResponseWriter, req *http.Request) {
+	// Handles POST requests at oauth/clients, registers a third-party app owned by the caller
+
+	UID, ok := currentUser(writer, req)
+	if !ok {
+		return
+	}
+
+	inObj := struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Confidential bool     `json:"confidential"`
+	}{}
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&inObj); err != nil {
+		http.Error(writer, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(inObj.Name)
+	if name == "" || len(name) > 100 {
+		http.Error(writer, "Clients need a name of at most 100 characters", http.StatusBadRequest)
+		return
+	}
+	if len(inObj.RedirectURIs) == 0 || len(inObj.RedirectURIs) > maxRedirectURIs {
+		http.Error(writer, "Clients need between 1 and 10 redirect URIs", http.StatusBadRequest)
+		return
+	}
+	for _, uri := range inObj.RedirectURIs {
+		if !validRedirectURI(uri) {
+			http.Error(writer, "Redirect URIs must be absolute https URLs without a fragment: "+uri, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Confidential clients, ones that run on a server, get a secret; public ones rely on PKCE alone
+	var secret string
+	var secretHash sql.NullString
+	if inObj.Confidential {
+		var err error
+		secret, err = auth.MakeClientSecret()
+		if err != nil {
+			http.Error(writer, "Failed to generate client secret", http.StatusInternalServerError)
+			return
+		}
+		secretHash = sql.NullString{String: auth.HashClientSecret(cfg.TokenHashKey, secret), Valid: true}
+	}
+
+	client, err := cfg.DBConn.CreateOAuthClient(req.Context(), database.CreateOAuthClientParams{
+		ID:           uuid.New(),
+		OwnerID:      UID,
+		Name:         name,
+		SecretHash:   secretHash,
+		RedirectUris: inObj.RedirectURIs,
+		CreatedAt:    time.Now().UTC(),
+	})
+	if err != nil {
+		http.Error(writer, "Failed to register client", http.StatusInternalServerError)
+		return
+	}
+
+	// Only the hash is stored, so this response is the one chance to see the secret
+	outJson, err := json.Marshal(OAuthClient{
+		ID:           client.ID,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectUris,
+		Confidential: client.SecretHash.Valid,
+		CreatedAt:    client.CreatedAt,
+		Secret:       secret,
+	})
+	if err != nil {
+		http.Error(writer, "Failed to marshal data", http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(201)
+	writer.Write(outJson)
+}
+
+func (cfg *ApiConfig) parseAuthorizationRequest(req *http.Request) (authorizationRequest, error) {
+	// Checks an authorization request; problems with the client or redirect URI are plain errors that must be
+	// shown to the user, anything after that is an *oauthError to send back to the client at ar.redirectURI
+
+	var ar authorizationRequest
+	clientID, err := uuid.Parse(req.FormValue("client_id"))
+	if err != nil {
+		return ar, errors.New("Invalid client_id")
+	}
+	ar.client, err = cfg.DBConn.GetOAuthClient(req.Context(), clientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ar, errors.New("Unknown client")
+	}
+	if err != nil {
+		return ar, err
+	}
+
+	// The redirect URI must match a registered one exactly, otherwise codes could be sent anywhere
+	ar.redirectURI = req.FormValue("redirect_uri")
+	if !slices.Contains(ar.client.RedirectUris, ar.redirectURI) {
+		return ar, errors.New("redirect_uri is not registered for this client")
+	}
+	ar.state = req.FormValue("state")
+
+	if req.FormValue("response_type") != "code" {
+		return ar, &oauthError{"unsupported_response_type", "Only the code response type is supported"}
+	}
+	ar.scopes, err = auth.ParseScopes(strings.Fields(req.FormValue("scope")))
+	if err != nil {
+		return ar, &oauthError{"invalid_scope", err.Error()}
+	}
+
+	// PKCE is required of every client, and only with S256 since plain offers no protection
+	ar.codeChallenge = req.FormValue("code_challenge")
+	if req.FormValue("code_challenge_method") != "S256" || !auth.ValidPKCEValue(ar.codeChallenge) {
+		return ar, &oauthError{"invalid_request", "A code_challenge using the S256 method is required"}
+	}
+	return ar, nil
+}
+
+func (cfg *ApiConfig) GETOAuthAuthorize(writer http.ResponseWriter, req *http.Request) {
+	// Handles GET requests at oauth/authorize, describes an authorization request so the caller can be asked to consent
+
+	UID, ok := currentUser(writer, req)
+	if !ok {
+		return
+	}
+
+	ar, err := cfg.parseAuthorizationRequest(req)
+	var oe *oauthError
+	if errors.As(err, &oe) {
+		writeAuthorizeRedirect(writer, ar, url.Values{"error": {oe.Code}, "error_description": {oe.Description}})
+		return
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Tells the frontend whether the user has already granted everything asked for, so it can skip the prompt
+	consent, err := cfg.DBConn.GetOAuthConsent(req.Context(), database.GetOAuthConsentParams{
+		UserID:   UID,
+		ClientID: ar.client.ID,
+	})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		http.Error(writer, "Unable to get consent", http.StatusInternalServerError)
+		return
+	}
+	consented := err == nil
+	for _, s := range ar.scopes {
+		consented = consented && auth.HasScope(consent.Scopes, s)
+	}
+
+	outJson, err := json.Marshal(OAuthAuthorization{
+		ClientID:   ar.client.ID,
+		ClientName: ar.client.Name,
+		Scopes:     ar.scopes,
+		Consented:  consented,
+	})
+	if err != nil {
+		http.Error(writer, "Failed to marshal data", http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(200)
+	writer.Write(outJson)
+}
+
+func (cfg *ApiConfig) POSTOAuthAuthorize(writer http.ResponseWriter, req *http.Request) {
+	// Handles POST requests at oauth/authorize, records the caller's answer and returns where to send them back to
+
+	UID, ok := currentUser(writer, req)
+	if !ok {
+		return
+	}
+
+	ar, err := cfg.parseAuthorizationRequest(req)
+	var oe *oauthError
+	if errors.As(err, &oe) {
+		writeAuthorizeRedirect(writer, ar, url.Values{"error": {oe.Code}, "error_description": {oe.Description}})
+		return
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.FormValue("approve") != "true" {
+		writeAuthorizeRedirect(writer, ar, url.Values{"error": {"access_denied"}})
+		return
+	}
+
+	// Remembers what the user agreed to, adding to anything granted before
+	now := time.Now().UTC()
+	_, err = cfg.DBConn.UpsertOAuthConsent(req.Context(), database.UpsertOAuthConsentParams{
+		UserID:    UID,
+		ClientID:  ar.client.ID,
+		Scopes:    ar.scopes,
+		CreatedAt: now,
+	})
+	if err != nil {
+		http.Error(writer, "Failed to record consent", http.StatusInternalServerError)
+		return
+	}
+
+	// The code is bound to the client, redirect URI, scopes and PKCE challenge, and only its hash is stored
+	code, err := auth.MakeAuthorizationCode()
+	if err != nil {
+		http.Error(writer, "Failed to generate code", http.StatusInternalServerError)
+		return
+	}
+	err = cfg.DBConn.CreateAuthorizationCode(req.Context(), database.CreateAuthorizationCodeParams{
+		CodeHash:      auth.HashToken(code),
+		ClientID:      ar.client.ID,
+		UserID:        UID,
+		RedirectUri:   ar.redirectURI,
+		Scopes:        ar.scopes,
+		CodeChallenge: ar.codeChallenge,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(authorizationCodeLifetime),
+	})
+	if err != nil {
+		http.Error(writer, "Failed to create code", http.StatusInternalServerError)
+		return
+	}
+	cfg.logSecurityEvent(req.Context(), UID, "oauth_consent", ar.client.ID.String()+" "+strings.Join(ar.scopes, ","))
+
+	writeAuthorizeRedirect(writer, ar, url.Values{"code": {code}})
+}
+
+func writeAuthorizeRedirect(writer http.ResponseWriter, ar authorizationRequest, params url.Values) {
+	// Writes the URL the frontend should send the user to, the client's redirect URI with params and the state added
+
+	// Registered URIs were validated, so this only fails if the stored data is corrupt
+	u, err := url.Parse(ar.redirectURI)
+	if err != nil {
+		http.Error(writer, "Invalid redirect_uri", http.StatusInternalServerError)
+		return
+	}
+	query := u.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	if ar.state != "" {
+		query.Set("state", ar.state)
+	}
+	u.RawQuery = query.Encode()
+
+	outJson, err := json.Marshal(struct {
+		RedirectTo string `json:"redirect_to"`
+	}{
+		RedirectTo: u.String(),
+	})
+	if err != nil {
+		http.Error(writer, "Failed to marshal data", http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(200)
+	writer.Write(outJson)
+}
+
+func (cfg *ApiConfig) GETOAuthConsents(writer http.ResponseWriter, req *http.Request) {
+	// Handles GET requests at oauth/consents, lists the apps the caller has authorized
+
+	UID, ok := currentUser(writer, req)
+	if !ok {
+		return
+	}
+
+	rows, err := cfg.DBConn.ListOAuthConsents(req.Context(), UID)
+	if err != nil {
+		http.Error(writer, "Unable to get consents", http.StatusInternalServerError)
+		return
+	}
+
+	out := make([]OAuthConsent, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, OAuthConsent{
+			ClientID:   r.ClientID,
+			ClientName: r.ClientName,
+			Scopes:     r.Scopes,
+			CreatedAt:  r.CreatedAt,
+			UpdatedAt:  r.UpdatedAt,
+		})
+	}
+
+	outJson, err := json.Marshal(out)
+	if err != nil {
+		http.Error(writer, "Failed to marshal data", http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(200)
+	writer.Write(outJson)
+}
+
+func (cfg *ApiConfig) DELETEOAuthConsent(writer http.ResponseWriter, req *http.Request) {
+	// Handles DELETE requests at oauth/consents/{clientID}, withdraws an app's access and ends its sessions
+
+	UID, ok := currentUser(writer, req)
+	if !ok {
+		return
+	}
+
+	clientID, err := uuid.Parse(req.PathValue("clientID"))
+	if err != nil {
+		http.Error(writer, "Invalid client ID", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := cfg.DBConn.DeleteOAuthConsent(req.Context(), database.DeleteOAuthConsentParams{
+		UserID:   UID,
+		ClientID: clientID,
+	})
+	if err != nil {
+		http.Error(writer, "Failed to withdraw consent", http.StatusInternalServerError)
+		return
+	}
+	if deleted == 0 {
+		http.Error(writer, "Consent not found", http.StatusNotFound)
+		return
+	}
+
+	// Access tokens already issued run out within the hour, refresh tokens stop now
+	err = cfg.DBConn.RevokeClientUserTokens(req.Context(), database.RevokeClientUserTokensParams{
+		UserID:    UID,
+		ClientID:  uuid.NullUUID{UUID: clientID, Valid: true},
+		RevokedAt: sql.NullTime{Time: time.Now().UTC(), Valid: true},
+	})
+	if err != nil {
+		http.Error(writer, "Failed to revoke client sessions", http.StatusInternalServerError)
+		return
+	}
+	cfg.logSecurityEvent(req.Context(), UID, "oauth_consent_withdrawn", clientID.String())
+
+	writer.WriteHeader(204)
+}
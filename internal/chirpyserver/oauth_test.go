@@ -0,0 +1,421 @@
+package chirpyserver
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// oauthStore keeps the rows the OAuth flow reads and writes, stored in each table's column order
+type oauthStore struct {
+	mu       sync.Mutex
+	clients  map[string][]driver.Value
+	consents map[string][]driver.Value
+	codes    map[string][]driver.Value
+	refresh  map[string][]driver.Value
+}
+
+func newOAuthStore() *oauthStore {
+	return &oauthStore{
+		clients:  map[string][]driver.Value{},
+		consents: map[string][]driver.Value{},
+		codes:    map[string][]driver.Value{},
+		refresh:  map[string][]driver.Value{},
+	}
+}
+
+func argValues(args []driver.NamedValue) []driver.Value {
+	out := make([]driver.Value, len(args))
+	for i, a := range args {
+		out[i] = a.Value
+	}
+	return out
+}
+
+func (s *oauthStore) queries() map[string]fakeQuery {
+	// Answers the queries the OAuth handlers run, enough to follow the flow end to end
+
+	var (
+		clientCols  = []string{"id", "owner_id", "name", "secret_hash", "redirect_uris", "created_at"}
+		consentCols = []string{"user_id", "client_id", "scopes", "created_at", "updated_at"}
+		codeCols    = []string{"code_hash", "client_id", "user_id", "redirect_uri", "scopes", "code_challenge", "created_at", "expires_at", "used_at", "family_id"}
+		refreshCols = []string{"token_hash", "created_at", "updated_at", "expires_at", "revoked_at", "user_id", "family_id", "replaced_at", "user_agent", "ip_address", "last_used_at", "client_id", "scopes"}
+	)
+	one := func(cols []string, row []driver.Value) fakeResult {
+		res := fakeResult{columns: cols}
+		if row != nil {
+			res.rows = [][]driver.Value{row}
+		}
+		return res
+	}
+	locked := func(fn fakeQuery) fakeQuery {
+		return func(args []driver.NamedValue) (fakeResult, error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return fn(args)
+		}
+	}
+
+	return map[string]fakeQuery{
+		"CreateOAuthClient": locked(func(args []driver.NamedValue) (fakeResult, error) {
+			row := argValues(args)
+			s.clients[row[0].(string)] = row
+			return one(clientCols, row), nil
+		}),
+		"GetOAuthClient": locked(func(args []driver.NamedValue) (fakeResult, error) {
+			return one(clientCols, s.clients[args[0].Value.(string)]), nil
+		}),
+		"GetOAuthConsent": locked(func(args []driver.NamedValue) (fakeResult, error) {
+			return one(consentCols, s.consents[args[0].Value.(string)+"/"+args[1].Value.(string)]), nil
+		}),
+		"UpsertOAuthConsent": locked(func(args []driver.NamedValue) (fakeResult, error) {
+			v := argValues(args)
+			row := []driver.Value{v[0], v[1], v[2], v[3], v[3]}
+			s.consents[v[0].(string)+"/"+v[1].(string)] = row
+			return one(consentCols, row), nil
+		}),
+		"CreateAuthorizationCode": locked(func(args []driver.NamedValue) (fakeResult, error) {
+			row := append(argValues(args), nil, nil)
+			s.codes[row[0].(string)] = row
+			return fakeResult{affected: 1}, nil
+		}),
+		"ConsumeAuthorizationCode": locked(func(args []driver.NamedValue) (fakeResult, error) {
+			row := s.codes[args[0].Value.(string)]
+			usedAt := args[1].Value.(time.Time)
+			if row == nil || row[8] != nil || !row[7].(time.Time).After(usedAt) {
+				return one(codeCols, nil), nil
+			}
+			row[8], row[9] = usedAt, args[2].Value
+			return one(codeCols, row), nil
+		}),
+		"GetAuthorizationCodeAnyState": locked(func(args []driver.NamedValue) (fakeResult, error) {
+			return one(codeCols, s.codes[args[0].Value.(string)]), nil
+		}),
+		"GetTokenVersion": returns(fakeResult{columns: []string{"token_version"}, rows: [][]driver.Value{{int64(0)}}}),
+		"AddRefreshToken": locked(func(args []driver.NamedValue) (fakeResult, error) {
+			v := argValues(args)
+			row := []driver.Value{v[0], v[1], v[2], v[3], nil, v[4], v[5], nil, v[6], v[7], v[8], v[9], v[10]}
+			s.refresh[v[0].(string)] = row
+			return fakeResult{affected: 1}, nil
+		}),
+		"GetToken": locked(func(args []driver.NamedValue) (fakeResult, error) {
+			row := s.refresh[args[0].Value.(string)]
+			if row == nil || row[4] != nil || row[7] != nil || !row[3].(time.Time).After(args[1].Value.(time.Time)) {
+				return one(refreshCols, nil), nil
+			}
+			return one(refreshCols, row), nil
+		}),
+		"GetRefreshTokenAnyState": locked(func(args []driver.NamedValue) (fakeResult, error) {
+			return one(refreshCols, s.refresh[args[0].Value.(string)]), nil
+		}),
+		"RotateRefreshToken": locked(func(args []driver.NamedValue) (fakeResult, error) {
+			row := s.refresh[args[0].Value.(string)]
+			if row == nil || row[4] != nil || row[7] != nil {
+				return fakeResult{}, nil
+			}
+			row[7] = args[1].Value
+			return fakeResult{affected: 1}, nil
+		}),
+		"RevokeTokenFamily": locked(func(args []driver.NamedValue) (fakeResult, error) {
+			res := fakeResult{}
+			for _, row := range s.refresh {
+				if row[6] == args[0].Value && row[4] == nil {
+					row[4] = args[1].Value
+					res.affected++
+				}
+			}
+			return res, nil
+		}),
+		"AddSecurityEvent": returns(fakeResult{affected: 1}),
+	}
+}
+
+// oauthTestClient plays a third-party app talking to the server over HTTP
+type oauthTestClient struct {
+	t           *testing.T
+	server      *httptest.Server
+	id, secret  string
+	redirectURI string
+}
+
+func (c *oauthTestClient) do(req *http.Request) (int, []byte) {
+	c.t.Helper()
+	resp, err := c.server.Client().Do(req)
+	if err != nil {
+		c.t.Fatalf("Request to %s failed: %v", req.URL, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, body
+}
+
+func (c *oauthTestClient) authorize(userToken, scope, verifier string) string {
+	// Walks the user through consent as the Chirpy frontend would and returns the code sent to the redirect URI
+
+	c.t.Helper()
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.id},
+		"redirect_uri":          {c.redirectURI},
+		"scope":                 {scope},
+		"state":                 {"xyz"},
+		"code_challenge":        {auth.PKCEChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+		"approve":               {"true"},
+	}
+	req := httptest.NewRequest("POST", c.server.URL+"/api/oauth/authorize", strings.NewReader(params.Encode()))
+	req.RequestURI = ""
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+userToken)
+	status, body := c.do(req)
+	if status != 200 {
+		c.t.Fatalf("Authorize returned %d: %s", status, body)
+	}
+
+	out := struct {
+		RedirectTo string `json:"redirect_to"`
+	}{}
+	json.Unmarshal(body, &out)
+	u, err := url.Parse(out.RedirectTo)
+	if err != nil || !strings.HasPrefix(out.RedirectTo, c.redirectURI) {
+		c.t.Fatalf("Unexpected redirect %q", out.RedirectTo)
+	}
+	if u.Query().Get("state") != "xyz" {
+		c.t.Errorf("State was not passed back: %q", out.RedirectTo)
+	}
+	return u.Query().Get("code")
+}
+
+func (c *oauthTestClient) token(params url.Values) (int, OAuthToken, string) {
+	// Calls the token endpoint with the client's credentials, returning the status, tokens and any error code
+
+	c.t.Helper()
+	req := httptest.NewRequest("POST", c.server.URL+"/api/oauth/token", strings.NewReader(params.Encode()))
+	req.RequestURI = ""
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(c.id, c.secret)
+	status, body := c.do(req)
+
+	var tkn OAuthToken
+	var oe oauthError
+	json.Unmarshal(body, &tkn)
+	json.Unmarshal(body, &oe)
+	return status, tkn, oe.Code
+}
+
+func (c *oauthTestClient) call(path, token string) int {
+	c.t.Helper()
+	req := httptest.NewRequest("GET", c.server.URL+path, nil)
+	req.RequestURI = ""
+	req.Header.Set("Authorization", "Bearer "+token)
+	status, _ := c.do(req)
+	return status
+}
+
+func TestOAuthFlow(t *testing.T) {
+	store := newOAuthStore()
+	_, conn := newFakeDB(store.queries())
+	keys := auth.NewKeySet()
+	keys.Add(auth.NewHMACKey("default", "secret"))
+	keys.SetSigningKey("default")
+	cfg := &ApiConfig{DBConn: conn, Keys: keys, TokenHashKey: []byte("token hash key")}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/oauth/clients", cfg.RequireAuth(auth.ScopeAccount, cfg.POSTOAuthClients))
+	mux.HandleFunc("POST /api/oauth/authorize", cfg.RequireAuth(auth.ScopeAccount, cfg.POSTOAuthAuthorize))
+	mux.HandleFunc("POST /api/oauth/token", cfg.POSTOAuthToken)
+	mux.HandleFunc("POST /api/refresh", cfg.POSTRefresh)
+	// Stand in for any handler that needs a scope
+	for _, scope := range auth.AllScopes {
+		mux.HandleFunc("GET /scoped/"+scope, cfg.RequireAuth(scope, func(writer http.ResponseWriter, req *http.Request) {
+			writer.WriteHeader(204)
+		}))
+	}
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	userID := uuid.New()
+	userToken, err := auth.MakeJWT(userID, 0, keys, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to make JWT: %v", err)
+	}
+
+	// Registers a confidential client as its developer would
+	client := &oauthTestClient{t: t, server: server, redirectURI: "https://app.example/callback"}
+	req := httptest.NewRequest("POST", server.URL+"/api/oauth/clients", strings.NewReader(
+		`{"name": "Example App", "redirect_uris": ["https://app.example/callback"], "confidential": true}`,
+	))
+	req.RequestURI = ""
+	req.Header.Set("Authorization", "Bearer "+userToken)
+	status, body := client.do(req)
+	if status != 201 {
+		t.Fatalf("Registering a client returned %d: %s", status, body)
+	}
+	registered := OAuthClient{}
+	json.Unmarshal(body, &registered)
+	client.id, client.secret = registered.ID.String(), registered.Secret
+	if !strings.HasPrefix(client.secret, auth.ClientSecretPrefix) {
+		t.Fatalf("Expected a client secret, got %q", client.secret)
+	}
+
+	// Exchanges a code for tokens limited to what the user granted
+	verifier := strings.Repeat("v", 43)
+	code := client.authorize(userToken, "chirps:read", verifier)
+	status, tkn, errCode := client.token(url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {client.redirectURI},
+		"code_verifier": {verifier},
+	})
+	if status != 200 {
+		t.Fatalf("Token exchange returned %d: %s", status, errCode)
+	}
+	if tkn.Scope != "chirps:read" || tkn.TokenType != "Bearer" {
+		t.Errorf("Unexpected token response %+v", tkn)
+	}
+	for scope, expected := range map[string]int{
+		auth.ScopeChirpsRead:  204,
+		auth.ScopeChirpsWrite: 403,
+		auth.ScopeAccount:     403,
+	} {
+		if got := client.call("/scoped/"+scope, tkn.AccessToken); got != expected {
+			t.Errorf("Using the access token for %s returned %d, expected %d", scope, got, expected)
+		}
+	}
+
+	// A code only works once, and replaying it ends the session it started
+	status, _, errCode = client.token(url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {client.redirectURI},
+		"code_verifier": {verifier},
+	})
+	if status != 400 || errCode != "invalid_grant" {
+		t.Errorf("Replaying a code returned %d %q, expected 400 invalid_grant", status, errCode)
+	}
+	status, _, _ = client.token(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tkn.RefreshToken}})
+	if status != 400 {
+		t.Errorf("Refresh token from a replayed code should be revoked, got %d", status)
+	}
+
+	// Refreshing keeps the granted scopes, and only works at the token endpoint
+	code = client.authorize(userToken, "chirps:read chirps:write", verifier)
+	_, tkn, _ = client.token(url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {client.redirectURI},
+		"code_verifier": {verifier},
+	})
+	req = httptest.NewRequest("POST", server.URL+"/api/refresh", nil)
+	req.RequestURI = ""
+	req.Header.Set("Authorization", "Bearer "+tkn.RefreshToken)
+	if status, _ := client.do(req); status != 401 {
+		t.Errorf("First-party refresh of a client's token returned %d, expected 401", status)
+	}
+	status, refreshed, errCode := client.token(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tkn.RefreshToken}})
+	if status != 200 {
+		t.Fatalf("Refresh returned %d: %s", status, errCode)
+	}
+	if refreshed.Scope != "chirps:read chirps:write" {
+		t.Errorf("Refresh changed the scope to %q", refreshed.Scope)
+	}
+	if got := client.call("/scoped/"+auth.ScopeChirpsWrite, refreshed.AccessToken); got != 204 {
+		t.Errorf("Refreshed access token returned %d, expected 204", got)
+	}
+}
+
+func TestOAuthTokenRejections(t *testing.T) {
+	store := newOAuthStore()
+	_, conn := newFakeDB(store.queries())
+	keys := auth.NewKeySet()
+	keys.Add(auth.NewHMACKey("default", "secret"))
+	keys.SetSigningKey("default")
+	cfg := &ApiConfig{DBConn: conn, Keys: keys, TokenHashKey: []byte("token hash key")}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/oauth/authorize", cfg.RequireAuth(auth.ScopeAccount, cfg.POSTOAuthAuthorize))
+	mux.HandleFunc("POST /api/oauth/token", cfg.POSTOAuthToken)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	userToken, _ := auth.MakeJWT(uuid.New(), 0, keys, time.Hour)
+
+	// A public client, which authenticates with its ID alone
+	clientID := uuid.New()
+	store.clients[clientID.String()] = []driver.Value{
+		clientID.String(), uuid.NewString(), "Public App", nil, `{"http://localhost/cb"}`, time.Now().UTC(),
+	}
+	client := &oauthTestClient{t: t, server: server, id: clientID.String(), redirectURI: "http://localhost/cb"}
+	verifier := strings.Repeat("w", 64)
+
+	test_cases := []struct {
+		name     string
+		params   func(code string) url.Values
+		secret   string
+		status   int
+		expected string
+	}{
+		{
+			name: "wrong verifier",
+			params: func(code string) url.Values {
+				return url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {client.redirectURI}, "code_verifier": {strings.Repeat("x", 64)}}
+			},
+			status:   400,
+			expected: "invalid_grant",
+		},
+		{
+			name: "missing verifier",
+			params: func(code string) url.Values {
+				return url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {client.redirectURI}}
+			},
+			status:   400,
+			expected: "invalid_grant",
+		},
+		{
+			name: "different redirect_uri",
+			params: func(code string) url.Values {
+				return url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {"http://localhost/other"}, "code_verifier": {verifier}}
+			},
+			status:   400,
+			expected: "invalid_grant",
+		},
+		{
+			name: "public client sending a secret",
+			params: func(code string) url.Values {
+				return url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {client.redirectURI}, "code_verifier": {verifier}}
+			},
+			secret:   "guess",
+			status:   401,
+			expected: "invalid_client",
+		},
+		{
+			name: "unsupported grant",
+			params: func(code string) url.Values {
+				return url.Values{"grant_type": {"password"}}
+			},
+			status:   400,
+			expected: "unsupported_grant_type",
+		},
+	}
+
+	for _, c := range test_cases {
+		t.Run(c.name, func(t *testing.T) {
+			client.t = t
+			client.secret = c.secret
+			code := client.authorize(userToken, "chirps:read", verifier)
+			status, _, errCode := client.token(c.params(code))
+			if status != c.status || errCode != c.expected {
+				t.Errorf("Got %d %q, expected %d %q", status, errCode, c.status, c.expected)
+			}
+		})
+	}
+}
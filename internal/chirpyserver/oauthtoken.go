@@ -0,0 +1,206 @@
+package chirpyserver
+
+import (
+	"crypto/hmac"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
+	"github.com/roxensox/chirpy/internal/database"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (cfg *ApiConfig) POSTOAuthToken(writer http.ResponseWriter, req *http.Request) {
+	// Handles POST requests at oauth/token, exchanges an authorization code or refresh token for a token pair
+
+	// Responses carry credentials, so nothing along the way may keep a copy
+	writer.Header().Set("Cache-Control", "no-store")
+
+	client, err := cfg.authenticateClient(req)
+	if err != nil {
+		var oe *oauthError
+		if !errors.As(err, &oe) {
+			http.Error(writer, "Unable to authenticate client", http.StatusInternalServerError)
+			return
+		}
+		if _, _, ok := req.BasicAuth(); ok {
+			writer.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+		}
+		writeOAuthError(writer, http.StatusUnauthorized, oe)
+		return
+	}
+
+	switch req.PostFormValue("grant_type") {
+	case "authorization_code":
+		cfg.exchangeAuthorizationCode(writer, req, client)
+	case "refresh_token":
+		cfg.refreshOAuthToken(writer, req, client)
+	default:
+		writeOAuthError(writer, http.StatusBadRequest, &oauthError{"unsupported_grant_type", "Use authorization_code or refresh_token"})
+	}
+}
+
+func (cfg *ApiConfig) authenticateClient(req *http.Request) (database.OauthClient, error) {
+	// Identifies the client from HTTP Basic auth or the request body; confidential clients must prove they hold their secret
+
+	id, secret, ok := req.BasicAuth()
+	if !ok {
+		id, secret = req.PostFormValue("client_id"), req.PostFormValue("client_secret")
+	}
+	invalid := &oauthError{"invalid_client", "Client authentication failed"}
+
+	clientID, err := uuid.Parse(id)
+	if err != nil {
+		return database.OauthClient{}, invalid
+	}
+	client, err := cfg.DBConn.GetOAuthClient(req.Context(), clientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.OauthClient{}, invalid
+	}
+	if err != nil {
+		return database.OauthClient{}, err
+	}
+
+	// Public clients have no secret to check, and shouldn't be sending one
+	if !client.SecretHash.Valid {
+		if secret != "" {
+			return database.OauthClient{}, invalid
+		}
+		return client, nil
+	}
+	given := auth.HashClientSecret(cfg.TokenHashKey, secret)
+	if secret == "" || !hmac.Equal([]byte(given), []byte(client.SecretHash.String)) {
+		return database.OauthClient{}, invalid
+	}
+	return client, nil
+}
+
+func (cfg *ApiConfig) exchangeAuthorizationCode(writer http.ResponseWriter, req *http.Request, client database.OauthClient) {
+	// Redeems an authorization code, starting a new session limited to the scopes the user granted
+
+	code := req.PostFormValue("code")
+	codeHash := auth.HashToken(code)
+	familyID := uuid.New()
+	now := time.Now().UTC()
+
+	// Marks the code used in the same statement that checks it, and records the session it started
+	grant, err := cfg.DBConn.ConsumeAuthorizationCode(req.Context(), database.ConsumeAuthorizationCodeParams{
+		CodeHash: codeHash,
+		UsedAt:   sql.NullTime{Time: now, Valid: true},
+		FamilyID: uuid.NullUUID{UUID: familyID, Valid: true},
+	})
+	if err != nil {
+		cfg.detectAuthorizationCodeReuse(req, codeHash)
+		writeOAuthError(writer, http.StatusBadRequest, &oauthError{"invalid_grant", "Invalid or expired authorization code"})
+		return
+	}
+
+	// The code is spent either way, so a wrong verifier can't be retried
+	if grant.ClientID != client.ID || grant.RedirectUri != req.PostFormValue("redirect_uri") {
+		writeOAuthError(writer, http.StatusBadRequest, &oauthError{"invalid_grant", "Code was issued to another client or redirect_uri"})
+		return
+	}
+	if !auth.VerifyPKCE(req.PostFormValue("code_verifier"), grant.CodeChallenge) {
+		writeOAuthError(writer, http.StatusBadRequest, &oauthError{"invalid_grant", "code_verifier does not match the code_challenge"})
+		return
+	}
+
+	version, err := cfg.DBConn.GetTokenVersion(req.Context(), grant.UserID)
+	if err != nil {
+		writeOAuthError(writer, http.StatusInternalServerError, &oauthError{"server_error", "Failed to create access token"})
+		return
+	}
+	sg := sessionGrant{ClientID: uuid.NullUUID{UUID: client.ID, Valid: true}, Scopes: grant.Scopes}
+	accTkn, refTkn, err := cfg.startSession(req, grant.UserID, version, familyID, sg)
+	if err != nil {
+		writeOAuthError(writer, http.StatusInternalServerError, &oauthError{"server_error", "Failed to create tokens"})
+		return
+	}
+
+	writeOAuthToken(writer, accTkn, refTkn, grant.Scopes)
+}
+
+func (cfg *ApiConfig) refreshOAuthToken(writer http.ResponseWriter, req *http.Request, client database.OauthClient) {
+	// Rotates a refresh token issued to the client, keeping the scopes it was granted
+
+	old, err := cfg.rotateRefreshToken(req, req.PostFormValue("refresh_token"), uuid.NullUUID{UUID: client.ID, Valid: true})
+	if errors.Is(err, errInvalidRefreshToken) {
+		writeOAuthError(writer, http.StatusBadRequest, &oauthError{"invalid_grant", "Invalid refresh token"})
+		return
+	}
+	if err != nil {
+		writeOAuthError(writer, http.StatusInternalServerError, &oauthError{"server_error", "Failed to rotate refresh token"})
+		return
+	}
+
+	version, err := cfg.DBConn.GetTokenVersion(req.Context(), old.UserID)
+	if err != nil {
+		writeOAuthError(writer, http.StatusInternalServerError, &oauthError{"server_error", "Failed to create access token"})
+		return
+	}
+	sg := sessionGrant{ClientID: old.ClientID, Scopes: old.Scopes}
+	accTkn, refTkn, err := cfg.startSession(req, old.UserID, version, old.FamilyID, sg)
+	if err != nil {
+		writeOAuthError(writer, http.StatusInternalServerError, &oauthError{"server_error", "Failed to create tokens"})
+		return
+	}
+
+	writeOAuthToken(writer, accTkn, refTkn, old.Scopes)
+}
+
+func (cfg *ApiConfig) detectAuthorizationCodeReuse(req *http.Request, codeHash string) {
+	// Revokes the session a code started if the code is presented again, since it may have been intercepted
+
+	stored, err := cfg.DBConn.GetAuthorizationCodeAnyState(req.Context(), codeHash)
+	if err != nil || !stored.FamilyID.Valid {
+		return
+	}
+
+	err = cfg.DBConn.RevokeTokenFamily(req.Context(), database.RevokeTokenFamilyParams{
+		FamilyID:  stored.FamilyID.UUID,
+		RevokedAt: sql.NullTime{Time: time.Now().UTC(), Valid: true},
+	})
+	if err != nil {
+		cfg.logSecurityEvent(req.Context(), stored.UserID, "oauth_code_reuse", "Failed to revoke token family "+stored.FamilyID.UUID.String()+": "+err.Error())
+		return
+	}
+
+	cfg.logSecurityEvent(req.Context(), stored.UserID, "oauth_code_reuse", "Revoked token family "+stored.FamilyID.UUID.String()+" from "+req.RemoteAddr)
+}
+
+func writeOAuthToken(writer http.ResponseWriter, accTkn, refTkn string, scopes []string) {
+	// Writes a successful token response in the shape RFC 6749 describes
+
+	outJson, err := json.Marshal(OAuthToken{
+		AccessToken:  accTkn,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenLifetime.Seconds()),
+		RefreshToken: refTkn,
+		Scope:        strings.Join(scopes, " "),
+	})
+	if err != nil {
+		writeOAuthError(writer, http.StatusInternalServerError, &oauthError{"server_error", "Failed to marshal data"})
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(200)
+	writer.Write(outJson)
+}
+
+func writeOAuthError(writer http.ResponseWriter, status int, oe *oauthError) {
+	// Writes an error response in the shape RFC 6749 describes
+
+	outJson, err := json.Marshal(oe)
+	if err != nil {
+		http.Error(writer, oe.Error(), status)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	writer.Write(outJson)
+}
@@ -0,0 +1,129 @@
+package chirpyserver
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"github.com/google/uuid"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+type pageCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+func encodeCursor(createdAt time.Time, id uuid.UUID) string {
+	// Packs a (created_at, id) position into an opaque URL-safe string
+
+	raw := fmt.Sprintf("%d|%s", createdAt.UnixNano(), id.String())
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	// Unpacks a cursor produced by encodeCursor
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return pageCursor{}, fmt.Errorf("Malformed cursor")
+	}
+
+	// Splits the cursor into its timestamp and ID halves
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return pageCursor{}, fmt.Errorf("Malformed cursor")
+	}
+
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return pageCursor{}, fmt.Errorf("Malformed cursor")
+	}
+
+	id, err := uuid.Parse(parts[1])
+	if err != nil {
+		return pageCursor{}, fmt.Errorf("Malformed cursor")
+	}
+
+	return pageCursor{
+		CreatedAt: time.Unix(0, nanos).UTC(),
+		ID:        id,
+	}, nil
+}
+
+func encodeOffsetCursor(offset int32) string {
+	// Packs a result offset into an opaque URL-safe string, for result sets without a stable key
+
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("o|%d", offset)))
+}
+
+func decodeOffsetCursor(s string) (int32, error) {
+	// Unpacks a cursor produced by encodeOffsetCursor
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return 0, fmt.Errorf("Malformed cursor")
+	}
+
+	offset, ok := strings.CutPrefix(string(raw), "o|")
+	if !ok {
+		return 0, fmt.Errorf("Malformed cursor")
+	}
+
+	parsed, err := strconv.ParseInt(offset, 10, 32)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("Malformed cursor")
+	}
+	return int32(parsed), nil
+}
+
+func parsePageLimit(query url.Values) (int32, error) {
+	// Reads the limit query parameter, applying the default and upper bound
+
+	limit := defaultPageLimit
+	if l := query.Get("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed < 1 {
+			return 0, fmt.Errorf("Invalid limit")
+		}
+		limit = min(parsed, maxPageLimit)
+	}
+	return int32(limit), nil
+}
+
+func parsePageParams(query url.Values) (int32, *pageCursor, error) {
+	// Reads the limit and cursor query parameters, applying defaults and bounds
+
+	limit, err := parsePageLimit(query)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// No cursor means start from the first page
+	c := query.Get("cursor")
+	if c == "" {
+		return limit, nil, nil
+	}
+
+	cursor, err := decodeCursor(c)
+	if err != nil {
+		return 0, nil, err
+	}
+	return limit, &cursor, nil
+}
+
+func (c *pageCursor) nullParams() (sql.NullTime, uuid.NullUUID) {
+	// Converts the cursor to the nullable query parameters used by the keyset queries
+
+	if c == nil {
+		return sql.NullTime{}, uuid.NullUUID{}
+	}
+	return sql.NullTime{Time: c.CreatedAt, Valid: true}, uuid.NullUUID{UUID: c.ID, Valid: true}
+}
@@ -0,0 +1,139 @@
+package chirpyserver
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/database"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	createdAt := time.Date(2024, 5, 1, 12, 30, 0, 123456789, time.UTC)
+	id := uuid.New()
+
+	got, err := decodeCursor(encodeCursor(createdAt, id))
+	if err != nil {
+		t.Fatalf("Failed to decode cursor: %v", err)
+	}
+	if !got.CreatedAt.Equal(createdAt) || got.ID != id {
+		t.Errorf("Expected (%v, %s), got (%v, %s)", createdAt, id, got.CreatedAt, got.ID)
+	}
+
+	for _, bad := range []string{"!!!", encodeOffsetCursor(3), "MTIz"} {
+		if _, err := decodeCursor(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestParsePageParams(t *testing.T) {
+	cursor := encodeCursor(time.Now().UTC(), uuid.New())
+
+	test_cases := []struct {
+		query     string
+		limit     int32
+		hasCursor bool
+		wantErr   bool
+	}{
+		{query: "", limit: defaultPageLimit},
+		{query: "limit=5", limit: 5},
+		{query: "limit=1000", limit: maxPageLimit},
+		{query: "limit=0", wantErr: true},
+		{query: "limit=ten", wantErr: true},
+		{query: "cursor=" + cursor, limit: defaultPageLimit, hasCursor: true},
+		{query: "cursor=nope", wantErr: true},
+	}
+
+	for _, c := range test_cases {
+		query, _ := url.ParseQuery(c.query)
+		limit, got, err := parsePageParams(query)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%q: error = %v, wantErr %v", c.query, err, c.wantErr)
+			continue
+		}
+		if err == nil && (limit != c.limit || (got != nil) != c.hasCursor) {
+			t.Errorf("%q: got limit %d cursor %v, expected %d %v", c.query, limit, got != nil, c.limit, c.hasCursor)
+		}
+	}
+}
+
+func TestGETChirpsPages(t *testing.T) {
+	// Five chirps a minute apart, served by a fake keyset query
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	chirps := make([]database.Chirp, 5)
+	for i := range chirps {
+		created := start.Add(time.Duration(i) * time.Minute)
+		chirps[i] = database.Chirp{ID: uuid.New(), CreatedAt: created, UpdatedAt: created, Body: "chirp", UserID: uuid.New()}
+	}
+	var fetched int64
+	_, conn := newFakeDB(map[string]fakeQuery{
+		"GetChirpsPage": func(args []driver.NamedValue) (fakeResult, error) {
+			fetched = args[2].Value.(int64)
+			rows := chirps
+			if args[0].Value != nil {
+				after := args[0].Value.(time.Time)
+				rows = nil
+				for _, c := range chirps {
+					if c.CreatedAt.After(after) {
+						rows = append(rows, c)
+					}
+				}
+			}
+			return chirpResult(rows[:min(len(rows), int(fetched))]...), nil
+		},
+		"GetChirpMentions": returns(fakeResult{columns: []string{"chirp_id", "username", "user_id"}}),
+	})
+	cfg := &ApiConfig{DBConn: conn}
+
+	get := func(query string) ([]Chirp, string, int) {
+		rec := httptest.NewRecorder()
+		cfg.GETChirps(rec, httptest.NewRequest("GET", "/api/chirps?"+query, nil))
+		var page ChirpPage
+		json.Unmarshal(rec.Body.Bytes(), &page)
+		return page.Chirps, page.NextCursor, rec.Code
+	}
+
+	// Pages through with limit=2, following next_cursor until it stops
+	var seen []uuid.UUID
+	query := "limit=2"
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatalf("Too many pages")
+		}
+		out, next, status := get(query)
+		if status != 200 {
+			t.Fatalf("Expected 200, got %d", status)
+		}
+		if fetched != 3 {
+			t.Errorf("Expected one extra row to be fetched, got limit %d", fetched)
+		}
+		for _, c := range out {
+			seen = append(seen, c.ID)
+		}
+		if next == "" {
+			break
+		}
+		query = "limit=2&cursor=" + next
+	}
+	if len(seen) != len(chirps) {
+		t.Fatalf("Expected %d chirps across pages, got %d", len(chirps), len(seen))
+	}
+	for i, c := range chirps {
+		if seen[i] != c.ID {
+			t.Errorf("Chirp %d out of order", i)
+		}
+	}
+
+	// Bad paging parameters are rejected
+	if _, _, status := get("limit=-1"); status != 400 {
+		t.Errorf("Expected 400 for a bad limit, got %d", status)
+	}
+	get("limit=500")
+	if fetched != maxPageLimit+1 {
+		t.Errorf("Expected limit to be capped at %d, fetched %d", maxPageLimit, fetched)
+	}
+}
@@ -0,0 +1,189 @@
+package chirpyserver
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
+	"github.com/roxensox/chirpy/internal/database"
+	"github.com/roxensox/chirpy/internal/mail"
+	"log"
+	"net/http"
+	"time"
+)
+
+const defaultPasswordResetTTL = time.Hour
+
+var errInvalidResetToken = errors.New("Invalid or expired reset token")
+
+func (cfg *ApiConfig) POSTPasswordReset(writer http.ResponseWriter, req *http.Request) {
+	// Handles POST requests at password-reset, emails a one-time reset token to the account's address
+
+	inObj := struct {
+		Email string `json:"email"`
+	}{}
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&inObj); err != nil || inObj.Email == "" {
+		http.Error(writer, "An email address is required", http.StatusBadRequest)
+		return
+	}
+
+	// Everything after the lookup happens in the background and the response is always the same,
+	// so neither it nor its timing reveals whether the account exists
+	ip := clientIP(req)
+	cfg.inBackground(req.Context(), "password reset email", func(ctx context.Context) error {
+		user, err := cfg.DBConn.GetUserByEmail(ctx, inObj.Email)
+		if err != nil {
+			return nil
+		}
+		if err := cfg.sendPasswordResetEmail(ctx, user); err != nil {
+			return err
+		}
+		cfg.logSecurityEvent(ctx, user.ID, "password_reset_requested", ip)
+		return nil
+	})
+
+	writer.WriteHeader(202)
+}
+
+func (cfg *ApiConfig) sendPasswordResetEmail(ctx context.Context, user database.User) error {
+	// Stores a new reset token for the user and emails it to them
+
+	tkn, err := auth.MakePasswordResetToken()
+	if err != nil {
+		return err
+	}
+
+	// Only the hash is stored, the token itself only ever exists in the email
+	ttl := cfg.PasswordResetTTL
+	if ttl <= 0 {
+		ttl = defaultPasswordResetTTL
+	}
+	now := time.Now().UTC()
+	err = cfg.DBConn.CreatePasswordResetToken(ctx, database.CreatePasswordResetTokenParams{
+		TokenHash: auth.HashPasswordResetToken(cfg.TokenHashKey, tkn),
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	})
+	if err != nil {
+		return err
+	}
+
+	return cfg.mailer().Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf(
+			"Someone asked to reset the password for your Chirpy account.\n\n"+
+				"Your reset code is: %s\n\n"+
+				"It expires in %s and can only be used once. If this wasn't you, you can ignore this email.\n",
+			tkn, ttl,
+		),
+	})
+}
+
+func (cfg *ApiConfig) POSTPasswordResetConfirm(writer http.ResponseWriter, req *http.Request) {
+	// Handles POST requests at password-reset/confirm, sets a new password using a reset token
+
+	inObj := struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}{}
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&inObj); err != nil || inObj.Token == "" || inObj.Password == "" {
+		http.Error(writer, "A token and new password are required", http.StatusBadRequest)
+		return
+	}
+
+	// Redeems the token, sets the password, signs the user out of every session, revokes their personal
+	// access tokens and voids any other reset tokens together, so a failure part way leaves the token
+	// unspent for a retry rather than a new password with the old sessions still live
+	now := time.Now().UTC()
+	var UID uuid.UUID
+	var version int32
+	err := cfg.inTx(req.Context(), func(q *database.Queries) error {
+		// Marks the token used in the same statement that checks it, so it can't be redeemed twice
+		var err error
+		UID, err = q.ConsumePasswordResetToken(req.Context(), database.ConsumePasswordResetTokenParams{
+			TokenHash: auth.HashPasswordResetToken(cfg.TokenHashKey, inObj.Token),
+			UsedAt:    sql.NullTime{Time: now, Valid: true},
+		})
+		if err != nil {
+			return errInvalidResetToken
+		}
+
+		// Hashes only once the token is known good, so junk tokens can't make the server run argon2
+		hashed, err := auth.HashPassword(inObj.Password)
+		if err != nil {
+			return err
+		}
+
+		// Setting the password also bumps the token version, ending every access token
+		version, err = q.SetUserPassword(req.Context(), database.SetUserPasswordParams{
+			HashedPassword: hashed,
+			UpdatedAt:      now,
+			ID:             UID,
+		})
+		if err != nil {
+			return err
+		}
+
+		err = q.RevokeAllUserTokens(req.Context(), database.RevokeAllUserTokensParams{
+			UserID:    UID,
+			RevokedAt: sql.NullTime{Time: now, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+		err = q.RevokeAllUserPersonalAccessTokens(req.Context(), database.RevokeAllUserPersonalAccessTokensParams{
+			UserID:    UID,
+			RevokedAt: sql.NullTime{Time: now, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+		return q.ExpireUserPasswordResetTokens(req.Context(), database.ExpireUserPasswordResetTokensParams{
+			UserID: UID,
+			UsedAt: sql.NullTime{Time: now, Valid: true},
+		})
+	})
+	if errors.Is(err, errInvalidResetToken) {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(writer, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+	if cfg.Revocations != nil {
+		cfg.Revocations.SetVersion(UID, version)
+	}
+
+	cfg.logSecurityEvent(req.Context(), UID, "password_reset", clientIP(req))
+	writer.WriteHeader(204)
+}
+
+func (cfg *ApiConfig) mailer() mail.Sender {
+	// Returns the configured mail sender, falling back to the server log
+
+	if cfg.Mailer == nil {
+		return mail.LogSender{}
+	}
+	return cfg.Mailer
+}
+
+func (cfg *ApiConfig) inBackground(ctx context.Context, name string, fn func(ctx context.Context) error) {
+	// Runs fn after the response has gone out, logging any failure; ctx's values carry over but not its cancellation
+
+	ctx = context.WithoutCancel(ctx)
+	cfg.background.Add(1)
+	go func() {
+		defer cfg.background.Done()
+		if err := fn(ctx); err != nil {
+			log.Printf("Failed to send %s: %v", name, err)
+		}
+	}()
+}
@@ -0,0 +1,128 @@
+package chirpyserver
+
+import (
+	"database/sql/driver"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
+	"github.com/roxensox/chirpy/internal/database"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPasswordReset(t *testing.T) {
+	type resetToken struct {
+		userID    string
+		expiresAt time.Time
+		used      bool
+	}
+	user := database.User{ID: uuid.New(), Email: "reset@example.com", Role: auth.RoleUser}
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	// Stores reset tokens by hash and redeems them the way ConsumePasswordResetToken does
+	var mu sync.Mutex
+	tokens := map[string]*resetToken{}
+	var passwordChanges, patRevocations int
+	var failRevocation bool
+	exec := returns(fakeResult{affected: 1})
+	fake, conn := newFakeDB(map[string]fakeQuery{
+		"GetUserByEmail": returns(userResult(user)),
+		"CreatePasswordResetToken": func(args []driver.NamedValue) (fakeResult, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			tokens[args[0].Value.(string)] = &resetToken{userID: args[1].Value.(string), expiresAt: args[3].Value.(time.Time)}
+			return fakeResult{affected: 1}, nil
+		},
+		"ConsumePasswordResetToken": func(args []driver.NamedValue) (fakeResult, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			res := fakeResult{columns: []string{"user_id"}}
+			tkn, ok := tokens[args[0].Value.(string)]
+			if ok && !tkn.used && tkn.expiresAt.After(args[1].Value.(time.Time)) {
+				tkn.used = true
+				res.rows = [][]driver.Value{{tkn.userID}}
+			}
+			return res, nil
+		},
+		"SetUserPassword": func([]driver.NamedValue) (fakeResult, error) {
+			passwordChanges++
+			return fakeResult{columns: []string{"token_version"}, rows: [][]driver.Value{{int64(passwordChanges)}}}, nil
+		},
+		"RevokeAllUserTokens": exec,
+		"RevokeAllUserPersonalAccessTokens": func(args []driver.NamedValue) (fakeResult, error) {
+			if failRevocation {
+				return fakeResult{}, errors.New("connection reset")
+			}
+			if args[0].Value.(string) == user.ID.String() {
+				patRevocations++
+			}
+			return fakeResult{affected: 1}, nil
+		},
+		"ExpireUserPasswordResetTokens": exec,
+		"AddSecurityEvent":              exec,
+	})
+	sender := &recordingSender{}
+	cfg := &ApiConfig{DB: fake.sqlDB, DBConn: conn, Mailer: sender, TokenHashKey: key}
+
+	confirm := func(token string) int {
+		rec := httptest.NewRecorder()
+		cfg.POSTPasswordResetConfirm(rec, httptest.NewRequest("POST", "/api/password-reset/confirm",
+			strings.NewReader(`{"token": "`+token+`", "password": "new password"}`)))
+		return rec.Code
+	}
+
+	// Requesting a reset emails a code and stores only its keyed hash
+	rec := httptest.NewRecorder()
+	cfg.POSTPasswordReset(rec, httptest.NewRequest("POST", "/api/password-reset", strings.NewReader(`{"email": "reset@example.com"}`)))
+	cfg.background.Wait()
+	if rec.Code != 202 || len(sender.sent) != 1 {
+		t.Fatalf("Expected 202 and one email, got %d and %d emails", rec.Code, len(sender.sent))
+	}
+	code := regexp.MustCompile(`reset code is: (\S+)`).FindStringSubmatch(sender.sent[0].Body)
+	if code == nil {
+		t.Fatalf("Expected a reset code in the email, got %q", sender.sent[0].Body)
+	}
+	if _, ok := tokens[auth.HashPasswordResetToken(key, code[1])]; !ok || len(tokens) != 1 {
+		t.Errorf("Expected the code to be stored under its keyed hash")
+	}
+
+	// The code sets a new password once and can't be reused
+	if status := confirm(code[1]); status != 204 {
+		t.Errorf("Expected 204 redeeming the code, got %d", status)
+	}
+	if patRevocations != 1 {
+		t.Errorf("Expected the user's personal access tokens to be revoked")
+	}
+	if commits, _ := fake.txCounts(); commits != 1 {
+		t.Errorf("Expected the reset to commit in one transaction, got %d commits", commits)
+	}
+	if status := confirm(code[1]); status != 400 {
+		t.Errorf("Expected 400 reusing the code, got %d", status)
+	}
+
+	// An expired code and one that was never issued are both refused
+	tokens[auth.HashPasswordResetToken(key, "expired")] = &resetToken{userID: user.ID.String(), expiresAt: time.Now().UTC().Add(-time.Minute)}
+	if status := confirm("expired"); status != 400 {
+		t.Errorf("Expected 400 for an expired code, got %d", status)
+	}
+	if status := confirm("made up"); status != 400 {
+		t.Errorf("Expected 400 for an unknown code, got %d", status)
+	}
+	if passwordChanges != 1 {
+		t.Errorf("Expected the password to change once, got %d", passwordChanges)
+	}
+
+	// A failure part way through rolls the whole reset back, password change included
+	tokens[auth.HashPasswordResetToken(key, "interrupted")] = &resetToken{userID: user.ID.String(), expiresAt: time.Now().UTC().Add(time.Minute)}
+	failRevocation = true
+	if status := confirm("interrupted"); status != 500 {
+		t.Errorf("Expected 500 when revoking access tokens fails, got %d", status)
+	}
+	if commits, rollbacks := fake.txCounts(); commits != 1 || rollbacks != 4 {
+		t.Errorf("Expected the interrupted reset to roll back, got %d commits and %d rollbacks", commits, rollbacks)
+	}
+}
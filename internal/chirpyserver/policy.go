@@ -0,0 +1,134 @@
+package chirpyserver
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
+	"github.com/roxensox/chirpy/internal/database"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// errLastAdmin is returned when a role change would leave no admins
+var errLastAdmin = errors.New("Can't demote the last admin")
+
+func (cfg *ApiConfig) can(ctx context.Context, userID uuid.UUID, permission string) (bool, error) {
+	// Reports whether the user's role grants the permission. The role is read fresh each time so a
+	// demotion takes effect on the next request rather than when their token expires
+
+	role, err := cfg.DBConn.GetUserRole(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return auth.RoleCan(role, permission), nil
+}
+
+func (cfg *ApiConfig) canModerate(ctx context.Context, userID, authorID uuid.UUID) (bool, error) {
+	// Reports whether the user may delete or restore a chirp: authors can always act on their own, moderators on anyone's
+
+	if userID == authorID {
+		return true, nil
+	}
+	return cfg.can(ctx, userID, auth.PermModerateChirps)
+}
+
+func (cfg *ApiConfig) RequirePermission(permission string, next http.HandlerFunc) http.HandlerFunc {
+	// Wraps next so it only runs for callers whose role grants permission. Privileged actions need the
+	// account scope, so only an interactive login can take them, never a personal access token or OAuth client
+
+	return cfg.RequireAuth(auth.ScopeAccount, func(writer http.ResponseWriter, req *http.Request) {
+		UID, ok := currentUser(writer, req)
+		if !ok {
+			return
+		}
+
+		allowed, err := cfg.can(req.Context(), UID, permission)
+		if err != nil {
+			log.Printf("Failed to check %s for %s: %v", permission, UID, err)
+			http.Error(writer, "Unable to check permissions", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			http.Error(writer, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next(writer, req)
+	})
+}
+
+func (cfg *ApiConfig) PUTUserRole(writer http.ResponseWriter, req *http.Request) {
+	// Handles PUT requests at admin/users/{userID}/role, changes which role a user has
+
+	UID, ok := currentUser(writer, req)
+	if !ok {
+		return
+	}
+
+	target, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		http.Error(writer, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	// Admins can't change their own role, so there's always someone left who can change it back
+	if target == UID {
+		http.Error(writer, "You can't change your own role", http.StatusConflict)
+		return
+	}
+
+	inObj := struct {
+		Role string `json:"role"`
+	}{}
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&inObj); err != nil {
+		http.Error(writer, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := auth.ValidRole(inObj.Role); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Locks the admins while the role changes, so two admins demoting each other at once can't leave none
+	var updated int64
+	err = cfg.inTx(req.Context(), func(q *database.Queries) error {
+		admins, err := q.LockAdmins(req.Context())
+		if err != nil {
+			return err
+		}
+		if inObj.Role != auth.RoleAdmin && slices.Contains(admins, target) && len(admins) == 1 {
+			return errLastAdmin
+		}
+
+		updated, err = q.SetUserRole(req.Context(), database.SetUserRoleParams{
+			Role:      inObj.Role,
+			UpdatedAt: time.Now().UTC(),
+			ID:        target,
+		})
+		return err
+	})
+	if errors.Is(err, errLastAdmin) {
+		http.Error(writer, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(writer, "Failed to set role", http.StatusInternalServerError)
+		return
+	}
+	if updated == 0 {
+		http.Error(writer, "User not found", http.StatusNotFound)
+		return
+	}
+	cfg.logSecurityEvent(req.Context(), target, "role_changed", inObj.Role+" by "+UID.String())
+
+	writer.WriteHeader(204)
+}
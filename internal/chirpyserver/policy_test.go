@@ -0,0 +1,157 @@
+package chirpyserver
+
+import (
+	"context"
+	"database/sql/driver"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequirePermission(t *testing.T) {
+	keys := auth.NewKeySet()
+	keys.Add(auth.NewHMACKey("default", "secret"))
+	keys.SetSigningKey("default")
+
+	roles := map[string]string{}
+	users := map[string]uuid.UUID{}
+	for _, role := range []string{auth.RoleUser, auth.RoleModerator, auth.RoleAdmin} {
+		users[role] = uuid.New()
+		roles[users[role].String()] = role
+	}
+	_, conn := newFakeDB(map[string]fakeQuery{
+		"GetUserRole": func(args []driver.NamedValue) (fakeResult, error) {
+			res := fakeResult{columns: []string{"role"}}
+			if role, ok := roles[args[0].Value.(string)]; ok {
+				res.rows = [][]driver.Value{{role}}
+			}
+			return res, nil
+		},
+	})
+	cfg := &ApiConfig{DBConn: conn, Keys: keys}
+
+	login := func(userID uuid.UUID) string {
+		tkn, _ := auth.MakeJWT(userID, 0, keys, time.Hour)
+		return tkn
+	}
+	// An OAuth client acting for an admin still can't use the admin API
+	delegated, _ := auth.MakeScopedJWT(users[auth.RoleAdmin], 0, keys, time.Hour, uuid.NewString(), auth.GrantableScopes)
+
+	test_cases := []struct {
+		name       string
+		permission string
+		token      string
+		status     int
+	}{
+		{name: "no token", permission: auth.PermViewMetrics, status: 401},
+		{name: "plain user", permission: auth.PermViewMetrics, token: login(users[auth.RoleUser]), status: 403},
+		{name: "moderator on admin action", permission: auth.PermViewMetrics, token: login(users[auth.RoleModerator]), status: 403},
+		{name: "moderator moderating", permission: auth.PermModerateChirps, token: login(users[auth.RoleModerator]), status: 204},
+		{name: "moderator on admin restore", permission: auth.PermRestoreChirps, token: login(users[auth.RoleModerator]), status: 403},
+		{name: "admin", permission: auth.PermViewMetrics, token: login(users[auth.RoleAdmin]), status: 204},
+		{name: "admin through a client", permission: auth.PermViewMetrics, token: delegated, status: 403},
+		{name: "deleted user", permission: auth.PermModerateChirps, token: login(uuid.New()), status: 403},
+	}
+
+	for _, c := range test_cases {
+		t.Run(c.name, func(t *testing.T) {
+			handler := cfg.RequirePermission(c.permission, func(writer http.ResponseWriter, req *http.Request) {
+				writer.WriteHeader(204)
+			})
+			req := httptest.NewRequest("GET", "/admin/metrics", nil)
+			if c.token != "" {
+				req.Header.Set("Authorization", "Bearer "+c.token)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != c.status {
+				t.Errorf("Expected status %d, got %d", c.status, rec.Code)
+			}
+		})
+	}
+}
+
+func TestResetRequiresDevMode(t *testing.T) {
+	_, conn := newFakeDB(map[string]fakeQuery{
+		"ResetUsers": returns(fakeResult{}),
+	})
+
+	for devMode, expected := range map[bool]int{false: 403, true: 200} {
+		cfg := &ApiConfig{DBConn: conn, DevMode: devMode}
+		cfg.FileserverHits.Store(5)
+		rec := httptest.NewRecorder()
+		cfg.Reset(rec, httptest.NewRequest("POST", "/admin/reset", nil))
+
+		if rec.Code != expected {
+			t.Errorf("DevMode %v: expected status %d, got %d", devMode, expected, rec.Code)
+		}
+		if wiped := cfg.FileserverHits.Load() == 0; wiped != devMode {
+			t.Errorf("DevMode %v: expected reset to happen to be %v", devMode, devMode)
+		}
+	}
+}
+
+func TestPUTUserRole(t *testing.T) {
+	caller, other := uuid.New(), uuid.New()
+
+	// Sets target's role with admins holding the admin role, returning the status and roles afterwards
+	run := func(admins []uuid.UUID, target uuid.UUID, role string) (int, map[string]string) {
+		roles := map[string]string{caller.String(): auth.RoleUser, other.String(): auth.RoleUser}
+		for _, id := range admins {
+			roles[id.String()] = auth.RoleAdmin
+		}
+		fake, conn := newFakeDB(map[string]fakeQuery{
+			"LockAdmins": func([]driver.NamedValue) (fakeResult, error) {
+				res := fakeResult{columns: []string{"id"}}
+				for id, r := range roles {
+					if r == auth.RoleAdmin {
+						res.rows = append(res.rows, []driver.Value{id})
+					}
+				}
+				return res, nil
+			},
+			"SetUserRole": func(args []driver.NamedValue) (fakeResult, error) {
+				id := args[2].Value.(string)
+				if _, ok := roles[id]; !ok {
+					return fakeResult{}, nil
+				}
+				roles[id] = args[0].Value.(string)
+				return fakeResult{affected: 1}, nil
+			},
+			"AddSecurityEvent": returns(fakeResult{affected: 1}),
+		})
+		cfg := &ApiConfig{DBConn: conn, DB: fake.sqlDB}
+
+		req := httptest.NewRequest("PUT", "/admin/users/"+target.String()+"/role", strings.NewReader(`{"role": "`+role+`"}`))
+		req.SetPathValue("userID", target.String())
+		req = req.WithContext(WithPrincipal(context.Background(), Principal{UserID: caller, Scopes: auth.AllScopes}))
+		rec := httptest.NewRecorder()
+		cfg.PUTUserRole(rec, req)
+		return rec.Code, roles
+	}
+
+	if code, roles := run([]uuid.UUID{caller}, other, auth.RoleModerator); code != 204 || roles[other.String()] != auth.RoleModerator {
+		t.Errorf("Expected a user to be made a moderator, got %d", code)
+	}
+	if code, roles := run([]uuid.UUID{caller, other}, other, auth.RoleUser); code != 204 || roles[other.String()] != auth.RoleUser {
+		t.Errorf("Expected an admin to demote another admin, got %d", code)
+	}
+	if code, _ := run([]uuid.UUID{caller}, caller, auth.RoleUser); code != 409 {
+		t.Errorf("Expected an admin changing their own role to be refused, got %d", code)
+	}
+	if code, _ := run([]uuid.UUID{caller}, other, "owner"); code != 400 {
+		t.Errorf("Expected an unknown role to be refused, got %d", code)
+	}
+
+	// The caller has just lost the admin role to a concurrent change, so the target is the only admin left
+	if code, roles := run([]uuid.UUID{other}, other, auth.RoleUser); code != 409 || roles[other.String()] != auth.RoleAdmin {
+		t.Errorf("Expected demoting the last admin to be refused, got %d", code)
+	}
+	if code, _ := run(nil, uuid.New(), auth.RoleUser); code != 404 {
+		t.Errorf("Expected 404 for an unknown user, got %d", code)
+	}
+}
@@ -0,0 +1,73 @@
+package chirpyserver
+
+import (
+	"context"
+	"database/sql"
+	"github.com/roxensox/chirpy/internal/database"
+	"log"
+	"time"
+)
+
+const defaultChirpRetention = 30 * 24 * time.Hour
+
+func (cfg *ApiConfig) PurgeDeletedChirps(ctx context.Context) error {
+	// Permanently removes chirps that were deleted longer ago than the retention period
+	// Purged chirps that still have replies are kept as empty tombstones so their threads survive
+
+	retention := cfg.ChirpRetention
+	if retention <= 0 {
+		retention = defaultChirpRetention
+	}
+	cutoff := sql.NullTime{Time: time.Now().UTC().Add(-retention), Valid: true}
+
+	// Clears everything derived from the old bodies first
+	if err := cfg.DBConn.PurgeExpiredChirpRevisions(ctx, cutoff); err != nil {
+		return err
+	}
+	if err := cfg.DBConn.PurgeExpiredChirpTags(ctx, cutoff); err != nil {
+		return err
+	}
+	if err := cfg.DBConn.PurgeExpiredChirpMentions(ctx, cutoff); err != nil {
+		return err
+	}
+
+	// Empties every expired chirp, then drops the ones nothing replies to. The DELETE checks for replies
+	// itself rather than acting on an earlier count, and new replies are only accepted for chirps that
+	// aren't deleted, so a reply can't slip in between the check and the delete and lose its parent
+	var tombstoned, purged int64
+	err := cfg.inTx(ctx, func(q *database.Queries) error {
+		var err error
+		if tombstoned, err = q.TombstoneExpiredChirps(ctx, cutoff); err != nil {
+			return err
+		}
+		purged, err = q.PurgeExpiredChirps(ctx, cutoff)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if tombstoned > 0 || purged > 0 {
+		log.Printf("Emptied %d expired chirps, removed %d with no replies", tombstoned, purged)
+	}
+	return nil
+}
+
+func (cfg *ApiConfig) RunChirpPurger(ctx context.Context, interval time.Duration) {
+	// Runs PurgeDeletedChirps every interval until ctx is cancelled
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := cfg.PurgeDeletedChirps(ctx); err != nil {
+			log.Printf("Failed to purge deleted chirps: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
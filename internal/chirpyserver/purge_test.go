@@ -0,0 +1,86 @@
+package chirpyserver
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPurgeDeletedChirps(t *testing.T) {
+	type row struct {
+		deletedAt  time.Time
+		tombstoned bool
+		replies    int
+	}
+	now := time.Now().UTC()
+	longAgo := now.Add(-40 * 24 * time.Hour)
+
+	run := func(purgeErr error) (map[string]*row, *fakeDB, error) {
+		chirps := map[string]*row{
+			"expired with replies": {deletedAt: longAgo, replies: 1},
+			"expired alone":        {deletedAt: longAgo},
+			"recently deleted":     {deletedAt: now},
+			"live":                 {},
+		}
+		expired := func(r *row, cutoff time.Time) bool { return !r.deletedAt.IsZero() && r.deletedAt.Before(cutoff) }
+
+		fake, conn := newFakeDB(map[string]fakeQuery{
+			"PurgeExpiredChirpRevisions": returns(fakeResult{}),
+			"PurgeExpiredChirpTags":      returns(fakeResult{}),
+			"PurgeExpiredChirpMentions":  returns(fakeResult{}),
+			"TombstoneExpiredChirps": func(args []driver.NamedValue) (fakeResult, error) {
+				res := fakeResult{}
+				for _, r := range chirps {
+					if expired(r, args[0].Value.(time.Time)) && !r.tombstoned {
+						r.tombstoned = true
+						res.affected++
+					}
+				}
+				return res, nil
+			},
+			"PurgeExpiredChirps": func(args []driver.NamedValue) (fakeResult, error) {
+				if purgeErr != nil {
+					return fakeResult{}, purgeErr
+				}
+				res := fakeResult{}
+				for name, r := range chirps {
+					if expired(r, args[0].Value.(time.Time)) && r.replies == 0 {
+						delete(chirps, name)
+						res.affected++
+					}
+				}
+				return res, nil
+			},
+		})
+		cfg := &ApiConfig{DBConn: conn, DB: fake.sqlDB}
+		return chirps, fake, cfg.PurgeDeletedChirps(context.Background())
+	}
+
+	chirps, fake, err := run(nil)
+	if err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	if r, ok := chirps["expired with replies"]; !ok || !r.tombstoned {
+		t.Errorf("Expected a chirp with replies to be kept as a tombstone")
+	}
+	if _, ok := chirps["expired alone"]; ok {
+		t.Errorf("Expected a chirp with no replies to be removed")
+	}
+	if chirps["recently deleted"].tombstoned || chirps["live"].tombstoned {
+		t.Errorf("Expected chirps inside the retention period to be left alone")
+	}
+	if commits, _ := fake.txCounts(); commits != 1 {
+		t.Errorf("Expected both steps to commit together, got %d commits", commits)
+	}
+
+	// A failed delete undoes the tombstoning alongside it
+	_, fake, err = run(errors.New("boom"))
+	if err == nil {
+		t.Fatalf("Expected the purge to fail")
+	}
+	if commits, rollbacks := fake.txCounts(); commits != 0 || rollbacks != 1 {
+		t.Errorf("Expected a rollback, got %d commits and %d rollbacks", commits, rollbacks)
+	}
+}
@@ -1,50 +1,72 @@
 package chirpyserver
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
 	"github.com/roxensox/chirpy/internal/auth"
 	"github.com/roxensox/chirpy/internal/database"
 	"net/http"
 	"time"
 )
 
+const refreshTokenLifetime = 60 * 24 * time.Hour
+
+var errInvalidRefreshToken = errors.New("Invalid token")
+
+// sessionGrant records who a session was issued to and what it may do; the zero value is a
+// first-party session with every scope
+type sessionGrant struct {
+	ClientID uuid.NullUUID
+	Scopes   []string
+}
+
 func (cfg *ApiConfig) POSTRefresh(writer http.ResponseWriter, req *http.Request) {
-	// Handles POST request to refresh endpoint, authenticates and returns a new access token
+	// Handles POST request to refresh endpoint, rotates the refresh token and returns a new token pair
 
 	// Gets the user's refresh token
 	tkn, err := auth.GetBearerToken(req.Header)
 	if err != nil {
 		writer.WriteHeader(401)
 		writer.Write([]byte("Token not found in header"))
+		return
 	}
 
-	// Builds parameters for refresh token DB query
-	params := database.GetTokenParams{
-		Token:     tkn,
-		ExpiresAt: time.Now().UTC(),
-	}
-
-	// Runs the query
-	resp, err := cfg.DBConn.GetToken(req.Context(), params)
-	if err != nil {
+	// Retires the presented token; tokens issued to OAuth clients are refreshed at the token endpoint instead
+	old, err := cfg.rotateRefreshToken(req, tkn, uuid.NullUUID{})
+	if errors.Is(err, errInvalidRefreshToken) {
 		writer.WriteHeader(401)
 		writer.Write([]byte("Invalid token"))
 		return
 	}
+	if err != nil {
+		writer.WriteHeader(500)
+		writer.Write([]byte("Failed to rotate refresh token"))
+		return
+	}
 
-	// Creates a new access token if the user validated successfully
-	accTkn, err := auth.MakeJWT(resp.UserID, cfg.Secret, 1*time.Hour)
+	// Issues the replacement pair in the same family, stamped with the user's current token version
+	version, err := cfg.DBConn.GetTokenVersion(req.Context(), old.UserID)
 	if err != nil {
 		writer.WriteHeader(500)
 		writer.Write([]byte("Failed to create new access token"))
 		return
 	}
+	accTkn, newRefTkn, err := cfg.startSession(req, old.UserID, version, old.FamilyID, sessionGrant{})
+	if err != nil {
+		writer.WriteHeader(500)
+		writer.Write([]byte("Failed to create new tokens"))
+		return
+	}
 
 	// Builds an output object
 	respObj := struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}{
-		Token: accTkn,
+		Token:        accTkn,
+		RefreshToken: newRefTkn,
 	}
 
 	// Marshals output object to json
@@ -59,3 +81,104 @@ func (cfg *ApiConfig) POSTRefresh(writer http.ResponseWriter, req *http.Request)
 	writer.WriteHeader(200)
 	writer.Write(respJson)
 }
+
+func (cfg *ApiConfig) startSession(req *http.Request, userID uuid.UUID, version int32, familyID uuid.UUID, grant sessionGrant) (string, string, error) {
+	// Issues an access token and a refresh token in familyID, both limited by grant, and returns them in that order
+
+	clientID := ""
+	if grant.ClientID.Valid {
+		clientID = grant.ClientID.UUID.String()
+	}
+	accTkn, err := auth.MakeScopedJWT(userID, version, cfg.Keys, accessTokenLifetime, clientID, grant.Scopes)
+	if err != nil {
+		return "", "", err
+	}
+
+	refTkn, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now().UTC()
+	err = cfg.DBConn.AddRefreshToken(req.Context(), database.AddRefreshTokenParams{
+		TokenHash:  cfg.hashRefreshToken(refTkn),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		ExpiresAt:  now.Add(refreshTokenLifetime),
+		UserID:     userID,
+		FamilyID:   familyID,
+		UserAgent:  req.UserAgent(),
+		IpAddress:  clientIP(req),
+		LastUsedAt: now,
+		ClientID:   grant.ClientID,
+		Scopes:     grant.Scopes,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return accTkn, refTkn, nil
+}
+
+func (cfg *ApiConfig) rotateRefreshToken(req *http.Request, tkn string, clientID uuid.NullUUID) (database.RefreshToken, error) {
+	// Retires a live refresh token issued to clientID and returns its record, errInvalidRefreshToken if it can't be used
+
+	// Turns away anything that isn't shaped like a refresh token before touching the database
+	if !auth.ValidRefreshTokenFormat(tkn) {
+		return database.RefreshToken{}, errInvalidRefreshToken
+	}
+
+	now := time.Now().UTC()
+	tknHash := cfg.hashRefreshToken(tkn)
+	resp, err := cfg.DBConn.GetToken(req.Context(), database.GetTokenParams{
+		TokenHash: tknHash,
+		ExpiresAt: now,
+	})
+	if err != nil {
+		cfg.detectRefreshTokenReuse(req, tknHash)
+		return database.RefreshToken{}, errInvalidRefreshToken
+	}
+	if resp.ClientID != clientID {
+		return database.RefreshToken{}, errInvalidRefreshToken
+	}
+
+	// If another request rotated the token first, this is a reuse
+	rotated, err := cfg.DBConn.RotateRefreshToken(req.Context(), database.RotateRefreshTokenParams{
+		TokenHash:  tknHash,
+		ReplacedAt: sql.NullTime{Time: now, Valid: true},
+	})
+	if err != nil {
+		return database.RefreshToken{}, err
+	}
+	if rotated == 0 {
+		cfg.detectRefreshTokenReuse(req, tknHash)
+		return database.RefreshToken{}, errInvalidRefreshToken
+	}
+	return resp, nil
+}
+
+func (cfg *ApiConfig) detectRefreshTokenReuse(req *http.Request, tknHash string) {
+	// Revokes a token's whole family if the token had already been rotated,
+	// since only a copy held by someone else would be presented again
+
+	stored, err := cfg.DBConn.GetRefreshTokenAnyState(req.Context(), tknHash)
+	if err != nil || !stored.ReplacedAt.Valid {
+		return
+	}
+
+	now := time.Now().UTC()
+	err = cfg.DBConn.RevokeTokenFamily(req.Context(), database.RevokeTokenFamilyParams{
+		FamilyID:  stored.FamilyID,
+		RevokedAt: sql.NullTime{Time: now, Valid: true},
+	})
+	if err != nil {
+		cfg.logSecurityEvent(req.Context(), stored.UserID, "refresh_token_reuse", "Failed to revoke token family "+stored.FamilyID.String()+": "+err.Error())
+		return
+	}
+
+	cfg.logSecurityEvent(req.Context(), stored.UserID, "refresh_token_reuse", "Revoked token family "+stored.FamilyID.String()+" from "+req.RemoteAddr)
+}
+
+func (cfg *ApiConfig) hashRefreshToken(tkn string) string {
+	// Returns the keyed hash a refresh token is stored and looked up by
+
+	return auth.HashRefreshToken(cfg.TokenHashKey, tkn)
+}
@@ -0,0 +1,110 @@
+package chirpyserver
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPOSTRefresh(t *testing.T) {
+	store := newOAuthStore()
+	queries := store.queries()
+
+	// Records the security events logged, and lets a test rotate a token out from under the handler
+	var events []string
+	queries["AddSecurityEvent"] = func(args []driver.NamedValue) (fakeResult, error) {
+		events = append(events, args[2].Value.(string))
+		return fakeResult{affected: 1}, nil
+	}
+	rotate := queries["RotateRefreshToken"]
+	var rotatedMeanwhile bool
+	queries["RotateRefreshToken"] = func(args []driver.NamedValue) (fakeResult, error) {
+		if rotatedMeanwhile {
+			rotatedMeanwhile = false
+			rotate(args)
+		}
+		return rotate(args)
+	}
+	_, conn := newFakeDB(queries)
+	keys := auth.NewKeySet()
+	keys.Add(auth.NewHMACKey("default", "secret"))
+	keys.SetSigningKey("default")
+	cfg := &ApiConfig{DBConn: conn, Keys: keys, TokenHashKey: []byte("token hash key")}
+
+	userID := uuid.New()
+	login := func() string {
+		_, refTkn, err := cfg.startSession(httptest.NewRequest("POST", "/api/login", nil), userID, 0, uuid.New(), sessionGrant{})
+		if err != nil {
+			t.Fatalf("Failed to start session: %v", err)
+		}
+		return refTkn
+	}
+	refresh := func(tkn string) (int, string) {
+		req := httptest.NewRequest("POST", "/api/refresh", nil)
+		req.Header.Set("Authorization", "Bearer "+tkn)
+		rec := httptest.NewRecorder()
+		cfg.POSTRefresh(rec, req)
+		out := struct {
+			RefreshToken string `json:"refresh_token"`
+		}{}
+		json.NewDecoder(rec.Body).Decode(&out)
+		return rec.Code, out.RefreshToken
+	}
+	revoked := func(tkn string) bool {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return store.refresh[cfg.hashRefreshToken(tkn)][4] != nil
+	}
+
+	// Refreshing swaps the token for a new one in the same family
+	first := login()
+	status, second := refresh(first)
+	if status != 200 || second == "" || second == first {
+		t.Fatalf("Expected 200 and a new refresh token, got %d and %q", status, second)
+	}
+	if store.refresh[cfg.hashRefreshToken(first)][6] != store.refresh[cfg.hashRefreshToken(second)][6] {
+		t.Errorf("Expected the new token to stay in the old token's family")
+	}
+
+	// Presenting the rotated token again revokes the whole family and logs it
+	if status, _ := refresh(first); status != 401 {
+		t.Errorf("Expected 401 replaying a rotated token, got %d", status)
+	}
+	if !revoked(second) {
+		t.Errorf("Expected a replay to revoke the newest token in the family")
+	}
+	if status, _ := refresh(second); status != 401 {
+		t.Errorf("Expected 401 for a token revoked by a replay, got %d", status)
+	}
+	if len(events) != 1 || events[0] != "refresh_token_reuse" {
+		t.Errorf("Expected one refresh_token_reuse event, got %v", events)
+	}
+
+	// Losing the rotation to a concurrent request with the same token counts as a replay too
+	other := login()
+	rotatedMeanwhile = true
+	if status, _ := refresh(other); status != 401 {
+		t.Errorf("Expected 401 when another request rotated the token first, got %d", status)
+	}
+	if !revoked(other) {
+		t.Errorf("Expected losing the rotation to revoke the family")
+	}
+	if len(events) != 2 {
+		t.Errorf("Expected the lost rotation to be logged, got %v", events)
+	}
+
+	// Malformed and unknown tokens are refused without touching any family
+	if status, _ := refresh("not a token"); status != 401 {
+		t.Errorf("Expected 401 for a malformed token, got %d", status)
+	}
+	unknown, _ := auth.MakeRefreshToken()
+	if status, _ := refresh(unknown); status != 401 {
+		t.Errorf("Expected 401 for an unknown token, got %d", status)
+	}
+	if len(events) != 2 {
+		t.Errorf("Expected no events for tokens that were never issued, got %v", events)
+	}
+}
@@ -0,0 +1,114 @@
+package chirpyserver
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/database"
+	"net/http"
+)
+
+func (cfg *ApiConfig) GETChirpReplies(writer http.ResponseWriter, req *http.Request) {
+	// Handles a GET request to chirps/{chirpID}/replies, returns one page of direct replies
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	// Parses the parent chirp ID from the path
+	chirpUUID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		http.Error(writer, "Invalid chirp ID", http.StatusBadRequest)
+		return
+	}
+
+	// Ensures the parent exists so a typo doesn't look like an empty thread
+	// A deleted parent still lists its replies
+	if _, err := cfg.DBConn.GetExactChirpWithDeleted(req.Context(), chirpUUID); err != nil {
+		http.Error(writer, "Chirp not found", http.StatusNotFound)
+		return
+	}
+
+	// Reads the page size and starting cursor from the query
+	limit, cursor, err := parsePageParams(req.URL.Query())
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cursorTime, cursorID := cursor.nullParams()
+
+	// Fetches one extra row to find out whether another page follows
+	replies, err := cfg.DBConn.GetChirpReplies(req.Context(), database.GetChirpRepliesParams{
+		InReplyTo:       uuid.NullUUID{UUID: chirpUUID, Valid: true},
+		CursorCreatedAt: cursorTime,
+		CursorID:        cursorID,
+		PageLimit:       limit + 1,
+	})
+	if err != nil {
+		http.Error(writer, "Unable to get replies", http.StatusInternalServerError)
+		return
+	}
+
+	cfg.writeChirpPage(writer, req, replies, int(limit))
+}
+
+func (cfg *ApiConfig) GETChirpThread(writer http.ResponseWriter, req *http.Request) {
+	// Handles a GET request to chirps/{chirpID}/thread, returns the chirp with its ancestors and descendants
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	// Parses the chirp ID from the path
+	chirpUUID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		http.Error(writer, "Invalid chirp ID", http.StatusBadRequest)
+		return
+	}
+
+	// Queries the chirp the thread is centered on, which may be a tombstone
+	chirp, err := cfg.DBConn.GetExactChirpWithDeleted(req.Context(), chirpUUID)
+	if err != nil {
+		http.Error(writer, "Chirp not found", http.StatusNotFound)
+		return
+	}
+
+	// Walks up the reply chain, root first
+	ancestors, err := cfg.DBConn.GetChirpAncestors(req.Context(), chirpUUID)
+	if err != nil {
+		http.Error(writer, "Unable to get thread", http.StatusInternalServerError)
+		return
+	}
+
+	// Walks down every reply beneath the chirp, oldest first
+	descendants, err := cfg.DBConn.GetChirpDescendants(req.Context(), uuid.NullUUID{UUID: chirpUUID, Valid: true})
+	if err != nil {
+		http.Error(writer, "Unable to get thread", http.StatusInternalServerError)
+		return
+	}
+
+	// Builds the output object
+	thread := ChirpThread{
+		Ancestors:   make([]Chirp, 0, len(ancestors)),
+		Chirp:       chirpFromDB(chirp),
+		Descendants: make([]Chirp, 0, len(descendants)),
+	}
+	for _, c := range ancestors {
+		thread.Ancestors = append(thread.Ancestors, chirpFromDB(c))
+	}
+	for _, c := range descendants {
+		thread.Descendants = append(thread.Descendants, chirpFromDB(c))
+	}
+
+	// Fills in mention links and likes for every chirp in the thread
+	center := []Chirp{thread.Chirp}
+	if err := cfg.decorateChirps(req, thread.Ancestors, center, thread.Descendants); err != nil {
+		http.Error(writer, "Unable to get chirp details", http.StatusInternalServerError)
+		return
+	}
+	thread.Chirp = center[0]
+
+	outJson, err := json.Marshal(thread)
+	if err != nil {
+		http.Error(writer, "Failed to marshal data", http.StatusInternalServerError)
+		return
+	}
+
+	writer.WriteHeader(200)
+	writer.Write(outJson)
+}
@@ -1,17 +1,29 @@
 package chirpyserver
 
 import (
+	"log"
 	"net/http"
 )
 
 func (cfg *ApiConfig) Reset(writer http.ResponseWriter, req *http.Request) {
-	// Handles hit to /reset (resets hit counter to 0)
+	// Handles hit to /reset (resets hit counter to 0 and deletes every user), only on a development server
+
+	// Refuses outright unless the server was started in dev mode, so a reachable production server can't be wiped
+	// even by an admin
+	if !cfg.DevMode {
+		http.Error(writer, "Reset is only available in dev mode", http.StatusForbidden)
+		return
+	}
 
 	// Resets hit counter to 0
 	cfg.FileserverHits.Store(0)
 
 	// Deletes all records from the users table
-	cfg.DBConn.ResetUsers(req.Context())
+	if err := cfg.DBConn.ResetUsers(req.Context()); err != nil {
+		log.Printf("Failed to reset users: %v", err)
+		http.Error(writer, "Failed to reset users", http.StatusInternalServerError)
+		return
+	}
 
 	// Writes the response
 	writer.Header().Add("Content-Type", "text/plain; charset=utf-8")
@@ -0,0 +1,112 @@
+package chirpyserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/database"
+	"net/http"
+	"time"
+)
+
+func (cfg *ApiConfig) POSTRestoreChirp(writer http.ResponseWriter, req *http.Request) {
+	// Handles POST requests at chirps/{chirpID}/restore, lets the author or a moderator undo a delete
+
+	// Gets the caller's ID; RequireAuth has already checked their token's scope
+	UID, ok := currentUser(writer, req)
+	if !ok {
+		return
+	}
+
+	cfg.restoreChirp(writer, req, &UID)
+}
+
+func (cfg *ApiConfig) POSTAdminRestoreChirp(writer http.ResponseWriter, req *http.Request) {
+	// Handles POST requests at admin/chirps/{chirpID}/restore, restores any deleted chirp; only admins reach it
+
+	cfg.restoreChirp(writer, req, nil)
+}
+
+func (cfg *ApiConfig) restoreChirp(writer http.ResponseWriter, req *http.Request, caller *uuid.UUID) {
+	// Restores a soft-deleted chirp, checking caller wrote it or may moderate it unless caller is nil
+
+	// Parses the chirp ID from the path
+	CID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		writer.WriteHeader(400)
+		writer.Write([]byte("Unable to parse chirp ID"))
+		return
+	}
+
+	// Queries the chirp, including deleted ones
+	chirp, err := cfg.DBConn.GetExactChirpWithDeleted(req.Context(), CID)
+	if err != nil {
+		writer.WriteHeader(404)
+		writer.Write([]byte("Chirp not found"))
+		return
+	}
+
+	// Only the author or a moderator may restore the chirp
+	if caller != nil {
+		allowed, err := cfg.canModerate(req.Context(), *caller, chirp.UserID)
+		if err != nil {
+			writer.WriteHeader(500)
+			writer.Write([]byte("Unable to check permissions"))
+			return
+		}
+		if !allowed {
+			writer.WriteHeader(403)
+			writer.Write([]byte("Unauthorized"))
+			return
+		}
+	}
+
+	if !chirp.DeletedAt.Valid {
+		writer.WriteHeader(409)
+		writer.Write([]byte("Chirp is not deleted"))
+		return
+	}
+
+	// Purged chirps have lost their body and can't come back
+	if chirp.Tombstoned {
+		writer.WriteHeader(410)
+		writer.Write([]byte("Chirp has been purged"))
+		return
+	}
+
+	// The purger can tombstone the chirp after the check above, which leaves no row to restore
+	dbResp, err := cfg.DBConn.RestoreChirp(req.Context(), database.RestoreChirpParams{
+		ID:        CID,
+		UpdatedAt: time.Now().UTC(),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		writer.WriteHeader(410)
+		writer.Write([]byte("Chirp has been purged"))
+		return
+	}
+	if err != nil {
+		writer.WriteHeader(500)
+		writer.Write([]byte("Failed to restore chirp"))
+		return
+	}
+
+	// Fills in mention links and likes for the response
+	decorated := []Chirp{chirpFromDB(dbResp)}
+	if err := cfg.decorateChirps(req, decorated); err != nil {
+		writer.WriteHeader(500)
+		writer.Write([]byte("Failed to get chirp details"))
+		return
+	}
+
+	outJson, err := json.Marshal(decorated[0])
+	if err != nil {
+		writer.WriteHeader(500)
+		writer.Write([]byte("Failed to marshal data"))
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(200)
+	writer.Write(outJson)
+}
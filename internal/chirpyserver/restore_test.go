@@ -0,0 +1,60 @@
+package chirpyserver
+
+import (
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
+	"github.com/roxensox/chirpy/internal/database"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPOSTRestoreChirp(t *testing.T) {
+	author := uuid.New()
+	now := time.Now().UTC()
+	deleted := database.Chirp{ID: uuid.New(), CreatedAt: now, UpdatedAt: now, Body: "gone", UserID: author,
+		DeletedAt: sql.NullTime{Time: now, Valid: true}}
+
+	run := func(stored database.Chirp, restored fakeResult) int {
+		_, conn := newFakeDB(map[string]fakeQuery{
+			"GetExactChirpWithDeleted": returns(chirpResult(stored)),
+			"RestoreChirp":             returns(restored),
+			"GetChirpMentions":         returns(fakeResult{columns: []string{"chirp_id", "username", "user_id"}}),
+			"GetLikedChirpIDs":         returns(fakeResult{columns: []string{"chirp_id"}}),
+		})
+		cfg := &ApiConfig{DBConn: conn}
+
+		req := httptest.NewRequest("POST", "/api/chirps/"+stored.ID.String()+"/restore", nil)
+		req.SetPathValue("chirpID", stored.ID.String())
+		req = req.WithContext(WithPrincipal(context.Background(), Principal{UserID: author, Scopes: auth.AllScopes}))
+		rec := httptest.NewRecorder()
+		cfg.POSTRestoreChirp(rec, req)
+		return rec.Code
+	}
+
+	live := deleted
+	live.DeletedAt = sql.NullTime{}
+	purged := deleted
+	purged.Tombstoned = true
+
+	test_cases := []struct {
+		name     string
+		stored   database.Chirp
+		restored fakeResult
+		status   int
+	}{
+		{name: "restored", stored: deleted, restored: chirpResult(live), status: 200},
+		{name: "not deleted", stored: live, status: 409},
+		{name: "already purged", stored: purged, status: 410},
+		// The purger tombstones the chirp between the read and the restore, so no row matches
+		{name: "purged meanwhile", stored: deleted, restored: chirpResult(), status: 410},
+	}
+
+	for _, c := range test_cases {
+		if status := run(c.stored, c.restored); status != c.status {
+			t.Errorf("%s: expected status %d, got %d", c.name, c.status, status)
+		}
+	}
+}
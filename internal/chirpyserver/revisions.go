@@ -0,0 +1,193 @@
+package chirpyserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/database"
+	"net/http"
+	"time"
+)
+
+// DefaultChirpEditWindow is how long after posting a chirp can be edited when ChirpEditWindow isn't set
+const DefaultChirpEditWindow = 15 * time.Minute
+
+func (cfg *ApiConfig) PUTChirpByID(writer http.ResponseWriter, req *http.Request) {
+	// Handles PUT requests at the chirps/{chirpID} endpoint, lets the author edit a recent chirp
+
+	// Gets the chirp ID as a string from the endpoint and parses it
+	CID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		writer.WriteHeader(400)
+		writer.Write([]byte("Unable to parse chirp ID"))
+		return
+	}
+
+	// Queries the chirp from the DB
+	chirp, err := cfg.DBConn.GetExactChirp(req.Context(), CID)
+	if err != nil {
+		writer.WriteHeader(404)
+		writer.Write([]byte("Chirp not found"))
+		return
+	}
+
+	// Gets the caller's ID; RequireAuth has already checked their token's scope
+	UID, ok := currentUser(writer, req)
+	if !ok {
+		return
+	}
+
+	// Compares the chirp's user ID with the token's
+	if chirp.UserID != UID {
+		writer.WriteHeader(403)
+		writer.Write([]byte("Unauthorized"))
+		return
+	}
+
+	// Only allows edits within the configured window after posting
+	window := cfg.ChirpEditWindow
+	if window <= 0 {
+		window = DefaultChirpEditWindow
+	}
+	now := time.Now().UTC()
+	if now.Sub(chirp.CreatedAt) > window {
+		writer.WriteHeader(403)
+		writer.Write([]byte("Edit window has passed"))
+		return
+	}
+
+	// Decodes the new body
+	inObj := struct {
+		Body string `json:"body"`
+	}{}
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&inObj); err != nil {
+		writeValidationError(writer, &chirpValidationError{
+			Code:    "malformed",
+			Message: "request body must be JSON",
+		})
+		return
+	}
+
+	// Runs the new body through the same checks as a new chirp
+	cleanedBody, err := validateChirpBody(inObj.Body, cfg.chirpMaxLength(req.Context(), UID), cfg.Moderation)
+	if err != nil {
+		writeValidationError(writer, err)
+		return
+	}
+
+	// Saves the current version, writes the new body and replaces the stored hashtags and mentions together so
+	// a failure part way can't lose the old body from the history or leave the feeds out of step with the chirp.
+	// The chirp is re-read under a lock so concurrent edits each save the body the other one wrote
+	var outObj Chirp
+	err = cfg.inTx(req.Context(), func(q *database.Queries) error {
+		current, err := q.LockChirp(req.Context(), chirp.ID)
+		if err != nil {
+			return err
+		}
+
+		err = q.AddChirpRevision(req.Context(), database.AddChirpRevisionParams{
+			ID:         uuid.New(),
+			ChirpID:    current.ID,
+			Body:       current.Body,
+			CreatedAt:  current.UpdatedAt,
+			ReplacedAt: now,
+		})
+		if err != nil {
+			return err
+		}
+
+		dbResp, err := q.UpdateChirpBody(req.Context(), database.UpdateChirpBodyParams{
+			Body:      cleanedBody,
+			UpdatedAt: now,
+			ID:        current.ID,
+		})
+		if err != nil {
+			return err
+		}
+		outObj = chirpFromDB(dbResp)
+
+		if err := q.DeleteChirpTags(req.Context(), current.ID); err != nil {
+			return err
+		}
+		if err := q.DeleteChirpMentions(req.Context(), current.ID); err != nil {
+			return err
+		}
+		return storeChirpEntities(req.Context(), q, current.ID, outObj.Entities)
+	})
+	// The chirp was deleted since it was first read
+	if errors.Is(err, sql.ErrNoRows) {
+		writer.WriteHeader(404)
+		writer.Write([]byte("Chirp not found"))
+		return
+	}
+	if err != nil {
+		writer.WriteHeader(500)
+		writer.Write([]byte("Failed to update chirp"))
+		return
+	}
+
+	// Fills in mention links and likes for the response
+	decorated := []Chirp{outObj}
+	if err := cfg.decorateChirps(req, decorated); err != nil {
+		writer.WriteHeader(500)
+		writer.Write([]byte("Failed to get chirp details"))
+		return
+	}
+
+	outJson, err := json.Marshal(decorated[0])
+	if err != nil {
+		writer.WriteHeader(500)
+		writer.Write([]byte("Failed to marshal data"))
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(200)
+	writer.Write(outJson)
+}
+
+func (cfg *ApiConfig) GETChirpRevisions(writer http.ResponseWriter, req *http.Request) {
+	// Handles GET requests at chirps/{chirpID}/revisions, returns prior versions of a chirp, oldest first
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	CID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		http.Error(writer, "Invalid chirp ID", http.StatusBadRequest)
+		return
+	}
+
+	// Deleted chirps don't expose their history
+	if _, err := cfg.DBConn.GetExactChirp(req.Context(), CID); err != nil {
+		http.Error(writer, "Chirp not found", http.StatusNotFound)
+		return
+	}
+
+	revisions, err := cfg.DBConn.GetChirpRevisions(req.Context(), CID)
+	if err != nil {
+		http.Error(writer, "Unable to get revisions", http.StatusInternalServerError)
+		return
+	}
+
+	out := make([]ChirpRevision, 0, len(revisions))
+	for _, r := range revisions {
+		out = append(out, ChirpRevision{
+			ID:         r.ID,
+			ChirpID:    r.ChirpID,
+			Body:       r.Body,
+			CreatedAt:  r.CreatedAt,
+			ReplacedAt: r.ReplacedAt,
+		})
+	}
+
+	outJson, err := json.Marshal(out)
+	if err != nil {
+		http.Error(writer, "Failed to marshal data", http.StatusInternalServerError)
+		return
+	}
+
+	writer.WriteHeader(200)
+	writer.Write(outJson)
+}
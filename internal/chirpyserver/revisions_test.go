@@ -0,0 +1,138 @@
+package chirpyserver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
+	"github.com/roxensox/chirpy/internal/database"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPUTChirpByID(t *testing.T) {
+	authorID := uuid.New()
+
+	// Serves a single chirp posted age ago, recording revisions as the handler saves them; meanwhile
+	// changes the chirp after the handler's first read, as a concurrent request would
+	run := func(age time.Duration, caller uuid.UUID, updateErr error, meanwhile func(*database.Chirp)) (int, database.Chirp, [][]driver.Value, *fakeDB) {
+		posted := time.Now().UTC().Add(-age)
+		chirp := database.Chirp{ID: uuid.New(), CreatedAt: posted, UpdatedAt: posted, Body: "first draft", UserID: authorID}
+		var revisions [][]driver.Value
+		fake, conn := newFakeDB(map[string]fakeQuery{
+			"GetUserByID":   returns(userResult(database.User{ID: caller, Role: auth.RoleUser})),
+			"GetExactChirp": func([]driver.NamedValue) (fakeResult, error) { return chirpResult(chirp), nil },
+			"LockChirp": func([]driver.NamedValue) (fakeResult, error) {
+				if meanwhile != nil {
+					meanwhile(&chirp)
+				}
+				if chirp.DeletedAt.Valid {
+					return chirpResult(), nil
+				}
+				return chirpResult(chirp), nil
+			},
+			"AddChirpRevision": func(args []driver.NamedValue) (fakeResult, error) {
+				revisions = append(revisions, []driver.Value{args[0].Value, args[1].Value, args[2].Value, args[3].Value, args[4].Value})
+				return fakeResult{affected: 1}, nil
+			},
+			"UpdateChirpBody": func(args []driver.NamedValue) (fakeResult, error) {
+				if updateErr != nil {
+					return fakeResult{}, updateErr
+				}
+				chirp.Body = args[0].Value.(string)
+				chirp.UpdatedAt = args[1].Value.(time.Time)
+				return chirpResult(chirp), nil
+			},
+			"DeleteChirpTags":     returns(fakeResult{}),
+			"DeleteChirpMentions": returns(fakeResult{}),
+			"GetChirpMentions":    returns(fakeResult{columns: []string{"chirp_id", "username", "user_id"}}),
+			"GetLikedChirpIDs":    returns(fakeResult{columns: []string{"chirp_id"}}),
+		})
+		cfg := &ApiConfig{DBConn: conn, DB: fake.sqlDB}
+
+		req := httptest.NewRequest("PUT", "/api/chirps/"+chirp.ID.String(), strings.NewReader(`{"body": "second draft"}`))
+		req.SetPathValue("chirpID", chirp.ID.String())
+		req = req.WithContext(WithPrincipal(context.Background(), Principal{UserID: caller, Scopes: auth.AllScopes}))
+		rec := httptest.NewRecorder()
+		cfg.PUTChirpByID(rec, req)
+		return rec.Code, chirp, revisions, fake
+	}
+
+	code, chirp, revisions, fake := run(time.Minute, authorID, nil, nil)
+	if code != 200 {
+		t.Fatalf("Expected 200 for an edit inside the window, got %d", code)
+	}
+	if chirp.Body != "second draft" {
+		t.Errorf("Expected the new body to be saved, got %q", chirp.Body)
+	}
+	if len(revisions) != 1 || revisions[0][2] != "first draft" {
+		t.Errorf("Expected the old body to be kept as a revision, got %v", revisions)
+	}
+	if commits, _ := fake.txCounts(); commits != 1 {
+		t.Errorf("Expected the revision and new body to commit together, got %d commits", commits)
+	}
+
+	// Past the default window, or not the author, the chirp is left alone
+	if code, chirp, revisions, _ := run(DefaultChirpEditWindow+time.Minute, authorID, nil, nil); code != 403 || chirp.Body != "first draft" || len(revisions) != 0 {
+		t.Errorf("Expected a late edit to be refused, got %d", code)
+	}
+	if code, chirp, _, _ := run(time.Minute, uuid.New(), nil, nil); code != 403 || chirp.Body != "first draft" {
+		t.Errorf("Expected another user's edit to be refused, got %d", code)
+	}
+
+	// An edit that landed since the first read is the one saved to the history, not the stale body
+	_, chirp, revisions, _ = run(time.Minute, authorID, nil, func(c *database.Chirp) { c.Body = "edited meanwhile" })
+	if len(revisions) != 1 || revisions[0][2] != "edited meanwhile" {
+		t.Errorf("Expected the concurrent edit to be kept as a revision, got %v", revisions)
+	}
+
+	// A chirp deleted since the first read isn't edited
+	code, chirp, _, _ = run(time.Minute, authorID, nil, func(c *database.Chirp) { c.DeletedAt = sql.NullTime{Time: time.Now(), Valid: true} })
+	if code != 404 || chirp.Body != "first draft" {
+		t.Errorf("Expected 404 editing a chirp deleted meanwhile, got %d", code)
+	}
+
+	// The revision written before a failed update is rolled back with it
+	code, _, _, fake = run(time.Minute, authorID, errors.New("connection reset"), nil)
+	if code != 500 {
+		t.Errorf("Expected 500 when the update fails, got %d", code)
+	}
+	if commits, rollbacks := fake.txCounts(); commits != 0 || rollbacks != 1 {
+		t.Errorf("Expected the revision to roll back, got %d commits and %d rollbacks", commits, rollbacks)
+	}
+}
+
+func TestGETChirpRevisions(t *testing.T) {
+	chirpID := uuid.New()
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	_, conn := newFakeDB(map[string]fakeQuery{
+		"GetExactChirp": returns(chirpResult(database.Chirp{ID: chirpID, CreatedAt: start, UpdatedAt: start, Body: "third", UserID: uuid.New()})),
+		"GetChirpRevisions": returns(fakeResult{
+			columns: []string{"id", "chirp_id", "body", "created_at", "replaced_at"},
+			rows: [][]driver.Value{
+				{uuid.NewString(), chirpID.String(), "first", start, start.Add(time.Minute)},
+				{uuid.NewString(), chirpID.String(), "second", start.Add(time.Minute), start.Add(2 * time.Minute)},
+			},
+		}),
+	})
+	cfg := &ApiConfig{DBConn: conn}
+
+	req := httptest.NewRequest("GET", "/api/chirps/"+chirpID.String()+"/revisions", nil)
+	req.SetPathValue("chirpID", chirpID.String())
+	rec := httptest.NewRecorder()
+	cfg.GETChirpRevisions(rec, req)
+
+	if rec.Code != 200 {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	var out []ChirpRevision
+	json.Unmarshal(rec.Body.Bytes(), &out)
+	if len(out) != 2 || out[0].Body != "first" || out[1].Body != "second" {
+		t.Errorf("Expected both earlier bodies oldest first, got %+v", out)
+	}
+}
@@ -0,0 +1,196 @@
+package chirpyserver
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/database"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultRevocationCacheTTL = 30 * time.Second
+
+type cachedVersion struct {
+	version   int32
+	fetchedAt time.Time
+}
+
+// TokenRevocations answers access token revocation checks from memory, falling back to the database.
+// Changes made through it apply immediately on this instance; changes made elsewhere are picked up once
+// the cached entry is older than the TTL.
+type TokenRevocations struct {
+	db  *database.Queries
+	ttl time.Duration
+
+	mu       sync.Mutex
+	versions map[uuid.UUID]cachedVersion
+	denied   map[string]time.Time
+	allowed  map[string]time.Time
+}
+
+func NewTokenRevocations(db *database.Queries, ttl time.Duration) *TokenRevocations {
+	// Builds an empty revocation cache backed by db
+
+	if ttl <= 0 {
+		ttl = defaultRevocationCacheTTL
+	}
+	return &TokenRevocations{
+		db:       db,
+		ttl:      ttl,
+		versions: make(map[uuid.UUID]cachedVersion),
+		denied:   make(map[string]time.Time),
+		allowed:  make(map[string]time.Time),
+	}
+}
+
+func (r *TokenRevocations) TokenVersion(ctx context.Context, userID uuid.UUID) (int32, error) {
+	// Returns the user's current token version, from the cache while it's fresh
+
+	now := time.Now()
+	r.mu.Lock()
+	cached, ok := r.versions[userID]
+	r.mu.Unlock()
+	if ok && now.Sub(cached.fetchedAt) < r.ttl {
+		return cached.version, nil
+	}
+
+	version, err := r.db.GetTokenVersion(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	r.versions[userID] = cachedVersion{version: version, fetchedAt: now}
+	r.mu.Unlock()
+	return version, nil
+}
+
+func (r *TokenRevocations) IsTokenDenied(ctx context.Context, jti string) (bool, error) {
+	// Reports whether the token ID is denylisted, caching the answer from the database for the TTL
+
+	now := time.Now()
+	r.mu.Lock()
+	if _, ok := r.denied[jti]; ok {
+		r.mu.Unlock()
+		return true, nil
+	}
+	if checkedAt, ok := r.allowed[jti]; ok && now.Sub(checkedAt) < r.ttl {
+		r.mu.Unlock()
+		return false, nil
+	}
+	r.mu.Unlock()
+
+	denied, err := r.db.IsAccessTokenDenied(ctx, jti)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	r.prune(now)
+	if denied {
+		// The real expiry isn't known here, so the entry is kept for one TTL before being re-checked
+		r.denied[jti] = now.Add(r.ttl)
+	} else {
+		r.allowed[jti] = now
+	}
+	r.mu.Unlock()
+	return denied, nil
+}
+
+func (r *TokenRevocations) Deny(ctx context.Context, jti string, userID uuid.UUID, expiresAt time.Time) error {
+	// Denylists a single access token until it would have expired anyway
+
+	now := time.Now().UTC()
+	err := r.db.DenyAccessToken(ctx, database.DenyAccessTokenParams{
+		Jti:       jti,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Expired entries can't match a valid token, so they're cleared out as new ones arrive
+	if err := r.db.PurgeExpiredDeniedTokens(ctx, now); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.prune(now)
+	r.denied[jti] = expiresAt
+	delete(r.allowed, jti)
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *TokenRevocations) BumpVersion(ctx context.Context, userID uuid.UUID) (int32, error) {
+	// Invalidates every access token issued to the user so far
+
+	version, err := r.db.BumpTokenVersion(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	r.SetVersion(userID, version)
+	return version, nil
+}
+
+func (r *TokenRevocations) SetVersion(userID uuid.UUID, version int32) {
+	// Records a version change made outside BumpVersion, like the one UpdateUser makes on password changes
+
+	r.mu.Lock()
+	r.versions[userID] = cachedVersion{version: version, fetchedAt: time.Now()}
+	r.mu.Unlock()
+}
+
+func (r *TokenRevocations) prune(now time.Time) {
+	// Drops cache entries that no longer matter; the caller must hold r.mu
+
+	for jti, expiresAt := range r.denied {
+		if now.After(expiresAt) {
+			delete(r.denied, jti)
+		}
+	}
+	for jti, checkedAt := range r.allowed {
+		if now.Sub(checkedAt) >= r.ttl {
+			delete(r.allowed, jti)
+		}
+	}
+	for userID, cached := range r.versions {
+		if now.Sub(cached.fetchedAt) >= r.ttl {
+			delete(r.versions, userID)
+		}
+	}
+}
+
+func (cfg *ApiConfig) POSTLogout(writer http.ResponseWriter, req *http.Request) {
+	// Handles POST requests at the logout endpoint, denylists the access token the request was made with
+
+	p, ok := PrincipalFrom(req.Context())
+	if !ok {
+		writeTokenError(writer, errMissingToken)
+		return
+	}
+
+	// Personal access tokens are revoked at the tokens endpoint instead
+	clms := p.Claims
+	if clms == nil || clms.ExpiresAt == nil {
+		writer.WriteHeader(400)
+		writer.Write([]byte("Only access tokens can be logged out"))
+		return
+	}
+
+	if cfg.Revocations == nil {
+		writer.WriteHeader(501)
+		writer.Write([]byte("Token revocation is not configured"))
+		return
+	}
+	if err := cfg.Revocations.Deny(req.Context(), clms.ID, p.UserID, clms.ExpiresAt.Time); err != nil {
+		writer.WriteHeader(500)
+		writer.Write([]byte("Failed to revoke token"))
+		return
+	}
+
+	writer.WriteHeader(204)
+}
@@ -16,7 +16,7 @@ func (cfg *ApiConfig) POSTRevoke(writer http.ResponseWriter, req *http.Request)
 		return
 	}
 	params := database.RevokeTokenParams{
-		Token: tkn,
+		TokenHash: cfg.hashRefreshToken(tkn),
 		RevokedAt: sql.NullTime{
 			Time:  time.Now().UTC(),
 			Valid: true,
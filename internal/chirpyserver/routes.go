@@ -0,0 +1,79 @@
+package chirpyserver
+
+import (
+	"github.com/roxensox/chirpy/internal/auth"
+	"net/http"
+)
+
+func (cfg *ApiConfig) Routes() *http.ServeMux {
+	// Builds a mux with every API and admin route bound to its handler and the auth it requires
+
+	sMux := http.NewServeMux()
+
+	// Binds functions to POST handlers
+	sMux.HandleFunc("POST /admin/reset", cfg.RequirePermission(auth.PermManageServer, cfg.Reset))
+	sMux.HandleFunc("POST /admin/moderation/reload", cfg.RequirePermission(auth.PermManageServer, cfg.POSTReloadModeration))
+	sMux.HandleFunc("POST /admin/chirps/{chirpID}/restore", cfg.RequirePermission(auth.PermRestoreChirps, cfg.POSTAdminRestoreChirp))
+	sMux.HandleFunc("POST /admin/keys/reload", cfg.RequirePermission(auth.PermManageServer, cfg.POSTReloadKeys))
+	sMux.HandleFunc("POST /admin/users/{userID}/unlock", cfg.RequirePermission(auth.PermUnlockUsers, cfg.POSTUnlockUser))
+	sMux.HandleFunc("POST /api/validate_chirp", cfg.OptionalAuth(auth.ScopeChirpsRead, cfg.ValidateChirp))
+	sMux.HandleFunc("POST /api/users", cfg.POSTUsers)
+	sMux.HandleFunc("POST /api/chirps", cfg.RequireAuth(auth.ScopeChirpsWrite, cfg.POSTChirps))
+	sMux.HandleFunc("POST /api/login", cfg.POSTLogin)
+	sMux.HandleFunc("POST /api/login/mfa", cfg.POSTLoginMFA)
+	sMux.HandleFunc("POST /api/mfa/totp", cfg.RequireAuth(auth.ScopeAccount, cfg.POSTEnrollTOTP))
+	sMux.HandleFunc("POST /api/mfa/totp/confirm", cfg.RequireAuth(auth.ScopeAccount, cfg.POSTConfirmTOTP))
+	sMux.HandleFunc("POST /api/refresh", cfg.POSTRefresh)
+	sMux.HandleFunc("POST /api/revoke", cfg.POSTRevoke)
+	sMux.HandleFunc("POST /api/logout", cfg.RequireAuth("", cfg.POSTLogout))
+	sMux.HandleFunc("POST /api/password-reset", cfg.POSTPasswordReset)
+	sMux.HandleFunc("POST /api/password-reset/confirm", cfg.POSTPasswordResetConfirm)
+	sMux.HandleFunc("POST /api/users/verify", cfg.POSTVerifyEmail)
+	sMux.HandleFunc("POST /api/users/verify/resend", cfg.RequireAuth(auth.ScopeAccount, cfg.POSTResendVerification))
+	sMux.HandleFunc("POST /api/tokens", cfg.RequireAuth(auth.ScopeAccount, cfg.POSTAPITokens))
+	sMux.HandleFunc("POST /api/oauth/clients", cfg.RequireAuth(auth.ScopeAccount, cfg.POSTOAuthClients))
+	sMux.HandleFunc("POST /api/oauth/authorize", cfg.RequireAuth(auth.ScopeAccount, cfg.POSTOAuthAuthorize))
+	sMux.HandleFunc("POST /api/oauth/token", cfg.POSTOAuthToken)
+	sMux.HandleFunc("POST /api/polka/webhooks", cfg.POSTPolkaWebhooks)
+	sMux.HandleFunc("POST /api/users/{userID}/follow", cfg.RequireAuth(auth.ScopeProfileWrite, cfg.POSTFollow))
+	sMux.HandleFunc("POST /api/chirps/{chirpID}/likes", cfg.RequireAuth(auth.ScopeChirpsWrite, cfg.POSTChirpLike))
+	sMux.HandleFunc("POST /api/chirps/{chirpID}/restore", cfg.RequireAuth(auth.ScopeChirpsWrite, cfg.POSTRestoreChirp))
+
+	// Binds functions to PUT handlers
+	sMux.HandleFunc("PUT /api/users", cfg.RequireAuth(auth.ScopeAccount, cfg.PUTUsers))
+	sMux.HandleFunc("PUT /api/users/profile", cfg.RequireAuth(auth.ScopeProfileWrite, cfg.PUTUserProfile))
+	sMux.HandleFunc("PUT /admin/users/{userID}/role", cfg.RequirePermission(auth.PermManageRoles, cfg.PUTUserRole))
+	sMux.HandleFunc("PUT /api/chirps/{chirpID}", cfg.RequireAuth(auth.ScopeChirpsWrite, cfg.PUTChirpByID))
+
+	// Binds functions to GET handlers
+	sMux.HandleFunc("GET /api/healthz", Healthz)
+	sMux.HandleFunc("GET /admin/metrics", cfg.RequirePermission(auth.PermViewMetrics, cfg.FServerHits))
+	sMux.HandleFunc("GET /.well-known/jwks.json", cfg.GETJWKS)
+	sMux.HandleFunc("GET /api/chirps", cfg.OptionalAuth(auth.ScopeChirpsRead, cfg.GETChirps))
+	sMux.HandleFunc("GET /api/chirps/search", cfg.OptionalAuth(auth.ScopeChirpsRead, cfg.GETChirpSearch))
+	sMux.HandleFunc("GET /api/chirps/{chirpID}", cfg.OptionalAuth(auth.ScopeChirpsRead, cfg.GETChirpByID))
+	sMux.HandleFunc("GET /api/chirps/{chirpID}/replies", cfg.OptionalAuth(auth.ScopeChirpsRead, cfg.GETChirpReplies))
+	sMux.HandleFunc("GET /api/chirps/{chirpID}/thread", cfg.OptionalAuth(auth.ScopeChirpsRead, cfg.GETChirpThread))
+	sMux.HandleFunc("GET /api/chirps/{chirpID}/revisions", cfg.GETChirpRevisions)
+	sMux.HandleFunc("GET /api/users/{userID}/followers", cfg.GETFollowers)
+	sMux.HandleFunc("GET /api/users/{userID}/following", cfg.GETFollowing)
+	sMux.HandleFunc("GET /api/timeline", cfg.RequireAuth(auth.ScopeChirpsRead, cfg.GETTimeline))
+	sMux.HandleFunc("GET /api/sessions", cfg.RequireAuth(auth.ScopeAccount, cfg.GETSessions))
+	sMux.HandleFunc("GET /api/tokens", cfg.RequireAuth(auth.ScopeAccount, cfg.GETAPITokens))
+	sMux.HandleFunc("GET /api/oauth/authorize", cfg.RequireAuth(auth.ScopeAccount, cfg.GETOAuthAuthorize))
+	sMux.HandleFunc("GET /api/oauth/consents", cfg.RequireAuth(auth.ScopeAccount, cfg.GETOAuthConsents))
+	sMux.HandleFunc("GET /api/tags/{tag}/chirps", cfg.OptionalAuth(auth.ScopeChirpsRead, cfg.GETTagChirps))
+	sMux.HandleFunc("GET /api/users/{userID}/mentions", cfg.OptionalAuth(auth.ScopeChirpsRead, cfg.GETUserMentions))
+
+	// Binds functions to DELETE handlers
+	sMux.HandleFunc("DELETE /api/chirps/{chirpID}", cfg.RequireAuth(auth.ScopeChirpsWrite, cfg.DELETEChirpByID))
+	sMux.HandleFunc("DELETE /api/users/{userID}/follow", cfg.RequireAuth(auth.ScopeProfileWrite, cfg.DELETEFollow))
+	sMux.HandleFunc("DELETE /api/chirps/{chirpID}/likes", cfg.RequireAuth(auth.ScopeChirpsWrite, cfg.DELETEChirpLike))
+	sMux.HandleFunc("DELETE /api/sessions", cfg.RequireAuth(auth.ScopeAccount, cfg.DELETESessions))
+	sMux.HandleFunc("DELETE /api/sessions/{id}", cfg.RequireAuth(auth.ScopeAccount, cfg.DELETESession))
+	sMux.HandleFunc("DELETE /api/tokens/{id}", cfg.RequireAuth(auth.ScopeAccount, cfg.DELETEAPIToken))
+	sMux.HandleFunc("DELETE /api/oauth/consents/{clientID}", cfg.RequireAuth(auth.ScopeAccount, cfg.DELETEOAuthConsent))
+	sMux.HandleFunc("DELETE /api/mfa/totp", cfg.RequireAuth(auth.ScopeAccount, cfg.DELETETOTP))
+
+	return sMux
+}
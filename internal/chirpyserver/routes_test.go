@@ -0,0 +1,48 @@
+package chirpyserver
+
+import (
+	"database/sql/driver"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAdminRoutesRequireAdmin(t *testing.T) {
+	keys := auth.NewKeySet()
+	keys.Add(auth.NewHMACKey("default", "secret"))
+	keys.SetSigningKey("default")
+
+	_, conn := newFakeDB(map[string]fakeQuery{
+		"GetUserRole": returns(fakeResult{columns: []string{"role"}, rows: [][]driver.Value{{auth.RoleUser}}}),
+	})
+	cfg := &ApiConfig{DBConn: conn, Keys: keys}
+	mux := cfg.Routes()
+	user, _ := auth.MakeJWT(uuid.New(), 0, keys, time.Hour)
+
+	routes := []struct {
+		method string
+		path   string
+	}{
+		{"POST", "/admin/reset"},
+		{"POST", "/admin/moderation/reload"},
+		{"POST", "/admin/chirps/" + uuid.NewString() + "/restore"},
+		{"POST", "/admin/keys/reload"},
+		{"POST", "/admin/users/" + uuid.NewString() + "/unlock"},
+	}
+
+	for _, r := range routes {
+		for token, expected := range map[string]int{"": 401, user: 403} {
+			req := httptest.NewRequest(r.method, r.path, nil)
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != expected {
+				t.Errorf("%s %s: expected status %d, got %d", r.method, r.path, expected, rec.Code)
+			}
+		}
+	}
+}
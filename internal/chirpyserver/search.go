@@ -0,0 +1,130 @@
+package chirpyserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/roxensox/chirpy/internal/database"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+func buildTSQuery(q string) (string, error) {
+	// Converts a user search string into a Postgres tsquery
+	// "quoted words" become phrase matches and a trailing * makes a prefix match
+
+	terms := make([]string, 0)
+
+	// Splits the input into alternating unquoted and quoted sections
+	for i, section := range strings.Split(q, "\"") {
+		quoted := i%2 == 1
+		if quoted {
+			if term := tsPhrase(section); term != "" {
+				terms = append(terms, term)
+			}
+			continue
+		}
+		for _, field := range strings.Fields(section) {
+			if term := tsPhrase(field); term != "" {
+				terms = append(terms, term)
+			}
+		}
+	}
+
+	if len(terms) == 0 {
+		return "", fmt.Errorf("Search query is empty")
+	}
+
+	// Requires every term to match
+	return strings.Join(terms, " & "), nil
+}
+
+func tsPhrase(s string) string {
+	// Turns a run of text into adjacent tsquery lexemes, stripping anything that isn't a letter or digit
+
+	prefix := strings.HasSuffix(strings.TrimSpace(s), "*")
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return ""
+	}
+
+	// Marks the final word as a prefix match when it ended with *
+	if prefix {
+		words[len(words)-1] += ":*"
+	}
+	if len(words) == 1 {
+		return words[0]
+	}
+	return "(" + strings.Join(words, " <-> ") + ")"
+}
+
+func (cfg *ApiConfig) GETChirpSearch(writer http.ResponseWriter, req *http.Request) {
+	// Handles GET requests at chirps/search, returns one page of chirps matching q, best match first
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	// Builds the tsquery from the search string
+	tsQuery, err := buildTSQuery(req.URL.Query().Get("q"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Reads the page size and offset; ranked results have no stable key to seek on
+	limit, err := parsePageLimit(req.URL.Query())
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var offset int32
+	if c := req.URL.Query().Get("cursor"); c != "" {
+		offset, err = decodeOffsetCursor(c)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Fetches one extra row to find out whether another page follows
+	results, err := cfg.DBConn.SearchChirps(req.Context(), database.SearchChirpsParams{
+		Query:      tsQuery,
+		PageLimit:  limit + 1,
+		PageOffset: offset,
+	})
+	if err != nil {
+		http.Error(writer, "Unable to search chirps", http.StatusInternalServerError)
+		return
+	}
+
+	// Trims the extra row and points the next cursor past this page
+	nextCursor := ""
+	if len(results) > int(limit) {
+		results = results[:limit]
+		nextCursor = encodeOffsetCursor(offset + limit)
+	}
+
+	out := make([]Chirp, 0, len(results))
+	for _, c := range results {
+		out = append(out, chirpFromDB(c))
+	}
+
+	// Fills in mention links and likes
+	if err := cfg.decorateChirps(req, out); err != nil {
+		http.Error(writer, "Unable to get chirp details", http.StatusInternalServerError)
+		return
+	}
+
+	outJson, err := json.Marshal(ChirpPage{
+		Chirps:     out,
+		NextCursor: nextCursor,
+	})
+	if err != nil {
+		http.Error(writer, "Failed to marshal data", http.StatusInternalServerError)
+		return
+	}
+
+	writer.WriteHeader(200)
+	writer.Write(outJson)
+}
@@ -0,0 +1,54 @@
+package chirpyserver
+
+import (
+	"testing"
+)
+
+func TestBuildTSQuery(t *testing.T) {
+	test_cases := []struct {
+		input    string
+		expected string
+		wantErr  bool
+	}{
+		{
+			input:    "chirpy",
+			expected: "chirpy",
+		},
+		{
+			input:    "hello world",
+			expected: "hello & world",
+		},
+		{
+			input:    "\"big red dog\"",
+			expected: "(big <-> red <-> dog)",
+		},
+		{
+			input:    "chirp*",
+			expected: "chirp:*",
+		},
+		{
+			input:    "\"hello wor*\" again",
+			expected: "(hello <-> wor:*) & again",
+		},
+		// Operator characters are stripped rather than passed through
+		{
+			input:    "a|b & !c",
+			expected: "(a <-> b) & c",
+		},
+		{
+			input:   "  \"\" !! ",
+			wantErr: true,
+		},
+	}
+
+	for i, tc := range test_cases {
+		out, err := buildTSQuery(tc.input)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("Case #%d: expected error %v, got %v", i, tc.wantErr, err)
+			continue
+		}
+		if out != tc.expected {
+			t.Errorf("Case #%d: expected %q, got %q", i, tc.expected, out)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package chirpyserver
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/database"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+func (cfg *ApiConfig) logSecurityEvent(ctx context.Context, userID uuid.UUID, event, detail string) {
+	// Records a security-relevant event for later review; failures are logged but never block the request
+
+	log.Printf("Security event %s for user %s: %s", event, userID, detail)
+
+	err := cfg.DBConn.AddSecurityEvent(ctx, database.AddSecurityEventParams{
+		ID:        uuid.New(),
+		UserID:    uuid.NullUUID{UUID: userID, Valid: userID != uuid.Nil},
+		Event:     event,
+		Detail:    detail,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("Failed to record security event %s: %v", event, err)
+	}
+}
+
+func clientIP(req *http.Request) string {
+	// Returns the address of the connecting client without its port
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -1,39 +1,159 @@
 package chirpyserver
 
 import (
+	"database/sql"
 	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
 	"github.com/roxensox/chirpy/internal/database"
+	"github.com/roxensox/chirpy/internal/mail"
+	"github.com/roxensox/chirpy/internal/moderation"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
 type ApiConfig struct {
-	FileserverHits atomic.Int32
-	DBConn         *database.Queries
-	Secret         string
-	APIKey         string
+	FileserverHits       atomic.Int32
+	DBConn               *database.Queries
+	DB                   *sql.DB
+	Keys                 *auth.KeySet
+	KeysDir              string
+	Validator            *auth.Validator
+	APIKey               string
+	ChirpMaxLength       int
+	RedChirpMaxLength    int
+	Moderation           *moderation.Filter
+	ChirpEditWindow      time.Duration
+	ChirpRetention       time.Duration
+	Revocations          *TokenRevocations
+	Mailer               mail.Sender
+	PasswordResetTTL     time.Duration
+	RequireVerifiedEmail bool
+	DevMode              bool
+	MFAKey               []byte
+	TokenHashKey         []byte
+	LoginThrottle        *LoginThrottle
+	throttleOnce         sync.Once
+	background           sync.WaitGroup
+}
+
+type APIToken struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Token      string     `json:"token,omitempty"`
+}
+
+type OAuthClient struct {
+	ID           uuid.UUID `json:"client_id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Confidential bool      `json:"confidential"`
+	CreatedAt    time.Time `json:"created_at"`
+	Secret       string    `json:"client_secret,omitempty"`
+}
+
+type OAuthAuthorization struct {
+	ClientID   uuid.UUID `json:"client_id"`
+	ClientName string    `json:"client_name"`
+	Scopes     []string  `json:"scopes"`
+	Consented  bool      `json:"consented"`
+}
+
+type OAuthConsent struct {
+	ClientID   uuid.UUID `json:"client_id"`
+	ClientName string    `json:"client_name"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type OAuthToken struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
 }
 
 type ValidateResponse struct {
 	Valid       bool   `json:"valid"`
 	Error       string `json:"error"`
+	Code        string `json:"code"`
 	CleanedBody string `json:"cleaned_body"`
 }
 
 type Chirp struct {
-	ID        uuid.UUID `json:"id"`
-	Body      string    `json:"body"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	UserID    uuid.UUID `json:"user_id"`
+	ID        uuid.UUID     `json:"id"`
+	Body      string        `json:"body"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	UserID    uuid.UUID     `json:"user_id"`
+	InReplyTo *uuid.UUID    `json:"in_reply_to"`
+	Deleted   bool          `json:"deleted"`
+	LikeCount int32         `json:"like_count"`
+	LikedByMe bool          `json:"liked_by_me"`
+	Entities  []ChirpEntity `json:"entities"`
+}
+
+type ChirpEntity struct {
+	Type   string     `json:"type"`
+	Text   string     `json:"text"`
+	Start  int        `json:"start"`
+	End    int        `json:"end"`
+	UserID *uuid.UUID `json:"user_id"`
+}
+
+type ChirpRevision struct {
+	ID         uuid.UUID `json:"id"`
+	ChirpID    uuid.UUID `json:"chirp_id"`
+	Body       string    `json:"body"`
+	CreatedAt  time.Time `json:"created_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+type ChirpThread struct {
+	Ancestors   []Chirp `json:"ancestors"`
+	Chirp       Chirp   `json:"chirp"`
+	Descendants []Chirp `json:"descendants"`
+}
+
+type ChirpPage struct {
+	Chirps     []Chirp `json:"chirps"`
+	NextCursor string  `json:"next_cursor"`
+}
+
+type Follow struct {
+	UserID     uuid.UUID `json:"user_id"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+type FollowPage struct {
+	Users      []Follow `json:"users"`
+	NextCursor string   `json:"next_cursor"`
+}
+
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	StartedAt  time.Time `json:"started_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
 }
 
 type User struct {
-	Email        string    `json:"email"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ID           uuid.UUID `json:"id"`
-	Token        string    `json:"token"`
-	RefreshToken string    `json:"refresh_token"`
-	IsChirpyRed  bool      `json:"is_chirpy_red"`
+	Email         string    `json:"email"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	ID            uuid.UUID `json:"id"`
+	Token         string    `json:"token"`
+	RefreshToken  string    `json:"refresh_token"`
+	IsChirpyRed   bool      `json:"is_chirpy_red"`
+	Username      string    `json:"username"`
+	EmailVerified bool      `json:"email_verified"`
+	Role          string    `json:"role"`
 }
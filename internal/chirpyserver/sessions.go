@@ -0,0 +1,123 @@
+package chirpyserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/database"
+	"net/http"
+	"time"
+)
+
+func (cfg *ApiConfig) GETSessions(writer http.ResponseWriter, req *http.Request) {
+	// Handles GET requests at the sessions endpoint, lists the caller's active sessions
+
+	writer.Header().Set("Content-Type", "application/json")
+
+	// Gets the caller's ID; RequireAuth has already checked their token's scope
+	UID, ok := currentUser(writer, req)
+	if !ok {
+		return
+	}
+
+	// Each session is a refresh token family; only its current token is live
+	rows, err := cfg.DBConn.GetActiveSessions(req.Context(), database.GetActiveSessionsParams{
+		UserID:    UID,
+		ExpiresAt: time.Now().UTC(),
+	})
+	if err != nil {
+		http.Error(writer, "Unable to get sessions", http.StatusInternalServerError)
+		return
+	}
+
+	out := make([]Session, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, Session{
+			ID:         r.FamilyID,
+			StartedAt:  r.StartedAt,
+			LastUsedAt: r.LastUsedAt,
+			ExpiresAt:  r.ExpiresAt,
+			UserAgent:  r.UserAgent,
+			IPAddress:  r.IpAddress,
+		})
+	}
+
+	outJson, err := json.Marshal(out)
+	if err != nil {
+		http.Error(writer, "Failed to marshal data", http.StatusInternalServerError)
+		return
+	}
+
+	writer.WriteHeader(200)
+	writer.Write(outJson)
+}
+
+func (cfg *ApiConfig) DELETESession(writer http.ResponseWriter, req *http.Request) {
+	// Handles DELETE requests at sessions/{id}, revokes one of the caller's sessions
+
+	// Gets the caller's ID; RequireAuth has already checked their token's scope
+	UID, ok := currentUser(writer, req)
+	if !ok {
+		return
+	}
+
+	sessionID, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		writer.WriteHeader(400)
+		writer.Write([]byte("Invalid session ID"))
+		return
+	}
+
+	// Revokes the family, scoped to the caller so other users' sessions can't be touched
+	revoked, err := cfg.DBConn.RevokeUserTokenFamily(req.Context(), database.RevokeUserTokenFamilyParams{
+		FamilyID:  sessionID,
+		UserID:    UID,
+		RevokedAt: sql.NullTime{Time: time.Now().UTC(), Valid: true},
+	})
+	if err != nil {
+		writer.WriteHeader(500)
+		writer.Write([]byte("Failed to revoke session"))
+		return
+	}
+	if revoked == 0 {
+		writer.WriteHeader(404)
+		writer.Write([]byte("Session not found"))
+		return
+	}
+
+	writer.WriteHeader(204)
+}
+
+func (cfg *ApiConfig) DELETESessions(writer http.ResponseWriter, req *http.Request) {
+	// Handles DELETE requests at the sessions endpoint, logs the caller out everywhere
+
+	// Gets the caller's ID; RequireAuth has already checked their token's scope
+	UID, ok := currentUser(writer, req)
+	if !ok {
+		return
+	}
+
+	err := cfg.DBConn.RevokeAllUserTokens(req.Context(), database.RevokeAllUserTokensParams{
+		UserID:    UID,
+		RevokedAt: sql.NullTime{Time: time.Now().UTC(), Valid: true},
+	})
+	if err != nil {
+		writer.WriteHeader(500)
+		writer.Write([]byte("Failed to revoke sessions"))
+		return
+	}
+
+	// Also invalidates every access token already handed out, including the caller's
+	if cfg.Revocations != nil {
+		_, err = cfg.Revocations.BumpVersion(req.Context(), UID)
+	} else {
+		_, err = cfg.DBConn.BumpTokenVersion(req.Context(), UID)
+	}
+	if err != nil {
+		writer.WriteHeader(500)
+		writer.Write([]byte("Failed to revoke access tokens"))
+		return
+	}
+
+	writer.WriteHeader(204)
+}
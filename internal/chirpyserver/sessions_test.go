@@ -0,0 +1,162 @@
+package chirpyserver
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	store := newOAuthStore()
+	queries := store.queries()
+
+	// Answers the session queries from the same refresh token rows the rest of the flow writes
+	sessionCols := []string{"family_id", "started_at", "last_used_at", "expires_at", "user_agent", "ip_address"}
+	queries["GetActiveSessions"] = func(args []driver.NamedValue) (fakeResult, error) {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		res := fakeResult{columns: sessionCols}
+		for _, row := range store.refresh {
+			if row[5] == args[0].Value && row[4] == nil && row[7] == nil && row[3].(time.Time).After(args[1].Value.(time.Time)) {
+				res.rows = append(res.rows, []driver.Value{row[6], row[1], row[10], row[3], row[8], row[9]})
+			}
+		}
+		return res, nil
+	}
+	revokeWhere := func(match func(row []driver.Value) bool, revokedAt driver.Value) fakeResult {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		res := fakeResult{}
+		for _, row := range store.refresh {
+			if match(row) && row[4] == nil {
+				row[4] = revokedAt
+				res.affected++
+			}
+		}
+		return res
+	}
+	queries["RevokeUserTokenFamily"] = func(args []driver.NamedValue) (fakeResult, error) {
+		return revokeWhere(func(row []driver.Value) bool {
+			return row[6] == args[0].Value && row[5] == args[1].Value
+		}, args[2].Value), nil
+	}
+	queries["RevokeAllUserTokens"] = func(args []driver.NamedValue) (fakeResult, error) {
+		return revokeWhere(func(row []driver.Value) bool { return row[5] == args[0].Value }, args[1].Value), nil
+	}
+	var bumped []driver.Value
+	queries["BumpTokenVersion"] = func(args []driver.NamedValue) (fakeResult, error) {
+		bumped = append(bumped, args[0].Value)
+		return fakeResult{columns: []string{"token_version"}, rows: [][]driver.Value{{int64(len(bumped))}}}, nil
+	}
+	_, conn := newFakeDB(queries)
+	keys := auth.NewKeySet()
+	keys.Add(auth.NewHMACKey("default", "secret"))
+	keys.SetSigningKey("default")
+	cfg := &ApiConfig{DBConn: conn, Keys: keys, TokenHashKey: []byte("token hash key")}
+
+	alice, bob := uuid.New(), uuid.New()
+	login := func(userID uuid.UUID, userAgent string) (uuid.UUID, string) {
+		familyID := uuid.New()
+		req := httptest.NewRequest("POST", "/api/login", nil)
+		req.Header.Set("User-Agent", userAgent)
+		_, refTkn, err := cfg.startSession(req, userID, 0, familyID, sessionGrant{})
+		if err != nil {
+			t.Fatalf("Failed to start session: %v", err)
+		}
+		return familyID, refTkn
+	}
+	as := func(userID uuid.UUID, method, path string) *http.Request {
+		req := httptest.NewRequest(method, path, nil)
+		return req.WithContext(WithPrincipal(context.Background(), Principal{UserID: userID, Scopes: auth.AllScopes}))
+	}
+	list := func(userID uuid.UUID) []Session {
+		rec := httptest.NewRecorder()
+		cfg.GETSessions(rec, as(userID, "GET", "/api/sessions"))
+		if rec.Code != 200 {
+			t.Fatalf("Expected 200 listing sessions, got %d", rec.Code)
+		}
+		var out []Session
+		json.NewDecoder(rec.Body).Decode(&out)
+		return out
+	}
+	revoke := func(userID uuid.UUID, id string) int {
+		req := as(userID, "DELETE", "/api/sessions/"+id)
+		req.SetPathValue("id", id)
+		rec := httptest.NewRecorder()
+		cfg.DELETESession(rec, req)
+		return rec.Code
+	}
+	revoked := func(tkn string) bool {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return store.refresh[cfg.hashRefreshToken(tkn)][4] != nil
+	}
+
+	phone, phoneTkn := login(alice, "phone")
+	laptop, _ := login(alice, "laptop")
+	bobFamily, bobTkn := login(bob, "desktop")
+
+	// A refreshed session is still listed once, under its family
+	req := httptest.NewRequest("POST", "/api/refresh", nil)
+	req.Header.Set("Authorization", "Bearer "+phoneTkn)
+	rec := httptest.NewRecorder()
+	cfg.POSTRefresh(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("Expected 200 refreshing the phone session, got %d", rec.Code)
+	}
+
+	// The caller only sees their own sessions
+	sessions := list(alice)
+	if len(sessions) != 2 {
+		t.Fatalf("Expected alice's two sessions, got %d", len(sessions))
+	}
+	for _, s := range sessions {
+		if s.ID != phone && s.ID != laptop {
+			t.Errorf("Unexpected session %s in alice's list", s.ID)
+		}
+	}
+
+	// Another user's session can't be revoked, and looks the same as one that doesn't exist
+	if status := revoke(alice, bobFamily.String()); status != 404 {
+		t.Errorf("Expected 404 revoking another user's session, got %d", status)
+	}
+	if revoked(bobTkn) {
+		t.Errorf("Expected bob's session to survive alice's request")
+	}
+	if status := revoke(alice, "not-a-uuid"); status != 400 {
+		t.Errorf("Expected 400 for a malformed session ID, got %d", status)
+	}
+
+	// Revoking one of the caller's sessions leaves the others
+	if status := revoke(alice, phone.String()); status != 204 {
+		t.Errorf("Expected 204 revoking alice's phone session, got %d", status)
+	}
+	if sessions := list(alice); len(sessions) != 1 || sessions[0].ID != laptop {
+		t.Errorf("Expected only the laptop session to remain, got %v", sessions)
+	}
+	if status := revoke(alice, phone.String()); status != 404 {
+		t.Errorf("Expected 404 revoking a session twice, got %d", status)
+	}
+
+	// Logging out everywhere ends every session and access token of the caller's, and only theirs
+	rec = httptest.NewRecorder()
+	cfg.DELETESessions(rec, as(alice, "DELETE", "/api/sessions"))
+	if rec.Code != 204 {
+		t.Errorf("Expected 204 logging out everywhere, got %d", rec.Code)
+	}
+	if sessions := list(alice); len(sessions) != 0 {
+		t.Errorf("Expected no sessions after logging out everywhere, got %d", len(sessions))
+	}
+	if len(bumped) != 1 || bumped[0] != alice.String() {
+		t.Errorf("Expected alice's token version to be bumped once, got %v", bumped)
+	}
+	if sessions := list(bob); len(sessions) != 1 || revoked(bobTkn) {
+		t.Errorf("Expected bob's session to survive alice logging out everywhere")
+	}
+}
@@ -0,0 +1,276 @@
+package chirpyserver
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/database"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BackoffPolicy decides how long a client must wait after a run of failed logins
+type BackoffPolicy struct {
+	// FreeAttempts failures are allowed before any delay applies
+	FreeAttempts int
+	// BaseDelay doubles with each failure past FreeAttempts, up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutAttempts failures lock the client out for LockoutDuration
+	LockoutAttempts int
+	LockoutDuration time.Duration
+	// Failures older than Window are forgotten
+	Window time.Duration
+}
+
+var (
+	DefaultAccountBackoff = BackoffPolicy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		LockoutAttempts: 10,
+		LockoutDuration: 15 * time.Minute,
+		Window:          time.Hour,
+	}
+	DefaultIPBackoff = BackoffPolicy{
+		FreeAttempts:    10,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		LockoutAttempts: 50,
+		LockoutDuration: 15 * time.Minute,
+		Window:          time.Hour,
+	}
+)
+
+func (p BackoffPolicy) Delay(failures int) time.Duration {
+	// Returns how long to wait after the given number of consecutive failures
+
+	if p.LockoutAttempts > 0 && failures >= p.LockoutAttempts {
+		return p.LockoutDuration
+	}
+	if failures < p.FreeAttempts {
+		return 0
+	}
+
+	// Caps the exponent so the delay can't overflow before MaxDelay applies
+	exp := min(failures-p.FreeAttempts, 32)
+	delay := time.Duration(float64(p.BaseDelay) * math.Pow(2, float64(exp)))
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+func (p BackoffPolicy) blockedUntil(failures int, lastFailure time.Time) time.Time {
+	// Returns when the next attempt is allowed
+
+	return lastFailure.Add(p.Delay(failures))
+}
+
+type failureCount struct {
+	count int
+	last  time.Time
+}
+
+// LoginThrottle slows down password guessing per account, in the database, and per client IP, in memory
+type LoginThrottle struct {
+	Account BackoffPolicy
+	IP      BackoffPolicy
+
+	mu      sync.Mutex
+	ips     map[string]failureCount
+	unknown map[string]failureCount
+}
+
+func NewLoginThrottle(account, ip BackoffPolicy) *LoginThrottle {
+	// Builds a throttle with no recorded failures
+
+	return &LoginThrottle{
+		Account: account,
+		IP:      ip,
+		ips:     make(map[string]failureCount),
+		unknown: make(map[string]failureCount),
+	}
+}
+
+func (t *LoginThrottle) claimIP(ip string, now time.Time) time.Duration {
+	// Reserves a login attempt for the IP, or returns how long it must wait if it's throttled
+
+	return t.claim(t.ips, t.IP, ip, now)
+}
+
+func (t *LoginThrottle) refundIP(ip string) {
+	// Gives back an attempt reserved for the IP that turned out not to be a failure
+
+	t.refund(t.ips, ip)
+}
+
+func (t *LoginThrottle) claimUnknown(email string, now time.Time) time.Duration {
+	// Reserves a login attempt for an email with no account, so it's throttled exactly like a real account
+
+	return t.claim(t.unknown, t.Account, strings.ToLower(email), now)
+}
+
+func (t *LoginThrottle) claim(counts map[string]failureCount, policy BackoffPolicy, key string, now time.Time) time.Duration {
+	// Counts an attempt for key before it's made, unless key must wait under policy; checking and counting
+	// under one lock means parallel attempts can't all slip under the limit
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f := counts[key]
+	if now.Sub(f.last) > policy.Window {
+		f = failureCount{}
+	}
+	if f.count > 0 {
+		if wait := policy.blockedUntil(f.count, f.last).Sub(now); wait > 0 {
+			return wait
+		}
+	}
+	counts[key] = failureCount{count: f.count + 1, last: now}
+	return 0
+}
+
+func (t *LoginThrottle) refund(counts map[string]failureCount, key string) {
+	// Takes back one claimed attempt for key
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, ok := counts[key]
+	if !ok {
+		return
+	}
+	if f.count <= 1 {
+		delete(counts, key)
+		return
+	}
+	f.count--
+	counts[key] = f
+}
+
+func (t *LoginThrottle) Prune(now time.Time) {
+	// Forgets failures that have fallen outside their policy's window
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for k, f := range t.ips {
+		if now.Sub(f.last) > t.IP.Window {
+			delete(t.ips, k)
+		}
+	}
+	for k, f := range t.unknown {
+		if now.Sub(f.last) > t.Account.Window {
+			delete(t.unknown, k)
+		}
+	}
+}
+
+func (t *LoginThrottle) accountRetryAfter(user database.User, now time.Time) time.Duration {
+	// Returns how long the account must wait before trying again, zero if it may try now
+
+	if !user.LastFailedLoginAt.Valid || now.Sub(user.LastFailedLoginAt.Time) > t.Account.Window {
+		return 0
+	}
+	until := t.Account.blockedUntil(int(user.FailedLogins), user.LastFailedLoginAt.Time)
+	return max(until.Sub(now), 0)
+}
+
+func (cfg *ApiConfig) loginThrottle() *LoginThrottle {
+	// Returns the configured throttle, creating one with the default policies if none was set
+
+	cfg.throttleOnce.Do(func() {
+		if cfg.LoginThrottle == nil {
+			cfg.LoginThrottle = NewLoginThrottle(DefaultAccountBackoff, DefaultIPBackoff)
+		}
+	})
+	return cfg.LoginThrottle
+}
+
+// How many times an account's attempt is re-read and claimed again after losing a race with another attempt
+const loginClaimRetries = 3
+
+func (cfg *ApiConfig) claimAccountAttempt(ctx context.Context, user database.User, now time.Time) (int32, time.Duration, error) {
+	// Reserves a login attempt against the account before its password is checked, returning its failure
+	// count including this attempt or how long it must wait. The claim only applies if no other attempt was
+	// counted since user was read, so a burst of parallel guesses can't all get past the limit
+
+	throttle := cfg.loginThrottle()
+	for range loginClaimRetries {
+		if wait := throttle.accountRetryAfter(user, now); wait > 0 {
+			return 0, wait, nil
+		}
+		failures, err := cfg.DBConn.ClaimLoginAttempt(ctx, database.ClaimLoginAttemptParams{
+			WindowStart:  now.Add(-throttle.Account.Window),
+			FailedAt:     now,
+			ID:           user.ID,
+			SeenFailures: user.FailedLogins,
+			SeenFailedAt: user.LastFailedLoginAt,
+		})
+		if err == nil {
+			return failures, 0, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, 0, err
+		}
+
+		// Another attempt was counted first, so checks again against the new count
+		user, err = cfg.DBConn.GetUserByID(ctx, user.ID)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	// Losing every time means a burst is under way, which waits like any other throttled client
+	return 0, max(throttle.Account.BaseDelay, time.Second), nil
+}
+
+func (cfg *ApiConfig) RunThrottlePruner(ctx context.Context, interval time.Duration) {
+	// Forgets stale in-memory login failures every interval, until ctx is cancelled
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			cfg.loginThrottle().Prune(now.UTC())
+		}
+	}
+}
+
+func writeRetryAfter(writer http.ResponseWriter, wait time.Duration) {
+	// Writes a 429 telling the client how many whole seconds to wait
+
+	seconds := int(math.Ceil(wait.Seconds()))
+	writer.Header().Set("Retry-After", fmt.Sprint(seconds))
+	http.Error(writer, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+}
+
+func (cfg *ApiConfig) POSTUnlockUser(writer http.ResponseWriter, req *http.Request) {
+	// Handles POST requests at admin/users/{userID}/unlock, clears an account's failed login count
+
+	UID, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		http.Error(writer, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	updated, err := cfg.DBConn.ResetFailedLogins(req.Context(), UID)
+	if err != nil {
+		http.Error(writer, "Failed to unlock user", http.StatusInternalServerError)
+		return
+	}
+	if updated == 0 {
+		http.Error(writer, "User not found", http.StatusNotFound)
+		return
+	}
+	cfg.logSecurityEvent(req.Context(), UID, "account_unlocked", clientIP(req))
+
+	writer.WriteHeader(204)
+}
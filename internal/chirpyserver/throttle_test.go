@@ -0,0 +1,162 @@
+package chirpyserver
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
+	"github.com/roxensox/chirpy/internal/database"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	policy := BackoffPolicy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		LockoutAttempts: 10,
+		LockoutDuration: 15 * time.Minute,
+		Window:          time.Hour,
+	}
+
+	test_cases := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{failures: 0, expected: 0},
+		{failures: 2, expected: 0},
+		{failures: 3, expected: time.Second},
+		{failures: 4, expected: 2 * time.Second},
+		{failures: 6, expected: 8 * time.Second},
+		{failures: 9, expected: time.Minute},
+		{failures: 10, expected: 15 * time.Minute},
+		{failures: 1000, expected: 15 * time.Minute},
+	}
+
+	for _, tc := range test_cases {
+		if got := policy.Delay(tc.failures); got != tc.expected {
+			t.Errorf("Delay(%d) = %v, want %v", tc.failures, got, tc.expected)
+		}
+	}
+}
+
+func TestIPThrottle(t *testing.T) {
+	throttle := NewLoginThrottle(DefaultAccountBackoff, BackoffPolicy{
+		FreeAttempts: 2,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute,
+		Window:       time.Hour,
+	})
+	now := time.Now()
+
+	// Free attempts are claimed without waiting, the next one has to wait
+	for i := range 2 {
+		if wait := throttle.claimIP("10.0.0.1", now); wait != 0 {
+			t.Errorf("Attempt %d shouldn't be throttled, got %v", i+1, wait)
+		}
+	}
+	if wait := throttle.claimIP("10.0.0.1", now); wait != time.Second {
+		t.Errorf("Expected a one second wait, got %v", wait)
+	}
+	if wait := throttle.claimIP("10.0.0.2", now); wait != 0 {
+		t.Errorf("Other IPs shouldn't be throttled, got %v", wait)
+	}
+
+	// A refunded attempt no longer counts
+	throttle.refundIP("10.0.0.1")
+	if wait := throttle.claimIP("10.0.0.1", now); wait != 0 {
+		t.Errorf("A refunded attempt shouldn't count, got %v", wait)
+	}
+	if wait := throttle.claimIP("10.0.0.1", now.Add(2*time.Hour)); wait != 0 {
+		t.Errorf("Failures outside the window should be forgotten, got %v", wait)
+	}
+
+	// Pruning drops entries outside the window and keeps the rest
+	throttle.Prune(now.Add(30 * time.Minute))
+	if _, ok := throttle.ips["10.0.0.2"]; !ok {
+		t.Errorf("Expected a recent failure to be kept")
+	}
+	throttle.Prune(now.Add(4 * time.Hour))
+	if len(throttle.ips) != 0 {
+		t.Errorf("Expected stale failures to be pruned, %d left", len(throttle.ips))
+	}
+}
+
+func TestLoginClaimsAttemptsFirst(t *testing.T) {
+	hash, err := auth.HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+
+	// Keeps the account's failure count, claiming attempts only if nothing changed since the caller read it
+	var mu sync.Mutex
+	user := database.User{ID: uuid.New(), Email: "user@example.com", HashedPassword: hash, Role: auth.RoleUser}
+	current := func([]driver.NamedValue) (fakeResult, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return userResult(user), nil
+	}
+	_, conn := newFakeDB(map[string]fakeQuery{
+		"GetUserByEmail":    current,
+		"GetUserByID":       current,
+		"ClaimLoginAttempt": claimLoginAttempt(&mu, &user),
+		"AddSecurityEvent":  returns(fakeResult{affected: 1}),
+	})
+	policy := BackoffPolicy{FreeAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+	cfg := &ApiConfig{DBConn: conn, LoginThrottle: NewLoginThrottle(policy, DefaultIPBackoff)}
+
+	// A burst of wrong passwords at once only gets the free attempts checked, the rest are turned away
+	var wg sync.WaitGroup
+	var rejected, throttled int
+	for i := range 12 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"email": "user@example.com", "password": "wrong"}`))
+			req.RemoteAddr = fmt.Sprintf("10.0.0.%d:1234", i)
+			rec := httptest.NewRecorder()
+			cfg.POSTLogin(rec, req)
+			mu.Lock()
+			defer mu.Unlock()
+			switch rec.Code {
+			case 401:
+				rejected++
+			case 429:
+				throttled++
+			default:
+				t.Errorf("Unexpected status %d", rec.Code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if rejected != policy.FreeAttempts || int(user.FailedLogins) != policy.FreeAttempts {
+		t.Errorf("Expected %d passwords to be checked and counted, got %d checked and %d counted", policy.FreeAttempts, rejected, user.FailedLogins)
+	}
+	if throttled != 12-policy.FreeAttempts {
+		t.Errorf("Expected the rest to be throttled, got %d", throttled)
+	}
+}
+
+func claimLoginAttempt(mu *sync.Mutex, user *database.User) fakeQuery {
+	// Answers ClaimLoginAttempt for user, counting the attempt only if nothing changed since the caller read it
+
+	return func(args []driver.NamedValue) (fakeResult, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		res := fakeResult{columns: []string{"failed_logins"}}
+		seenAt, _ := args[4].Value.(time.Time)
+		if args[3].Value.(int64) != int64(user.FailedLogins) || !seenAt.Equal(user.LastFailedLoginAt.Time) {
+			return res, nil
+		}
+		user.FailedLogins++
+		user.LastFailedLoginAt = sql.NullTime{Time: args[1].Value.(time.Time), Valid: true}
+		res.rows = [][]driver.Value{{int64(user.FailedLogins)}}
+		return res, nil
+	}
+}
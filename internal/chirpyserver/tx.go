@@ -0,0 +1,22 @@
+package chirpyserver
+
+import (
+	"context"
+	"github.com/roxensox/chirpy/internal/database"
+)
+
+func (cfg *ApiConfig) inTx(ctx context.Context, fn func(q *database.Queries) error) error {
+	// Runs fn with queries bound to a single transaction, committing if it returns nil and rolling back otherwise
+
+	tx, err := cfg.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// A no-op once the transaction has committed
+	defer tx.Rollback()
+
+	if err := fn(cfg.DBConn.WithTx(tx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
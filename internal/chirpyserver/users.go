@@ -1,16 +1,24 @@
 package chirpyserver
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/roxensox/chirpy/internal/auth"
 	"github.com/roxensox/chirpy/internal/database"
+	"github.com/roxensox/chirpy/internal/mail"
+	"log"
 	"net/http"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 func (cfg *ApiConfig) POSTUsers(writer http.ResponseWriter, req *http.Request) {
-	// Handles POST request to users endpoint, returns newly created user
+	// Handles POST request to users endpoint, creates the user and sends a verification email
 
 	// Creates anonymous struct instance for receiving input and umarshals into it
 	in := struct {
@@ -19,10 +27,16 @@ func (cfg *ApiConfig) POSTUsers(writer http.ResponseWriter, req *http.Request) {
 	}{}
 	decoder := json.NewDecoder(req.Body)
 	decoder.Decode(&in)
+	if !validEmail(in.Email) {
+		writer.WriteHeader(400)
+		writer.Write([]byte("Invalid email address"))
+		return
+	}
 	hash, err := auth.HashPassword(in.Password)
 	if err != nil {
 		writer.WriteHeader(500)
 		writer.Write([]byte("Failed to hash password"))
+		return
 	}
 
 	// Builds query param object
@@ -36,19 +50,46 @@ func (cfg *ApiConfig) POSTUsers(writer http.ResponseWriter, req *http.Request) {
 
 	// Queries database to insert new data
 	dbResp, err := cfg.DBConn.CreateUser(req.Context(), params)
-	if err != nil {
+	var pqErr *pq.Error
+	duplicate := errors.As(err, &pqErr) && pqErr.Code == "23505"
+	if err != nil && !duplicate {
 		writer.WriteHeader(500)
-		writer.Write([]byte("Failed to find user"))
+		writer.Write([]byte("Failed to create user"))
 		return
 	}
 
-	// Builds new User object to umarshal to JSON
+	// Emails go out in the background and the response doesn't depend on whether the address was
+	// already taken, so signing up can't be used to find accounts; the owner of a taken address is told instead
+	if duplicate {
+		cfg.inBackground(req.Context(), "duplicate signup email", func(ctx context.Context) error {
+			return cfg.mailer().Send(ctx, mail.Message{
+				To:      in.Email,
+				Subject: "Someone tried to sign up with your email address",
+				Body: "Someone tried to create a Chirpy account with this email address, which already has one.\n\n" +
+					"If this was you, log in or reset your password instead. Otherwise you can ignore this email.\n",
+			})
+		})
+	} else {
+		cfg.inBackground(req.Context(), "verification email", func(ctx context.Context) error {
+			return cfg.sendVerificationEmail(ctx, dbResp.ID, dbResp.Email)
+		})
+	}
+
+	// Builds new User object to umarshal to JSON; for a taken address it describes the account that
+	// would have been made, so the response has the same shape either way
 	jsonResp := User{
-		Email:       dbResp.Email,
-		CreatedAt:   dbResp.CreatedAt,
-		UpdatedAt:   dbResp.UpdatedAt,
-		ID:          dbResp.ID,
-		IsChirpyRed: dbResp.IsChirpyRed,
+		Email:     params.Email,
+		CreatedAt: params.CreatedAt,
+		UpdatedAt: params.UpdatedAt,
+		ID:        params.ID,
+		Role:      auth.RoleUser,
+	}
+	if !duplicate {
+		jsonResp.Email = dbResp.Email
+		jsonResp.CreatedAt = dbResp.CreatedAt
+		jsonResp.UpdatedAt = dbResp.UpdatedAt
+		jsonResp.ID = dbResp.ID
+		jsonResp.IsChirpyRed = dbResp.IsChirpyRed
 	}
 
 	// Umarshals User object
@@ -60,6 +101,7 @@ func (cfg *ApiConfig) POSTUsers(writer http.ResponseWriter, req *http.Request) {
 	}
 
 	// Writes success response
+	writer.Header().Set("Content-Type", "application/json")
 	writer.WriteHeader(201)
 	writer.Write(resp)
 }
@@ -67,19 +109,9 @@ func (cfg *ApiConfig) POSTUsers(writer http.ResponseWriter, req *http.Request) {
 func (cfg *ApiConfig) PUTUsers(writer http.ResponseWriter, req *http.Request) {
 	// Handles PUT requests at users endpoint, takes in new email and password
 
-	// Gets access token from request header
-	tkn, err := auth.GetBearerToken(req.Header)
-	if err != nil {
-		writer.WriteHeader(401)
-		writer.Write([]byte("Could not find token"))
-		return
-	}
-
-	// Gets the user ID by validating access token
-	UID, err := auth.ValidateJWT(tkn, cfg.Secret)
-	if err != nil {
-		writer.WriteHeader(401)
-		writer.Write([]byte("Invalid token"))
+	// Gets the caller's ID; RequireAuth has already checked their token's scope
+	UID, ok := currentUser(writer, req)
+	if !ok {
 		return
 	}
 
@@ -87,11 +119,41 @@ func (cfg *ApiConfig) PUTUsers(writer http.ResponseWriter, req *http.Request) {
 	rcv := struct {
 		Password string `json:"password"`
 		Email    string `json:"email"`
+		Username string `json:"username"`
 	}{}
 
 	// Decodes request body into object
 	decoder := json.NewDecoder(req.Body)
 	decoder.Decode(&rcv)
+	if !validEmail(rcv.Email) {
+		writer.WriteHeader(400)
+		writer.Write([]byte("Invalid email address"))
+		return
+	}
+
+	// Checks the username before anything is written so a bad one can't leave a half-applied update
+	username := ""
+	if rcv.Username != "" {
+		var ok bool
+		username, ok = normalizeUsername(rcv.Username)
+		if !ok {
+			writer.WriteHeader(400)
+			writer.Write([]byte("Usernames must be 1-30 letters, digits or underscores"))
+			return
+		}
+	}
+
+	// Gets the current record so an email change can be detected
+	before, err := cfg.DBConn.GetUserByID(req.Context(), UID)
+	if err != nil {
+		writer.WriteHeader(404)
+		writer.Write([]byte("User not found"))
+		return
+	}
+
+	// Resubmitting the current email and password only touches the username, so it leaves tokens alone
+	samePassword, err := auth.CheckPasswordHash(rcv.Password, before.HashedPassword)
+	credentialsChanged := err != nil || !samePassword || rcv.Email != before.Email
 
 	// Hashes the new password
 	hashed, err := auth.HashPassword(rcv.Password)
@@ -109,21 +171,83 @@ func (cfg *ApiConfig) PUTUsers(writer http.ResponseWriter, req *http.Request) {
 		ID:             UID,
 	}
 
-	// Runs the query
-	resp, err := cfg.DBConn.UpdateUser(req.Context(), params)
+	// Runs the update, which also bumps the token version since the password changed and clears verification if
+	// the email changed, together with the username change so a taken username rolls back the whole request
+	var resp database.UpdateUserRow
+	var newUsername sql.NullString
+	usernameTaken := false
+	err = cfg.inTx(req.Context(), func(q *database.Queries) error {
+		var err error
+		resp, err = q.UpdateUser(req.Context(), params)
+		if err != nil {
+			return err
+		}
+
+		// Personal access tokens aren't tied to the token version, so they're revoked outright when a credential changed
+		if credentialsChanged {
+			err = q.RevokeAllUserPersonalAccessTokens(req.Context(), database.RevokeAllUserPersonalAccessTokensParams{
+				UserID:    UID,
+				RevokedAt: sql.NullTime{Time: params.UpdatedAt, Valid: true},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if username == "" {
+			return nil
+		}
+
+		newUsername, err = q.SetUsername(req.Context(), database.SetUsernameParams{
+			Username:  sql.NullString{String: username, Valid: true},
+			UpdatedAt: time.Now().UTC(),
+			ID:        UID,
+		})
+		usernameTaken = err != nil
+		return err
+	})
+	if usernameTaken {
+		writer.WriteHeader(409)
+		writer.Write([]byte("Username is already taken"))
+		return
+	}
 	if err != nil {
 		writer.WriteHeader(500)
 		writer.Write([]byte("Failed to update user"))
 		return
 	}
+	if cfg.Revocations != nil {
+		cfg.Revocations.SetVersion(UID, resp.TokenVersion)
+	}
+
+	// A new address has to be verified again
+	if resp.Email != before.Email {
+		if err := cfg.sendVerificationEmail(req.Context(), UID, resp.Email); err != nil {
+			log.Printf("Failed to send verification email: %v", err)
+		}
+	}
+
+	// Access tokens issued before the change no longer work, so the caller gets a fresh one
+	newTkn, err := auth.MakeJWT(UID, resp.TokenVersion, cfg.Keys, accessTokenLifetime)
+	if err != nil {
+		writer.WriteHeader(500)
+		writer.Write([]byte("Failed to create access token"))
+		return
+	}
 
 	// Transfers query response to JSON-able object
 	userObj := User{
-		Email:       resp.Email,
-		ID:          resp.ID,
-		UpdatedAt:   resp.UpdatedAt,
-		CreatedAt:   resp.CreatedAt,
-		IsChirpyRed: resp.IsChirpyRed,
+		Email:         resp.Email,
+		ID:            resp.ID,
+		UpdatedAt:     resp.UpdatedAt,
+		CreatedAt:     resp.CreatedAt,
+		Token:         newTkn,
+		IsChirpyRed:   resp.IsChirpyRed,
+		EmailVerified: resp.EmailVerifiedAt.Valid,
+		Role:          resp.Role,
+		Username:      resp.Username.String,
+	}
+	if newUsername.Valid {
+		userObj.Username = newUsername.String
 	}
 
 	// Marshals object to JSON
@@ -138,3 +262,85 @@ func (cfg *ApiConfig) PUTUsers(writer http.ResponseWriter, req *http.Request) {
 	writer.WriteHeader(200)
 	writer.Write(userJson)
 }
+
+func (cfg *ApiConfig) PUTUserProfile(writer http.ResponseWriter, req *http.Request) {
+	// Handles PUT requests at users/profile, changes the caller's username without touching their credentials
+
+	// Gets the caller's ID; RequireAuth has already checked their token's scope
+	UID, ok := currentUser(writer, req)
+	if !ok {
+		return
+	}
+
+	rcv := struct {
+		Username string `json:"username"`
+	}{}
+	decoder := json.NewDecoder(req.Body)
+	decoder.Decode(&rcv)
+	username, ok := normalizeUsername(rcv.Username)
+	if !ok {
+		writer.WriteHeader(400)
+		writer.Write([]byte("Usernames must be 1-30 letters, digits or underscores"))
+		return
+	}
+
+	user, err := cfg.DBConn.GetUserByID(req.Context(), UID)
+	if err != nil {
+		writer.WriteHeader(404)
+		writer.Write([]byte("User not found"))
+		return
+	}
+
+	// Only a clash with another user's username is reported as taken
+	newUsername, err := cfg.DBConn.SetUsername(req.Context(), database.SetUsernameParams{
+		Username:  sql.NullString{String: username, Valid: true},
+		UpdatedAt: time.Now().UTC(),
+		ID:        UID,
+	})
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		writer.WriteHeader(409)
+		writer.Write([]byte("Username is already taken"))
+		return
+	}
+	if err != nil {
+		writer.WriteHeader(500)
+		writer.Write([]byte("Failed to update username"))
+		return
+	}
+
+	userJson, err := json.Marshal(User{
+		Email:         user.Email,
+		ID:            user.ID,
+		UpdatedAt:     user.UpdatedAt,
+		CreatedAt:     user.CreatedAt,
+		IsChirpyRed:   user.IsChirpyRed,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+		Role:          user.Role,
+		Username:      newUsername.String,
+	})
+	if err != nil {
+		writer.WriteHeader(500)
+		writer.Write([]byte("Failed to marshal output"))
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(200)
+	writer.Write(userJson)
+}
+
+func normalizeUsername(s string) (string, bool) {
+	// Lowercases a requested username and checks it only uses characters extractEntities matches
+
+	username := strings.ToLower(strings.TrimPrefix(s, "@"))
+	if len(username) == 0 || utf8.RuneCountInString(username) > 30 {
+		return "", false
+	}
+	for _, r := range username {
+		if !isEntityRune(r) {
+			return "", false
+		}
+	}
+	return username, true
+}
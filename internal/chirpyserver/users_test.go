@@ -0,0 +1,130 @@
+package chirpyserver
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/roxensox/chirpy/internal/auth"
+	"github.com/roxensox/chirpy/internal/database"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPUTUsers(t *testing.T) {
+	keys := auth.NewKeySet()
+	keys.Add(auth.NewHMACKey("default", "secret"))
+	keys.SetSigningKey("default")
+
+	userID := uuid.New()
+	now := time.Now().UTC()
+	current, _ := auth.HashPassword("hunter22")
+	var updated bool
+	var patRevocations int
+	fake, conn := newFakeDB(map[string]fakeQuery{
+		"GetUserByID": returns(userResult(database.User{ID: userID, Email: "old@example.com", HashedPassword: current, Role: auth.RoleUser})),
+		"UpdateUser": func(args []driver.NamedValue) (fakeResult, error) {
+			updated = true
+			return fakeResult{
+				columns: []string{"id", "email", "created_at", "updated_at", "is_chirpy_red", "username", "token_version", "email_verified_at", "role"},
+				rows:    [][]driver.Value{{userID.String(), args[0].Value, now, now, false, "existing", int64(1), nil, auth.RoleUser}},
+			}, nil
+		},
+		"RevokeAllUserPersonalAccessTokens": func(args []driver.NamedValue) (fakeResult, error) {
+			patRevocations++
+			return fakeResult{affected: 1}, nil
+		},
+		"CreateEmailVerificationToken": returns(fakeResult{affected: 1}),
+		"SetUsername":                  fails(errors.New("duplicate key value violates unique constraint")),
+	})
+	cfg := &ApiConfig{DBConn: conn, DB: fake.sqlDB, Keys: keys, Mailer: &recordingSender{}}
+
+	put := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/api/users", strings.NewReader(body))
+		req = req.WithContext(WithPrincipal(context.Background(), Principal{UserID: userID, Scopes: auth.AllScopes}))
+		rec := httptest.NewRecorder()
+		cfg.PUTUsers(rec, req)
+		return rec
+	}
+
+	// An invalid username is refused before anything is written
+	if code := put(`{"email": "new@example.com", "password": "hunter22", "username": "not valid!"}`).Code; code != 400 {
+		t.Errorf("Expected 400 for an invalid username, got %d", code)
+	}
+	if updated {
+		t.Errorf("Expected no update for an invalid username")
+	}
+
+	// Resubmitting the current email and password leaves personal access tokens alone
+	if code := put(`{"email": "old@example.com", "password": "hunter22"}`).Code; code != 200 {
+		t.Errorf("Expected 200 resubmitting the current credentials, got %d", code)
+	}
+	if patRevocations != 0 {
+		t.Errorf("Expected personal access tokens to survive an update that changes no credentials")
+	}
+
+	// Changing the email revokes personal access tokens, which don't follow the token version
+	rec := put(`{"email": "new@example.com", "password": "hunter22"}`)
+	if rec.Code != 200 {
+		t.Errorf("Expected 200 updating the user, got %d", rec.Code)
+	}
+
+	// Leaving the username out keeps the current one in the response
+	var got User
+	json.NewDecoder(rec.Body).Decode(&got)
+	if got.Username != "existing" {
+		t.Errorf("Expected the existing username in the response, got %q", got.Username)
+	}
+	if patRevocations != 1 {
+		t.Errorf("Expected the user's personal access tokens to be revoked")
+	}
+
+	// A taken username rolls back the email and password change made alongside it
+	if code := put(`{"email": "new@example.com", "password": "hunter22", "username": "taken"}`).Code; code != 409 {
+		t.Errorf("Expected 409 for a taken username, got %d", code)
+	}
+	if commits, rollbacks := fake.txCounts(); commits != 2 || rollbacks != 1 {
+		t.Errorf("Expected only the update with a taken username to roll back, got %d commits and %d rollbacks", commits, rollbacks)
+	}
+}
+
+func TestPUTUserProfile(t *testing.T) {
+	userID := uuid.New()
+	fake := map[string]fakeQuery{
+		"GetUserByID": returns(userResult(database.User{ID: userID, Email: "user@example.com", Role: auth.RoleUser})),
+		"SetUsername": func(args []driver.NamedValue) (fakeResult, error) {
+			if args[0].Value == "taken" {
+				return fakeResult{}, &pq.Error{Code: "23505"}
+			}
+			return fakeResult{columns: []string{"username"}, rows: [][]driver.Value{{args[0].Value}}}, nil
+		},
+	}
+	_, conn := newFakeDB(fake)
+	cfg := &ApiConfig{DBConn: conn}
+
+	put := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/api/users/profile", strings.NewReader(body))
+		req = req.WithContext(WithPrincipal(context.Background(), Principal{UserID: userID, Scopes: []string{auth.ScopeProfileWrite}}))
+		rec := httptest.NewRecorder()
+		cfg.PUTUserProfile(rec, req)
+		return rec
+	}
+
+	// A principal with only profile:write can change the username, which comes back normalized
+	rec := put(`{"username": "@New_Name"}`)
+	var got User
+	json.NewDecoder(rec.Body).Decode(&got)
+	if rec.Code != 200 || got.Username != "new_name" {
+		t.Errorf("Expected 200 and the normalized username, got %d and %q", rec.Code, got.Username)
+	}
+	if code := put(`{"username": "not valid!"}`).Code; code != 400 {
+		t.Errorf("Expected 400 for an invalid username, got %d", code)
+	}
+	if code := put(`{"username": "taken"}`).Code; code != 409 {
+		t.Errorf("Expected 409 for a taken username, got %d", code)
+	}
+}
@@ -0,0 +1,95 @@
+package chirpyserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/moderation"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultChirpMaxLength    = 140
+	defaultRedChirpMaxLength = 280
+)
+
+type chirpValidationError struct {
+	Code    string
+	Message string
+}
+
+func (e *chirpValidationError) Error() string {
+	return e.Message
+}
+
+func validateChirpBody(body string, maxLength int, filter *moderation.Filter) (string, error) {
+	// Checks a chirp body against the posting rules and returns it with flagged words censored
+	// Length is counted in runes so multi-byte characters count once
+
+	if strings.TrimSpace(body) == "" {
+		return "", &chirpValidationError{
+			Code:    "empty",
+			Message: "chirp is empty",
+		}
+	}
+
+	if length := utf8.RuneCountInString(body); length > maxLength {
+		return "", &chirpValidationError{
+			Code:    "too_long",
+			Message: fmt.Sprintf("chirp is too long (%d > %d characters)", length, maxLength),
+		}
+	}
+
+	if filter == nil {
+		return body, nil
+	}
+	return filter.Censor(body), nil
+}
+
+func (cfg *ApiConfig) chirpMaxLength(ctx context.Context, userID uuid.UUID) int {
+	// Returns the chirp length limit for a user, Chirpy Red members get the higher limit
+	// An anonymous caller (uuid.Nil) gets the standard limit
+
+	limit := cfg.ChirpMaxLength
+	if limit <= 0 {
+		limit = defaultChirpMaxLength
+	}
+	if userID == uuid.Nil {
+		return limit
+	}
+
+	user, err := cfg.DBConn.GetUserByID(ctx, userID)
+	if err != nil || !user.IsChirpyRed {
+		return limit
+	}
+
+	if cfg.RedChirpMaxLength <= 0 {
+		return max(limit, defaultRedChirpMaxLength)
+	}
+	return cfg.RedChirpMaxLength
+}
+
+func writeValidationError(writer http.ResponseWriter, err error) {
+	// Writes a 400 response describing why a chirp was rejected
+
+	out := ValidateResponse{
+		Valid: false,
+		Error: err.Error(),
+		Code:  "invalid",
+	}
+	if vErr, ok := err.(*chirpValidationError); ok {
+		out.Code = vErr.Code
+	}
+
+	resp, err := json.Marshal(out)
+	if err != nil {
+		resp = []byte("{\"error\":\"something went wrong\"}")
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(400)
+	writer.Write(resp)
+}
@@ -0,0 +1,88 @@
+package chirpyserver
+
+import (
+	"context"
+	"github.com/roxensox/chirpy/internal/moderation"
+	"strings"
+	"testing"
+)
+
+func TestValidateChirpBody(t *testing.T) {
+	filter := moderation.NewFilter(moderation.StaticSource{"kerfuffle"})
+	if _, err := filter.Reload(context.Background()); err != nil {
+		t.Fatalf("Failed to load words: %v", err)
+	}
+
+	test_cases := []struct {
+		body      string
+		maxLength int
+		expected  string
+		errCode   string
+	}{
+		{
+			body:      "hello chirpy",
+			maxLength: 140,
+			expected:  "hello chirpy",
+		},
+		{
+			body:      "   ",
+			maxLength: 140,
+			errCode:   "empty",
+		},
+		{
+			body:      strings.Repeat("a", 141),
+			maxLength: 140,
+			errCode:   "too_long",
+		},
+		// 140 multi-byte runes is still 140 characters
+		{
+			body:      strings.Repeat("é", 140),
+			maxLength: 140,
+			expected:  strings.Repeat("é", 140),
+		},
+		{
+			body:      strings.Repeat("a", 200),
+			maxLength: 280,
+			expected:  strings.Repeat("a", 200),
+		},
+		{
+			body:      "what a kerfuffle",
+			maxLength: 140,
+			expected:  "what a ****",
+		},
+	}
+
+	for i, tc := range test_cases {
+		out, err := validateChirpBody(tc.body, tc.maxLength, filter)
+		if tc.errCode != "" {
+			vErr, ok := err.(*chirpValidationError)
+			if !ok || vErr.Code != tc.errCode {
+				t.Errorf("Case #%d: expected error code %s, got %v", i, tc.errCode, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Case #%d: unexpected error %v", i, err)
+			continue
+		}
+		if out != tc.expected {
+			t.Errorf("Case #%d: expected %q, got %q", i, tc.expected, out)
+		}
+	}
+}
+
+func TestValidEmail(t *testing.T) {
+	cases := map[string]bool{
+		"pippin@example.com":               true,
+		"luna.lovegood@hogwarts.edu":       true,
+		"":                                 false,
+		"not an email":                     false,
+		"Pippin <pippin@example.com>":      false,
+		"pippin@example.com\r\nBcc: x@y.z": false,
+	}
+	for in, want := range cases {
+		if got := validEmail(in); got != want {
+			t.Errorf("validEmail(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
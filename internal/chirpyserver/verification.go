@@ -0,0 +1,136 @@
+package chirpyserver
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
+	"github.com/roxensox/chirpy/internal/database"
+	"github.com/roxensox/chirpy/internal/mail"
+	"log"
+	"net/http"
+	netmail "net/mail"
+	"time"
+)
+
+const emailVerificationTTL = 48 * time.Hour
+
+func validEmail(s string) bool {
+	// Reports whether s is a bare email address, without a display name or angle brackets
+
+	addr, err := netmail.ParseAddress(s)
+	return err == nil && addr.Address == s
+}
+
+func (cfg *ApiConfig) sendVerificationEmail(ctx context.Context, userID uuid.UUID, email string) error {
+	// Emails a one-time token that proves the user controls the address
+
+	tkn, err := auth.MakeEmailVerificationToken()
+	if err != nil {
+		return err
+	}
+
+	// The token is tied to the address it was sent to, so it can't verify a later change
+	now := time.Now().UTC()
+	err = cfg.DBConn.CreateEmailVerificationToken(ctx, database.CreateEmailVerificationTokenParams{
+		TokenHash: auth.HashToken(tkn),
+		UserID:    userID,
+		Email:     email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(emailVerificationTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	return cfg.mailer().Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf(
+			"Welcome to Chirpy! Confirm this is your email address with the code below.\n\n"+
+				"Your verification code is: %s\n\n"+
+				"It expires in %s.\n",
+			tkn, emailVerificationTTL,
+		),
+	})
+}
+
+func (cfg *ApiConfig) POSTVerifyEmail(writer http.ResponseWriter, req *http.Request) {
+	// Handles POST requests at users/verify, marks the address a verification token was sent to as verified
+
+	inObj := struct {
+		Token string `json:"token"`
+	}{}
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&inObj); err != nil || inObj.Token == "" {
+		http.Error(writer, "A verification token is required", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UTC()
+	row, err := cfg.DBConn.ConsumeEmailVerificationToken(req.Context(), database.ConsumeEmailVerificationTokenParams{
+		TokenHash: auth.HashToken(inObj.Token),
+		UsedAt:    sql.NullTime{Time: now, Valid: true},
+	})
+	if err != nil {
+		http.Error(writer, "Invalid or expired verification token", http.StatusBadRequest)
+		return
+	}
+
+	// Only verifies the account if it still uses the address the token was sent to
+	updated, err := cfg.DBConn.MarkEmailVerified(req.Context(), database.MarkEmailVerifiedParams{
+		ID:              row.UserID,
+		Email:           row.Email,
+		EmailVerifiedAt: sql.NullTime{Time: now, Valid: true},
+	})
+	if err != nil {
+		http.Error(writer, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+	if updated == 0 {
+		http.Error(writer, "Email address has changed since this token was sent", http.StatusConflict)
+		return
+	}
+
+	writer.WriteHeader(204)
+}
+
+func (cfg *ApiConfig) POSTResendVerification(writer http.ResponseWriter, req *http.Request) {
+	// Handles POST requests at users/verify/resend, sends the caller a fresh verification token
+
+	// Gets the caller's ID; RequireAuth has already checked their token's scope
+	UID, ok := currentUser(writer, req)
+	if !ok {
+		return
+	}
+
+	user, err := cfg.DBConn.GetUserByID(req.Context(), UID)
+	if err != nil {
+		http.Error(writer, "User not found", http.StatusNotFound)
+		return
+	}
+	if user.EmailVerifiedAt.Valid {
+		http.Error(writer, "Email address is already verified", http.StatusConflict)
+		return
+	}
+
+	if err := cfg.sendVerificationEmail(req.Context(), user.ID, user.Email); err != nil {
+		log.Printf("Failed to send verification email: %v", err)
+		http.Error(writer, "Failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+
+	writer.WriteHeader(202)
+}
+
+func (cfg *ApiConfig) emailVerified(ctx context.Context, userID uuid.UUID) (bool, error) {
+	// Reports whether the user has verified their current email address
+
+	user, err := cfg.DBConn.GetUserByID(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return user.EmailVerifiedAt.Valid, nil
+}
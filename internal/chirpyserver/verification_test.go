@@ -0,0 +1,122 @@
+package chirpyserver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/google/uuid"
+	"github.com/roxensox/chirpy/internal/auth"
+	"github.com/roxensox/chirpy/internal/database"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEmailVerification(t *testing.T) {
+	type verificationToken struct {
+		userID string
+		email  string
+		used   bool
+	}
+	user := database.User{ID: uuid.New(), Email: "new@example.com", Role: auth.RoleUser}
+
+	// Keeps one user and their verification tokens, answering the queries the way the SQL does
+	var mu sync.Mutex
+	tokens := map[string]*verificationToken{}
+	fake, conn := newFakeDB(map[string]fakeQuery{
+		"GetUserByID": func([]driver.NamedValue) (fakeResult, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return userResult(user), nil
+		},
+		"CreateEmailVerificationToken": func(args []driver.NamedValue) (fakeResult, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			tokens[args[0].Value.(string)] = &verificationToken{userID: args[1].Value.(string), email: args[2].Value.(string)}
+			return fakeResult{affected: 1}, nil
+		},
+		"ConsumeEmailVerificationToken": func(args []driver.NamedValue) (fakeResult, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			res := fakeResult{columns: []string{"user_id", "email"}}
+			if tkn, ok := tokens[args[0].Value.(string)]; ok && !tkn.used {
+				tkn.used = true
+				res.rows = [][]driver.Value{{tkn.userID, tkn.email}}
+			}
+			return res, nil
+		},
+		"MarkEmailVerified": func(args []driver.NamedValue) (fakeResult, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if args[0].Value.(string) != user.ID.String() || args[1].Value.(string) != user.Email {
+				return fakeResult{}, nil
+			}
+			user.EmailVerifiedAt = sql.NullTime{Time: args[2].Value.(time.Time), Valid: true}
+			return fakeResult{affected: 1}, nil
+		},
+	})
+	sender := &recordingSender{}
+	cfg := &ApiConfig{DBConn: conn, DB: fake.sqlDB, Mailer: sender, RequireVerifiedEmail: true}
+	ctx := WithPrincipal(context.Background(), Principal{UserID: user.ID, Scopes: auth.AllScopes})
+
+	resend := func() (int, string) {
+		rec := httptest.NewRecorder()
+		cfg.POSTResendVerification(rec, httptest.NewRequest("POST", "/api/users/verify/resend", nil).WithContext(ctx))
+		if len(sender.sent) == 0 {
+			return rec.Code, ""
+		}
+		code := regexp.MustCompile(`verification code is: (\S+)`).FindStringSubmatch(sender.sent[len(sender.sent)-1].Body)
+		if code == nil {
+			t.Fatalf("Expected a verification code in the email")
+		}
+		return rec.Code, code[1]
+	}
+	verify := func(token string) int {
+		rec := httptest.NewRecorder()
+		cfg.POSTVerifyEmail(rec, httptest.NewRequest("POST", "/api/users/verify", strings.NewReader(`{"token": "`+token+`"}`)))
+		return rec.Code
+	}
+	post := func() int {
+		rec := httptest.NewRecorder()
+		cfg.POSTChirps(rec, httptest.NewRequest("POST", "/api/chirps", strings.NewReader(`{"body": "hello"}`)).WithContext(ctx))
+		return rec.Code
+	}
+
+	// An unverified account can't post while verification is required
+	if status := post(); status != 403 {
+		t.Errorf("Expected 403 posting before verifying, got %d", status)
+	}
+
+	// A code sent to an address that has since changed doesn't verify the new one
+	_, stale := resend()
+	user.Email = "changed@example.com"
+	if status := verify(stale); status != 409 {
+		t.Errorf("Expected 409 for a code sent to an old address, got %d", status)
+	}
+
+	// A code sent to the current address verifies it once
+	status, code := resend()
+	if status != 202 {
+		t.Fatalf("Expected 202 resending, got %d", status)
+	}
+	if status := verify(code); status != 204 {
+		t.Errorf("Expected 204 verifying, got %d", status)
+	}
+	if !user.EmailVerifiedAt.Valid {
+		t.Errorf("Expected the address to be marked verified")
+	}
+	if status := verify(code); status != 400 {
+		t.Errorf("Expected 400 reusing a code, got %d", status)
+	}
+	if status := verify("made up"); status != 400 {
+		t.Errorf("Expected 400 for an unknown code, got %d", status)
+	}
+
+	// Once verified there's nothing to resend
+	if status, _ := resend(); status != 409 {
+		t.Errorf("Expected 409 resending for a verified address, got %d", status)
+	}
+}
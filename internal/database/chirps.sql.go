@@ -7,6 +7,7 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
@@ -18,14 +19,16 @@ INSERT INTO chirps (
 	created_at,
 	updated_at,
 	body,
-	user_id
+	user_id,
+	in_reply_to
 ) VALUES (
 	$1,
 	$2,
 	$3,
 	$4,
-	$5
-) RETURNING id, created_at, updated_at, body, user_id
+	$5,
+	$6
+) RETURNING id, created_at, updated_at, body, user_id, in_reply_to, tombstoned, like_count, search_vector, deleted_at
 `
 
 type CreateChirpParams struct {
@@ -34,6 +37,7 @@ type CreateChirpParams struct {
 	UpdatedAt time.Time
 	Body      string
 	UserID    uuid.UUID
+	InReplyTo uuid.NullUUID
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
@@ -43,6 +47,7 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		arg.UpdatedAt,
 		arg.Body,
 		arg.UserID,
+		arg.InReplyTo,
 	)
 	var i Chirp
 	err := row.Scan(
@@ -51,23 +56,178 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.InReplyTo,
+		&i.Tombstoned,
+		&i.LikeCount,
+		&i.SearchVector,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
-const deleteChirp = `-- name: DeleteChirp :exec
-DELETE FROM chirps
-WHERE id = $1
+const getChirpAncestors = `-- name: GetChirpAncestors :many
+WITH RECURSIVE ancestors AS (
+	SELECT c.id, c.in_reply_to, 0 AS depth
+	FROM chirps c
+	WHERE c.id = $1
+	UNION ALL
+	SELECT p.id, p.in_reply_to, a.depth + 1
+	FROM chirps p
+	JOIN ancestors a ON p.id = a.in_reply_to
+)
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.in_reply_to, chirps.tombstoned, chirps.like_count, chirps.search_vector, chirps.deleted_at
+FROM chirps
+JOIN ancestors ON chirps.id = ancestors.id
+WHERE ancestors.depth > 0
+ORDER BY ancestors.depth DESC
 `
 
-func (q *Queries) DeleteChirp(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.ExecContext(ctx, deleteChirp, id)
-	return err
+func (q *Queries) GetChirpAncestors(ctx context.Context, id uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpAncestors, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.Tombstoned,
+			&i.LikeCount,
+			&i.SearchVector,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpDescendants = `-- name: GetChirpDescendants :many
+WITH RECURSIVE descendants AS (
+	SELECT c.id
+	FROM chirps c
+	WHERE c.in_reply_to = $1
+	UNION ALL
+	SELECT r.id
+	FROM chirps r
+	JOIN descendants d ON r.in_reply_to = d.id
+)
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.in_reply_to, chirps.tombstoned, chirps.like_count, chirps.search_vector, chirps.deleted_at
+FROM chirps
+JOIN descendants ON chirps.id = descendants.id
+ORDER BY chirps.created_at ASC, chirps.id ASC
+`
+
+func (q *Queries) GetChirpDescendants(ctx context.Context, inReplyTo uuid.NullUUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpDescendants, inReplyTo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.Tombstoned,
+			&i.LikeCount,
+			&i.SearchVector,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpReplies = `-- name: GetChirpReplies :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, tombstoned, like_count, search_vector, deleted_at
+FROM chirps
+WHERE in_reply_to = $1
+AND (
+	$2::timestamp IS NULL
+	OR (created_at, id) > ($2::timestamp, $3::uuid)
+)
+ORDER BY created_at ASC, id ASC
+LIMIT $4
+`
+
+type GetChirpRepliesParams struct {
+	InReplyTo       uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+func (q *Queries) GetChirpReplies(ctx context.Context, arg GetChirpRepliesParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpReplies,
+		arg.InReplyTo,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.Tombstoned,
+			&i.LikeCount,
+			&i.SearchVector,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
 const getChirps = `-- name: GetChirps :many
-SELECT id, created_at, updated_at, body, user_id 
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, tombstoned, like_count, search_vector, deleted_at 
 FROM chirps
+WHERE deleted_at IS NULL
 ORDER BY created_at ASC
 `
 
@@ -86,6 +246,11 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.InReplyTo,
+			&i.Tombstoned,
+			&i.LikeCount,
+			&i.SearchVector,
+			&i.DeletedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -101,9 +266,10 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 }
 
 const getChirpsByAuthor = `-- name: GetChirpsByAuthor :many
-SELECT id, created_at, updated_at, body, user_id
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, tombstoned, like_count, search_vector, deleted_at
 FROM chirps
 WHERE user_id = $1
+AND deleted_at IS NULL
 ORDER BY created_at ASC
 `
 
@@ -122,6 +288,233 @@ func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Ch
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.InReplyTo,
+			&i.Tombstoned,
+			&i.LikeCount,
+			&i.SearchVector,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpsByAuthorPage = `-- name: GetChirpsByAuthorPage :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, tombstoned, like_count, search_vector, deleted_at
+FROM chirps
+WHERE user_id = $1
+AND deleted_at IS NULL
+AND (
+	$2::timestamp IS NULL
+	OR (created_at, id) > ($2::timestamp, $3::uuid)
+)
+ORDER BY created_at ASC, id ASC
+LIMIT $4
+`
+
+type GetChirpsByAuthorPageParams struct {
+	UserID          uuid.UUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+func (q *Queries) GetChirpsByAuthorPage(ctx context.Context, arg GetChirpsByAuthorPageParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsByAuthorPage,
+		arg.UserID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.Tombstoned,
+			&i.LikeCount,
+			&i.SearchVector,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpsByAuthorPageDesc = `-- name: GetChirpsByAuthorPageDesc :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, tombstoned, like_count, search_vector, deleted_at
+FROM chirps
+WHERE user_id = $1
+AND deleted_at IS NULL
+AND (
+	$2::timestamp IS NULL
+	OR (created_at, id) < ($2::timestamp, $3::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT $4
+`
+
+type GetChirpsByAuthorPageDescParams struct {
+	UserID          uuid.UUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+func (q *Queries) GetChirpsByAuthorPageDesc(ctx context.Context, arg GetChirpsByAuthorPageDescParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsByAuthorPageDesc,
+		arg.UserID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.Tombstoned,
+			&i.LikeCount,
+			&i.SearchVector,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpsPage = `-- name: GetChirpsPage :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, tombstoned, like_count, search_vector, deleted_at
+FROM chirps
+WHERE deleted_at IS NULL
+AND (
+	$1::timestamp IS NULL
+	OR (created_at, id) > ($1::timestamp, $2::uuid)
+)
+ORDER BY created_at ASC, id ASC
+LIMIT $3
+`
+
+type GetChirpsPageParams struct {
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+func (q *Queries) GetChirpsPage(ctx context.Context, arg GetChirpsPageParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsPage, arg.CursorCreatedAt, arg.CursorID, arg.PageLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.Tombstoned,
+			&i.LikeCount,
+			&i.SearchVector,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpsPageDesc = `-- name: GetChirpsPageDesc :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, tombstoned, like_count, search_vector, deleted_at
+FROM chirps
+WHERE deleted_at IS NULL
+AND (
+	$1::timestamp IS NULL
+	OR (created_at, id) < ($1::timestamp, $2::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT $3
+`
+
+type GetChirpsPageDescParams struct {
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+func (q *Queries) GetChirpsPageDesc(ctx context.Context, arg GetChirpsPageDescParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsPageDesc, arg.CursorCreatedAt, arg.CursorID, arg.PageLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.Tombstoned,
+			&i.LikeCount,
+			&i.SearchVector,
+			&i.DeletedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -137,9 +530,10 @@ func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Ch
 }
 
 const getExactChirp = `-- name: GetExactChirp :one
-SELECT id, created_at, updated_at, body, user_id
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, tombstoned, like_count, search_vector, deleted_at
 FROM chirps
 WHERE id = $1
+AND deleted_at IS NULL
 `
 
 func (q *Queries) GetExactChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
@@ -151,6 +545,231 @@ func (q *Queries) GetExactChirp(ctx context.Context, id uuid.UUID) (Chirp, error
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.InReplyTo,
+		&i.Tombstoned,
+		&i.LikeCount,
+		&i.SearchVector,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const getExactChirpWithDeleted = `-- name: GetExactChirpWithDeleted :one
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, tombstoned, like_count, search_vector, deleted_at
+FROM chirps
+WHERE id = $1
+`
+
+func (q *Queries) GetExactChirpWithDeleted(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getExactChirpWithDeleted, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.InReplyTo,
+		&i.Tombstoned,
+		&i.LikeCount,
+		&i.SearchVector,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const lockChirp = `-- name: LockChirp :one
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, tombstoned, like_count, search_vector, deleted_at
+FROM chirps
+WHERE id = $1
+AND deleted_at IS NULL
+FOR UPDATE
+`
+
+func (q *Queries) LockChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, lockChirp, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.InReplyTo,
+		&i.Tombstoned,
+		&i.LikeCount,
+		&i.SearchVector,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const purgeExpiredChirps = `-- name: PurgeExpiredChirps :execrows
+DELETE FROM chirps
+WHERE deleted_at < $1
+AND NOT EXISTS (
+	SELECT 1
+	FROM chirps replies
+	WHERE replies.in_reply_to = chirps.id
+)
+`
+
+func (q *Queries) PurgeExpiredChirps(ctx context.Context, deletedAt sql.NullTime) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeExpiredChirps, deletedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const restoreChirp = `-- name: RestoreChirp :one
+UPDATE chirps
+SET
+	deleted_at = NULL,
+	updated_at = $2
+WHERE id = $1
+AND NOT tombstoned
+RETURNING id, created_at, updated_at, body, user_id, in_reply_to, tombstoned, like_count, search_vector, deleted_at
+`
+
+type RestoreChirpParams struct {
+	ID        uuid.UUID
+	UpdatedAt time.Time
+}
+
+func (q *Queries) RestoreChirp(ctx context.Context, arg RestoreChirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, restoreChirp, arg.ID, arg.UpdatedAt)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.InReplyTo,
+		&i.Tombstoned,
+		&i.LikeCount,
+		&i.SearchVector,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const searchChirps = `-- name: SearchChirps :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.in_reply_to, chirps.tombstoned, chirps.like_count, chirps.search_vector, chirps.deleted_at
+FROM chirps, to_tsquery('english', $1) query
+WHERE chirps.search_vector @@ query
+AND chirps.deleted_at IS NULL
+ORDER BY ts_rank(chirps.search_vector, query) DESC, chirps.created_at DESC, chirps.id DESC
+LIMIT $2
+OFFSET $3
+`
+
+type SearchChirpsParams struct {
+	Query      string
+	PageLimit  int32
+	PageOffset int32
+}
+
+func (q *Queries) SearchChirps(ctx context.Context, arg SearchChirpsParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, searchChirps, arg.Query, arg.PageLimit, arg.PageOffset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.Tombstoned,
+			&i.LikeCount,
+			&i.SearchVector,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const softDeleteChirp = `-- name: SoftDeleteChirp :exec
+UPDATE chirps
+SET
+	deleted_at = $2,
+	updated_at = $2
+WHERE id = $1
+`
+
+type SoftDeleteChirpParams struct {
+	ID        uuid.UUID
+	DeletedAt sql.NullTime
+}
+
+func (q *Queries) SoftDeleteChirp(ctx context.Context, arg SoftDeleteChirpParams) error {
+	_, err := q.db.ExecContext(ctx, softDeleteChirp, arg.ID, arg.DeletedAt)
+	return err
+}
+
+const tombstoneExpiredChirps = `-- name: TombstoneExpiredChirps :execrows
+UPDATE chirps
+SET
+	body = '',
+	tombstoned = TRUE
+WHERE deleted_at < $1
+AND NOT tombstoned
+`
+
+func (q *Queries) TombstoneExpiredChirps(ctx context.Context, deletedAt sql.NullTime) (int64, error) {
+	result, err := q.db.ExecContext(ctx, tombstoneExpiredChirps, deletedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateChirpBody = `-- name: UpdateChirpBody :one
+UPDATE chirps
+SET
+	body = $1,
+	updated_at = $2
+WHERE id = $3
+AND deleted_at IS NULL
+RETURNING id, created_at, updated_at, body, user_id, in_reply_to, tombstoned, like_count, search_vector, deleted_at
+`
+
+type UpdateChirpBodyParams struct {
+	Body      string
+	UpdatedAt time.Time
+	ID        uuid.UUID
+}
+
+func (q *Queries) UpdateChirpBody(ctx context.Context, arg UpdateChirpBodyParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, updateChirpBody, arg.Body, arg.UpdatedAt, arg.ID)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.InReplyTo,
+		&i.Tombstoned,
+		&i.LikeCount,
+		&i.SearchVector,
+		&i.DeletedAt,
 	)
 	return i, err
 }
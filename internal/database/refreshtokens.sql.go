@@ -11,84 +11,314 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 const addRefreshToken = `-- name: AddRefreshToken :exec
 INSERT INTO refresh_tokens (
-	token,
+	token_hash,
 	created_at,
 	updated_at,
 	expires_at,
-	user_id
+	user_id,
+	family_id,
+	user_agent,
+	ip_address,
+	last_used_at,
+	client_id,
+	scopes
 ) VALUES (
 	$1,
 	$2,
 	$3,
 	$4,
-	$5
+	$5,
+	$6,
+	$7,
+	$8,
+	$9,
+	$10,
+	$11
 )
 `
 
 type AddRefreshTokenParams struct {
-	Token     string
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	ExpiresAt time.Time
-	UserID    uuid.UUID
+	TokenHash  string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	ExpiresAt  time.Time
+	UserID     uuid.UUID
+	FamilyID   uuid.UUID
+	UserAgent  string
+	IpAddress  string
+	LastUsedAt time.Time
+	ClientID   uuid.NullUUID
+	Scopes     []string
 }
 
 func (q *Queries) AddRefreshToken(ctx context.Context, arg AddRefreshTokenParams) error {
 	_, err := q.db.ExecContext(ctx, addRefreshToken,
-		arg.Token,
+		arg.TokenHash,
 		arg.CreatedAt,
 		arg.UpdatedAt,
 		arg.ExpiresAt,
 		arg.UserID,
+		arg.FamilyID,
+		arg.UserAgent,
+		arg.IpAddress,
+		arg.LastUsedAt,
+		arg.ClientID,
+		pq.Array(arg.Scopes),
 	)
 	return err
 }
 
+const getActiveSessions = `-- name: GetActiveSessions :many
+SELECT
+	family_id,
+	(
+		SELECT MIN(f.created_at)
+		FROM refresh_tokens f
+		WHERE f.family_id = refresh_tokens.family_id
+	)::timestamp AS started_at,
+	last_used_at,
+	expires_at,
+	user_agent,
+	ip_address
+FROM refresh_tokens
+WHERE user_id = $1
+AND expires_at > $2
+AND revoked_at IS NULL
+AND replaced_at IS NULL
+ORDER BY last_used_at DESC
+`
+
+type GetActiveSessionsParams struct {
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+}
+
+type GetActiveSessionsRow struct {
+	FamilyID   uuid.UUID
+	StartedAt  time.Time
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+	UserAgent  string
+	IpAddress  string
+}
+
+func (q *Queries) GetActiveSessions(ctx context.Context, arg GetActiveSessionsParams) ([]GetActiveSessionsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getActiveSessions, arg.UserID, arg.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetActiveSessionsRow
+	for rows.Next() {
+		var i GetActiveSessionsRow
+		if err := rows.Scan(
+			&i.FamilyID,
+			&i.StartedAt,
+			&i.LastUsedAt,
+			&i.ExpiresAt,
+			&i.UserAgent,
+			&i.IpAddress,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRefreshTokenAnyState = `-- name: GetRefreshTokenAnyState :one
+SELECT token_hash, created_at, updated_at, expires_at, revoked_at, user_id, family_id, replaced_at, user_agent, ip_address, last_used_at, client_id, scopes
+FROM refresh_tokens
+WHERE token_hash = $1
+`
+
+func (q *Queries) GetRefreshTokenAnyState(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getRefreshTokenAnyState, tokenHash)
+	var i RefreshToken
+	err := row.Scan(
+		&i.TokenHash,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.UserID,
+		&i.FamilyID,
+		&i.ReplacedAt,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.LastUsedAt,
+		&i.ClientID,
+		pq.Array(&i.Scopes),
+	)
+	return i, err
+}
+
 const getToken = `-- name: GetToken :one
-SELECT token, created_at, updated_at, expires_at, revoked_at, user_id 
+SELECT token_hash, created_at, updated_at, expires_at, revoked_at, user_id, family_id, replaced_at, user_agent, ip_address, last_used_at, client_id, scopes 
 FROM refresh_tokens
-WHERE token = $1
+WHERE token_hash = $1
 AND expires_at > $2
 AND revoked_at IS NULL
+AND replaced_at IS NULL
 `
 
 type GetTokenParams struct {
-	Token     string
+	TokenHash string
 	ExpiresAt time.Time
 }
 
 func (q *Queries) GetToken(ctx context.Context, arg GetTokenParams) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, getToken, arg.Token, arg.ExpiresAt)
+	row := q.db.QueryRowContext(ctx, getToken, arg.TokenHash, arg.ExpiresAt)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
+		&i.TokenHash,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.ExpiresAt,
 		&i.RevokedAt,
 		&i.UserID,
+		&i.FamilyID,
+		&i.ReplacedAt,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.LastUsedAt,
+		&i.ClientID,
+		pq.Array(&i.Scopes),
 	)
 	return i, err
 }
 
+const revokeAllUserTokens = `-- name: RevokeAllUserTokens :exec
+UPDATE refresh_tokens
+SET
+	revoked_at = $2,
+	updated_at = $2
+WHERE user_id = $1
+AND revoked_at IS NULL
+`
+
+type RevokeAllUserTokensParams struct {
+	UserID    uuid.UUID
+	RevokedAt sql.NullTime
+}
+
+func (q *Queries) RevokeAllUserTokens(ctx context.Context, arg RevokeAllUserTokensParams) error {
+	_, err := q.db.ExecContext(ctx, revokeAllUserTokens, arg.UserID, arg.RevokedAt)
+	return err
+}
+
+const revokeClientUserTokens = `-- name: RevokeClientUserTokens :exec
+UPDATE refresh_tokens
+SET
+	revoked_at = $3,
+	updated_at = $3
+WHERE user_id = $1
+AND client_id = $2
+AND revoked_at IS NULL
+`
+
+type RevokeClientUserTokensParams struct {
+	UserID    uuid.UUID
+	ClientID  uuid.NullUUID
+	RevokedAt sql.NullTime
+}
+
+func (q *Queries) RevokeClientUserTokens(ctx context.Context, arg RevokeClientUserTokensParams) error {
+	_, err := q.db.ExecContext(ctx, revokeClientUserTokens, arg.UserID, arg.ClientID, arg.RevokedAt)
+	return err
+}
+
 const revokeToken = `-- name: RevokeToken :exec
 UPDATE refresh_tokens
 SET 
 	revoked_at = $2,
 	updated_at = $2
-WHERE token = $1
+WHERE token_hash = $1
 `
 
 type RevokeTokenParams struct {
-	Token     string
+	TokenHash string
 	RevokedAt sql.NullTime
 }
 
 func (q *Queries) RevokeToken(ctx context.Context, arg RevokeTokenParams) error {
-	_, err := q.db.ExecContext(ctx, revokeToken, arg.Token, arg.RevokedAt)
+	_, err := q.db.ExecContext(ctx, revokeToken, arg.TokenHash, arg.RevokedAt)
+	return err
+}
+
+const revokeTokenFamily = `-- name: RevokeTokenFamily :exec
+UPDATE refresh_tokens
+SET
+	revoked_at = $2,
+	updated_at = $2
+WHERE family_id = $1
+AND revoked_at IS NULL
+`
+
+type RevokeTokenFamilyParams struct {
+	FamilyID  uuid.UUID
+	RevokedAt sql.NullTime
+}
+
+func (q *Queries) RevokeTokenFamily(ctx context.Context, arg RevokeTokenFamilyParams) error {
+	_, err := q.db.ExecContext(ctx, revokeTokenFamily, arg.FamilyID, arg.RevokedAt)
 	return err
 }
+
+const revokeUserTokenFamily = `-- name: RevokeUserTokenFamily :execrows
+UPDATE refresh_tokens
+SET
+	revoked_at = $3,
+	updated_at = $3
+WHERE family_id = $1
+AND user_id = $2
+AND revoked_at IS NULL
+`
+
+type RevokeUserTokenFamilyParams struct {
+	FamilyID  uuid.UUID
+	UserID    uuid.UUID
+	RevokedAt sql.NullTime
+}
+
+func (q *Queries) RevokeUserTokenFamily(ctx context.Context, arg RevokeUserTokenFamilyParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeUserTokenFamily, arg.FamilyID, arg.UserID, arg.RevokedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const rotateRefreshToken = `-- name: RotateRefreshToken :execrows
+UPDATE refresh_tokens
+SET
+	replaced_at = $2,
+	updated_at = $2
+WHERE token_hash = $1
+AND revoked_at IS NULL
+AND replaced_at IS NULL
+`
+
+type RotateRefreshTokenParams struct {
+	TokenHash  string
+	ReplacedAt sql.NullTime
+}
+
+func (q *Queries) RotateRefreshToken(ctx context.Context, arg RotateRefreshTokenParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, rotateRefreshToken, arg.TokenHash, arg.ReplacedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -7,11 +7,62 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+const bumpTokenVersion = `-- name: BumpTokenVersion :one
+UPDATE users
+SET token_version = token_version + 1
+WHERE id = $1
+RETURNING token_version
+`
+
+func (q *Queries) BumpTokenVersion(ctx context.Context, id uuid.UUID) (int32, error) {
+	row := q.db.QueryRowContext(ctx, bumpTokenVersion, id)
+	var token_version int32
+	err := row.Scan(&token_version)
+	return token_version, err
+}
+
+const claimLoginAttempt = `-- name: ClaimLoginAttempt :one
+UPDATE users
+SET
+	failed_logins = CASE
+		WHEN last_failed_login_at IS NULL OR last_failed_login_at < $1::timestamp THEN 1
+		ELSE failed_logins + 1
+	END,
+	last_failed_login_at = $2::timestamp
+WHERE id = $3
+AND failed_logins = $4
+AND last_failed_login_at IS NOT DISTINCT FROM $5::timestamp
+RETURNING failed_logins
+`
+
+type ClaimLoginAttemptParams struct {
+	WindowStart  time.Time
+	FailedAt     time.Time
+	ID           uuid.UUID
+	SeenFailures int32
+	SeenFailedAt sql.NullTime
+}
+
+func (q *Queries) ClaimLoginAttempt(ctx context.Context, arg ClaimLoginAttemptParams) (int32, error) {
+	row := q.db.QueryRowContext(ctx, claimLoginAttempt,
+		arg.WindowStart,
+		arg.FailedAt,
+		arg.ID,
+		arg.SeenFailures,
+		arg.SeenFailedAt,
+	)
+	var failed_logins int32
+	err := row.Scan(&failed_logins)
+	return failed_logins, err
+}
+
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (
 	id, 
@@ -63,8 +114,21 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (CreateU
 	return i, err
 }
 
+const getTokenVersion = `-- name: GetTokenVersion :one
+SELECT token_version
+FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetTokenVersion(ctx context.Context, id uuid.UUID) (int32, error) {
+	row := q.db.QueryRowContext(ctx, getTokenVersion, id)
+	var token_version int32
+	err := row.Scan(&token_version)
+	return token_version, err
+}
+
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red 
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, username, token_version, email_verified_at, totp_secret, totp_enabled_at, totp_last_step, failed_logins, last_failed_login_at, role 
 FROM users
 WHERE email = $1
 `
@@ -79,10 +143,155 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.Username,
+		&i.TokenVersion,
+		&i.EmailVerifiedAt,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.FailedLogins,
+		&i.LastFailedLoginAt,
+		&i.Role,
 	)
 	return i, err
 }
 
+const getUserByID = `-- name: GetUserByID :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, username, token_version, email_verified_at, totp_secret, totp_enabled_at, totp_last_step, failed_logins, last_failed_login_at, role
+FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.Username,
+		&i.TokenVersion,
+		&i.EmailVerifiedAt,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.FailedLogins,
+		&i.LastFailedLoginAt,
+		&i.Role,
+	)
+	return i, err
+}
+
+const getUserRole = `-- name: GetUserRole :one
+SELECT role
+FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUserRole(ctx context.Context, id uuid.UUID) (string, error) {
+	row := q.db.QueryRowContext(ctx, getUserRole, id)
+	var role string
+	err := row.Scan(&role)
+	return role, err
+}
+
+const getUsersByUsernames = `-- name: GetUsersByUsernames :many
+SELECT id, username
+FROM users
+WHERE username = ANY($1::text[])
+`
+
+type GetUsersByUsernamesRow struct {
+	ID       uuid.UUID
+	Username sql.NullString
+}
+
+func (q *Queries) GetUsersByUsernames(ctx context.Context, usernames []string) ([]GetUsersByUsernamesRow, error) {
+	rows, err := q.db.QueryContext(ctx, getUsersByUsernames, pq.Array(usernames))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetUsersByUsernamesRow
+	for rows.Next() {
+		var i GetUsersByUsernamesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Username,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockAdmins = `-- name: LockAdmins :many
+SELECT id
+FROM users
+WHERE role = 'admin'
+FOR UPDATE
+`
+
+func (q *Queries) LockAdmins(ctx context.Context) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, lockAdmins)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const refundLoginAttempt = `-- name: RefundLoginAttempt :exec
+UPDATE users
+SET failed_logins = GREATEST(failed_logins - 1, 0)
+WHERE id = $1
+`
+
+func (q *Queries) RefundLoginAttempt(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, refundLoginAttempt, id)
+	return err
+}
+
+const resetFailedLogins = `-- name: ResetFailedLogins :execrows
+UPDATE users
+SET
+	failed_logins = 0,
+	last_failed_login_at = NULL
+WHERE id = $1
+`
+
+func (q *Queries) ResetFailedLogins(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, resetFailedLogins, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const resetUsers = `-- name: ResetUsers :exec
 DELETE FROM users
 `
@@ -92,14 +301,83 @@ func (q *Queries) ResetUsers(ctx context.Context) error {
 	return err
 }
 
+const setUserPassword = `-- name: SetUserPassword :one
+UPDATE users
+SET
+	hashed_password = $1,
+	updated_at = $2,
+	token_version = token_version + 1
+WHERE id = $3
+RETURNING token_version
+`
+
+type SetUserPasswordParams struct {
+	HashedPassword string
+	UpdatedAt      time.Time
+	ID             uuid.UUID
+}
+
+func (q *Queries) SetUserPassword(ctx context.Context, arg SetUserPasswordParams) (int32, error) {
+	row := q.db.QueryRowContext(ctx, setUserPassword, arg.HashedPassword, arg.UpdatedAt, arg.ID)
+	var token_version int32
+	err := row.Scan(&token_version)
+	return token_version, err
+}
+
+const setUserRole = `-- name: SetUserRole :execrows
+UPDATE users
+SET
+	role = $1,
+	updated_at = $2
+WHERE id = $3
+`
+
+type SetUserRoleParams struct {
+	Role      string
+	UpdatedAt time.Time
+	ID        uuid.UUID
+}
+
+func (q *Queries) SetUserRole(ctx context.Context, arg SetUserRoleParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setUserRole, arg.Role, arg.UpdatedAt, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setUsername = `-- name: SetUsername :one
+UPDATE users
+SET
+	username = $1,
+	updated_at = $2
+WHERE id = $3
+RETURNING username
+`
+
+type SetUsernameParams struct {
+	Username  sql.NullString
+	UpdatedAt time.Time
+	ID        uuid.UUID
+}
+
+func (q *Queries) SetUsername(ctx context.Context, arg SetUsernameParams) (sql.NullString, error) {
+	row := q.db.QueryRowContext(ctx, setUsername, arg.Username, arg.UpdatedAt, arg.ID)
+	var username sql.NullString
+	err := row.Scan(&username)
+	return username, err
+}
+
 const updateUser = `-- name: UpdateUser :one
 UPDATE users
 SET 
 	email = $1,
 	hashed_password = $2,
-	updated_at = $3
+	updated_at = $3,
+	token_version = token_version + 1,
+	email_verified_at = CASE WHEN email = $1 THEN email_verified_at ELSE NULL END
 WHERE id = $4
-RETURNING id, email, created_at, updated_at, is_chirpy_red
+RETURNING id, email, created_at, updated_at, is_chirpy_red, username, token_version, email_verified_at, role
 `
 
 type UpdateUserParams struct {
@@ -110,11 +388,15 @@ type UpdateUserParams struct {
 }
 
 type UpdateUserRow struct {
-	ID          uuid.UUID
-	Email       string
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
-	IsChirpyRed bool
+	ID              uuid.UUID
+	Email           string
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	IsChirpyRed     bool
+	Username        sql.NullString
+	TokenVersion    int32
+	EmailVerifiedAt sql.NullTime
+	Role            string
 }
 
 func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (UpdateUserRow, error) {
@@ -131,6 +413,10 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (UpdateU
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.IsChirpyRed,
+		&i.Username,
+		&i.TokenVersion,
+		&i.EmailVerifiedAt,
+		&i.Role,
 	)
 	return i, err
 }
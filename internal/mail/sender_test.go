@@ -0,0 +1,43 @@
+package mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileSender(t *testing.T) {
+	dir := t.TempDir()
+	sender := FileSender{Dir: dir, From: "noreply@chirpy.test"}
+
+	err := sender.Send(context.Background(), Message{
+		To:      "pippin@example.com",
+		Subject: "Hello",
+		Body:    "line one\nline two",
+	})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected one message file, got %v (%v)", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("Failed to read message: %v", err)
+	}
+	for _, want := range []string{"To: pippin@example.com\r\n", "Subject: Hello\r\n", "line one\r\nline two"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Message is missing %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	if got := sanitize("a/b\\c d@x.io"); got != "a_b_c_d@x.io" {
+		t.Errorf("sanitize = %q", got)
+	}
+}
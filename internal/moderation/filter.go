@@ -0,0 +1,116 @@
+package moderation
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Replacement is written in place of each flagged word
+const Replacement = "****"
+
+type Filter struct {
+	source Source
+	mu     sync.RWMutex
+	words  map[string]struct{}
+}
+
+func NewFilter(source Source) *Filter {
+	// Creates a filter backed by source, call Reload to populate it
+
+	return &Filter{
+		source: source,
+		words:  make(map[string]struct{}),
+	}
+}
+
+func (f *Filter) Reload(ctx context.Context) (int, error) {
+	// Replaces the word list with a fresh copy from the source and returns its size
+	// On error the previous list stays in effect
+
+	words, err := f.source.Words(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	loaded := make(map[string]struct{}, len(words))
+	for _, w := range words {
+		w = strings.TrimSpace(w)
+		if w == "" {
+			continue
+		}
+		loaded[foldKey(w)] = struct{}{}
+	}
+
+	// Swaps the list in under the write lock so readers never see a partial list
+	f.mu.Lock()
+	f.words = loaded
+	f.mu.Unlock()
+
+	return len(loaded), nil
+}
+
+func (f *Filter) Censor(s string) string {
+	// Replaces each flagged word in s, leaving punctuation and spacing exactly as written
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if len(f.words) == 0 {
+		return s
+	}
+
+	var out strings.Builder
+	out.Grow(len(s))
+
+	// Walks the string one word (run of letters and digits) at a time
+	start := -1
+	for i, r := range s {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			out.WriteString(f.censorWord(s[start:i]))
+			start = -1
+		}
+		out.WriteRune(r)
+	}
+	if start >= 0 {
+		out.WriteString(f.censorWord(s[start:]))
+	}
+
+	return out.String()
+}
+
+func (f *Filter) censorWord(word string) string {
+	// Returns the replacement if word is flagged, otherwise word unchanged
+	// Callers must hold the read lock
+
+	if _, ok := f.words[foldKey(word)]; ok {
+		return Replacement
+	}
+	return word
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func foldKey(s string) string {
+	// Maps each rune to the smallest rune in its Unicode case folding orbit,
+	// so strings that are equal under case folding share a key
+
+	return strings.Map(func(r rune) rune {
+		smallest := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if f < smallest {
+				smallest = f
+			}
+		}
+		return smallest
+	}, s)
+}
@@ -0,0 +1,92 @@
+package moderation_test
+
+import (
+	"context"
+	"github.com/roxensox/chirpy/internal/moderation"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCensor(t *testing.T) {
+	filter := moderation.NewFilter(moderation.StaticSource{"kerfuffle", "sharbert", "straße"})
+	if _, err := filter.Reload(context.Background()); err != nil {
+		t.Fatalf("Failed to load words: %v", err)
+	}
+
+	test_cases := []struct {
+		input    string
+		expected string
+	}{
+		{
+			input:    "what a kerfuffle",
+			expected: "what a ****",
+		},
+		{
+			input:    "Kerfuffle! Such a SHARBERT.",
+			expected: "****! Such a ****.",
+		},
+		// Spacing is preserved exactly
+		{
+			input:    "  kerfuffle\t\tfine  ",
+			expected: "  ****\t\tfine  ",
+		},
+		// Only whole words are flagged
+		{
+			input:    "kerfuffles and sharberts",
+			expected: "kerfuffles and sharberts",
+		},
+		// Matching uses Unicode case folding, not just ASCII lowercasing
+		{
+			input:    "\u212Aerfuffle and STRA\u1E9EE",
+			expected: "**** and ****",
+		},
+		{
+			input:    "",
+			expected: "",
+		},
+	}
+
+	for i, tc := range test_cases {
+		out := filter.Censor(tc.input)
+		if out != tc.expected {
+			t.Errorf("Case #%d: expected %q, got %q", i, tc.expected, out)
+		}
+	}
+}
+
+func TestReloadFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	if err := os.WriteFile(path, []byte("# flagged words\nfornax\n\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write word list: %v", err)
+	}
+
+	filter := moderation.NewFilter(moderation.FileSource{Path: path})
+	n, err := filter.Reload(context.Background())
+	if err != nil || n != 1 {
+		t.Fatalf("Expected 1 word loaded, got %d (%v)", n, err)
+	}
+	if out := filter.Censor("Fornax?"); out != "****?" {
+		t.Errorf("Expected word from file to be censored, got %q", out)
+	}
+
+	// Rewrites the file and reloads without recreating the filter
+	if err := os.WriteFile(path, []byte("sharbert\n"), 0o600); err != nil {
+		t.Fatalf("Failed to rewrite word list: %v", err)
+	}
+	if _, err := filter.Reload(context.Background()); err != nil {
+		t.Fatalf("Failed to reload: %v", err)
+	}
+	if out := filter.Censor("fornax sharbert"); out != "fornax ****" {
+		t.Errorf("Expected reloaded list to replace the old one, got %q", out)
+	}
+
+	// A failed reload keeps the current list
+	os.Remove(path)
+	if _, err := filter.Reload(context.Background()); err == nil {
+		t.Errorf("Expected reload of a missing file to fail")
+	}
+	if out := filter.Censor("sharbert"); out != "****" {
+		t.Errorf("Expected previous list to survive a failed reload, got %q", out)
+	}
+}
@@ -0,0 +1,54 @@
+package moderation
+
+import (
+	"bufio"
+	"context"
+	"github.com/roxensox/chirpy/internal/database"
+	"os"
+	"strings"
+)
+
+type Source interface {
+	Words(ctx context.Context) ([]string, error)
+}
+
+type FileSource struct {
+	Path string
+}
+
+func (s FileSource) Words(ctx context.Context) ([]string, error) {
+	// Reads one word per line, skipping blank lines and lines starting with #
+
+	file, err := os.Open(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	words := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return words, scanner.Err()
+}
+
+type DBSource struct {
+	DB *database.Queries
+}
+
+func (s DBSource) Words(ctx context.Context) ([]string, error) {
+	// Reads the words from the moderation_words table
+
+	return s.DB.GetModerationWords(ctx)
+}
+
+type StaticSource []string
+
+func (s StaticSource) Words(ctx context.Context) ([]string, error) {
+	return s, nil
+}
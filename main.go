@@ -1,14 +1,22 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/base64"
 	"fmt"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"github.com/roxensox/chirpy/internal/auth"
 	"github.com/roxensox/chirpy/internal/chirpyserver"
 	"github.com/roxensox/chirpy/internal/database"
+	"github.com/roxensox/chirpy/internal/mail"
+	"github.com/roxensox/chirpy/internal/moderation"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func main() {
@@ -27,14 +35,118 @@ func main() {
 
 	// Gets a query engine for the database and adds it to the config object
 	dbQueries := database.New(db)
+
+	// Loads flagged words from a file if one is configured, otherwise from the database
+	var wordSource moderation.Source = moderation.DBSource{DB: dbQueries}
+	if path := os.Getenv("MODERATION_WORDS_FILE"); path != "" {
+		wordSource = moderation.FileSource{Path: path}
+	}
+	filter := moderation.NewFilter(wordSource)
+	if _, err := filter.Reload(context.Background()); err != nil {
+		fmt.Printf("Unable to load moderation words: %v\n", err)
+	}
+
+	// Loads signing keys from a directory if one is configured, otherwise signs with the shared secret
+	keys := auth.NewKeySet()
+	keysDir := os.Getenv("JWT_KEYS_DIR")
+	if keysDir != "" {
+		if err := keys.LoadDir(keysDir); err != nil {
+			fmt.Printf("Unable to load signing keys: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		keys.Add(auth.NewHMACKey("default", os.Getenv("SECRET")))
+		keys.SetSigningKey("default")
+	}
+
+	// Pins the accepted signing algorithms if configured and allows a little clock skew between servers
+	revocations := chirpyserver.NewTokenRevocations(dbQueries, envDuration("TOKEN_REVOCATION_CACHE_TTL", 30*time.Second))
+	validator := &auth.Validator{
+		Keys:        keys,
+		ClockSkew:   envDuration("JWT_CLOCK_SKEW", 30*time.Second),
+		Revocations: revocations,
+	}
+	if algs := os.Getenv("JWT_ALGORITHMS"); algs != "" {
+		validator.Algorithms = strings.Split(algs, ",")
+	}
+
+	// Sends mail through SMTP if a relay is configured, otherwise writes it to a directory or the log
+	var mailer mail.Sender = mail.LogSender{}
+	mailFrom := os.Getenv("MAIL_FROM")
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		mailer = mail.SMTPSender{
+			Addr:     addr,
+			From:     mailFrom,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}
+	} else if dir := os.Getenv("MAIL_DIR"); dir != "" {
+		mailer = mail.FileSender{Dir: dir, From: mailFrom}
+	}
+
+	// Reads the key TOTP secrets are encrypted with, two-factor authentication stays off without one
+	var mfaKey []byte
+	if encoded := os.Getenv("MFA_ENCRYPTION_KEY"); encoded != "" {
+		mfaKey, err = base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(mfaKey) != 32 {
+			fmt.Println("MFA_ENCRYPTION_KEY must be 32 bytes, base64 encoded")
+			os.Exit(1)
+		}
+	}
+
+	// Reads TOKEN_HASH_KEY, the required key refresh tokens, personal access tokens, password reset codes and
+	// OAuth client secrets are hashed with before they're stored. It must be at least 32 random bytes, base64 encoded,
+	// and changing it invalidates every one of those tokens
+	tokenHashKey, err := base64.StdEncoding.DecodeString(os.Getenv("TOKEN_HASH_KEY"))
+	if err != nil || len(tokenHashKey) < 32 {
+		fmt.Println("TOKEN_HASH_KEY must be at least 32 bytes, base64 encoded; generate one with: openssl rand -base64 32")
+		os.Exit(1)
+	}
+
+	// Reads the login backoff limits, falling back to the defaults for anything unset
+	accountBackoff := chirpyserver.DefaultAccountBackoff
+	accountBackoff.FreeAttempts = envInt("LOGIN_FREE_ATTEMPTS", accountBackoff.FreeAttempts)
+	accountBackoff.LockoutAttempts = envInt("LOGIN_LOCKOUT_ATTEMPTS", accountBackoff.LockoutAttempts)
+	accountBackoff.LockoutDuration = envDuration("LOGIN_LOCKOUT_DURATION", accountBackoff.LockoutDuration)
+	ipBackoff := chirpyserver.DefaultIPBackoff
+	ipBackoff.FreeAttempts = envInt("LOGIN_IP_FREE_ATTEMPTS", ipBackoff.FreeAttempts)
+	ipBackoff.LockoutAttempts = envInt("LOGIN_IP_LOCKOUT_ATTEMPTS", ipBackoff.LockoutAttempts)
+	ipBackoff.LockoutDuration = envDuration("LOGIN_IP_LOCKOUT_DURATION", ipBackoff.LockoutDuration)
+
+	// Builds the server config
 	config := chirpyserver.ApiConfig{
-		DBConn: dbQueries,
-		Secret: os.Getenv("SECRET"),
-		APIKey: os.Getenv("POLKA_KEY"),
+		DBConn:               dbQueries,
+		DB:                   db,
+		Keys:                 keys,
+		KeysDir:              keysDir,
+		APIKey:               os.Getenv("POLKA_KEY"),
+		ChirpMaxLength:       envInt("CHIRP_MAX_LENGTH", 140),
+		RedChirpMaxLength:    envInt("RED_CHIRP_MAX_LENGTH", 280),
+		Moderation:           filter,
+		ChirpEditWindow:      envDuration("CHIRP_EDIT_WINDOW", chirpyserver.DefaultChirpEditWindow),
+		ChirpRetention:       envDuration("CHIRP_RETENTION", 30*24*time.Hour),
+		Validator:            validator,
+		Revocations:          revocations,
+		Mailer:               mailer,
+		PasswordResetTTL:     envDuration("PASSWORD_RESET_TTL", time.Hour),
+		RequireVerifiedEmail: os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true",
+		DevMode:              os.Getenv("DEV_MODE") == "true",
+		MFAKey:               mfaKey,
+		TokenHashKey:         tokenHashKey,
+		LoginThrottle:        chirpyserver.NewLoginThrottle(accountBackoff, ipBackoff),
 	}
 
-	// Starts a new server mux
-	sMux := http.NewServeMux()
+	// Starts the background job that permanently removes old deleted chirps
+	go config.RunChirpPurger(context.Background(), time.Hour)
+
+	// Starts the background job that drops retired signing keys once their tokens have expired
+	go config.RunKeyPruner(context.Background(), time.Minute)
+
+	// Starts the background job that forgets old failed logins from IPs and unknown emails
+	go config.RunThrottlePruner(context.Background(), time.Minute)
+
+	// Gets a server mux with every API route bound
+	sMux := config.Routes()
 
 	// Makes a root handler for the file server
 	handler := http.FileServer(http.Dir("."))
@@ -48,28 +160,26 @@ func main() {
 	// Binds middleware-produced handler to app directory
 	sMux.Handle("/app/", config.MiddlewareMetricsInc(handler))
 
-	// Binds functions to POST handlers
-	sMux.HandleFunc("POST /admin/reset", config.Reset)
-	sMux.HandleFunc("POST /api/validate_chirp", chirpyserver.ValidateChirp)
-	sMux.HandleFunc("POST /api/users", config.POSTUsers)
-	sMux.HandleFunc("POST /api/chirps", config.POSTChirps)
-	sMux.HandleFunc("POST /api/login", config.POSTLogin)
-	sMux.HandleFunc("POST /api/refresh", config.POSTRefresh)
-	sMux.HandleFunc("POST /api/revoke", config.POSTRevoke)
-	sMux.HandleFunc("POST /api/polka/webhooks", config.POSTPolkaWebhooks)
+	// Runs the server
+	server.ListenAndServe()
+}
 
-	// Binds functions to PUT handlers
-	sMux.HandleFunc("PUT /api/users", config.PUTUsers)
+func envInt(key string, fallback int) int {
+	// Reads an integer setting from the environment, using fallback if it's unset or invalid
 
-	// Binds functions to GET handlers
-	sMux.HandleFunc("GET /api/healthz", chirpyserver.Healthz)
-	sMux.HandleFunc("GET /admin/metrics", config.FServerHits)
-	sMux.HandleFunc("GET /api/chirps", config.GETChirps)
-	sMux.HandleFunc("GET /api/chirps/{chirpID}", config.GETChirpByID)
+	val, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return val
+}
 
-	// Binds functions to DELETE handlers
-	sMux.HandleFunc("DELETE /api/chirps/{chirpID}", config.DELETEChirpByID)
+func envDuration(key string, fallback time.Duration) time.Duration {
+	// Reads a duration setting (like "15m") from the environment, using fallback if it's unset or invalid
 
-	// Runs the server
-	server.ListenAndServe()
+	val, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return val
 }
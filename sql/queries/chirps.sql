@@ -4,31 +4,183 @@ INSERT INTO chirps (
 	created_at,
 	updated_at,
 	body,
-	user_id
+	user_id,
+	in_reply_to
 ) VALUES (
 	$1,
 	$2,
 	$3,
 	$4,
-	$5
+	$5,
+	$6
 ) RETURNING *;
 
 -- name: GetChirps :many
 SELECT * 
 FROM chirps
+WHERE deleted_at IS NULL
 ORDER BY created_at ASC;
 
 -- name: GetExactChirp :one
 SELECT *
 FROM chirps
+WHERE id = $1
+AND deleted_at IS NULL;
+
+-- name: LockChirp :one
+SELECT *
+FROM chirps
+WHERE id = $1
+AND deleted_at IS NULL
+FOR UPDATE;
+
+-- name: GetExactChirpWithDeleted :one
+SELECT *
+FROM chirps
 WHERE id = $1;
 
--- name: DeleteChirp :exec
-DELETE FROM chirps
+-- name: SoftDeleteChirp :exec
+UPDATE chirps
+SET
+	deleted_at = $2,
+	updated_at = $2
 WHERE id = $1;
 
+-- name: RestoreChirp :one
+UPDATE chirps
+SET
+	deleted_at = NULL,
+	updated_at = $2
+WHERE id = $1
+AND NOT tombstoned
+RETURNING *;
+
 -- name: GetChirpsByAuthor :many
 SELECT *
 FROM chirps
 WHERE user_id = $1
+AND deleted_at IS NULL
 ORDER BY created_at ASC;
+
+-- name: GetChirpsPage :many
+SELECT *
+FROM chirps
+WHERE deleted_at IS NULL
+AND (
+	sqlc.narg('cursor_created_at')::timestamp IS NULL
+	OR (created_at, id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('page_limit');
+
+-- name: GetChirpsPageDesc :many
+SELECT *
+FROM chirps
+WHERE deleted_at IS NULL
+AND (
+	sqlc.narg('cursor_created_at')::timestamp IS NULL
+	OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_limit');
+
+-- name: GetChirpsByAuthorPage :many
+SELECT *
+FROM chirps
+WHERE user_id = sqlc.arg('user_id')
+AND deleted_at IS NULL
+AND (
+	sqlc.narg('cursor_created_at')::timestamp IS NULL
+	OR (created_at, id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('page_limit');
+
+-- name: GetChirpsByAuthorPageDesc :many
+SELECT *
+FROM chirps
+WHERE user_id = sqlc.arg('user_id')
+AND deleted_at IS NULL
+AND (
+	sqlc.narg('cursor_created_at')::timestamp IS NULL
+	OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_limit');
+
+-- name: GetChirpReplies :many
+SELECT *
+FROM chirps
+WHERE in_reply_to = sqlc.arg('in_reply_to')
+AND (
+	sqlc.narg('cursor_created_at')::timestamp IS NULL
+	OR (created_at, id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('page_limit');
+
+-- name: GetChirpAncestors :many
+WITH RECURSIVE ancestors AS (
+	SELECT c.id, c.in_reply_to, 0 AS depth
+	FROM chirps c
+	WHERE c.id = $1
+	UNION ALL
+	SELECT p.id, p.in_reply_to, a.depth + 1
+	FROM chirps p
+	JOIN ancestors a ON p.id = a.in_reply_to
+)
+SELECT chirps.*
+FROM chirps
+JOIN ancestors ON chirps.id = ancestors.id
+WHERE ancestors.depth > 0
+ORDER BY ancestors.depth DESC;
+
+-- name: GetChirpDescendants :many
+WITH RECURSIVE descendants AS (
+	SELECT c.id
+	FROM chirps c
+	WHERE c.in_reply_to = $1
+	UNION ALL
+	SELECT r.id
+	FROM chirps r
+	JOIN descendants d ON r.in_reply_to = d.id
+)
+SELECT chirps.*
+FROM chirps
+JOIN descendants ON chirps.id = descendants.id
+ORDER BY chirps.created_at ASC, chirps.id ASC;
+
+-- name: SearchChirps :many
+SELECT chirps.*
+FROM chirps, to_tsquery('english', sqlc.arg('query')) query
+WHERE chirps.search_vector @@ query
+AND chirps.deleted_at IS NULL
+ORDER BY ts_rank(chirps.search_vector, query) DESC, chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg('page_limit')
+OFFSET sqlc.arg('page_offset');
+
+-- name: UpdateChirpBody :one
+UPDATE chirps
+SET
+	body = $1,
+	updated_at = $2
+WHERE id = $3
+AND deleted_at IS NULL
+RETURNING *;
+
+-- name: TombstoneExpiredChirps :execrows
+UPDATE chirps
+SET
+	body = '',
+	tombstoned = TRUE
+WHERE deleted_at < $1
+AND NOT tombstoned;
+
+-- name: PurgeExpiredChirps :execrows
+DELETE FROM chirps
+WHERE deleted_at < $1
+AND NOT EXISTS (
+	SELECT 1
+	FROM chirps replies
+	WHERE replies.in_reply_to = chirps.id
+);
@@ -0,0 +1,23 @@
+-- name: DenyAccessToken :exec
+INSERT INTO denied_access_tokens (
+	jti,
+	user_id,
+	expires_at,
+	created_at
+) VALUES (
+	$1,
+	$2,
+	$3,
+	$4
+) ON CONFLICT DO NOTHING;
+
+-- name: IsAccessTokenDenied :one
+SELECT EXISTS (
+	SELECT 1
+	FROM denied_access_tokens
+	WHERE jti = $1
+);
+
+-- name: PurgeExpiredDeniedTokens :exec
+DELETE FROM denied_access_tokens
+WHERE expires_at < $1;
@@ -0,0 +1,30 @@
+-- name: CreateEmailVerificationToken :exec
+INSERT INTO email_verification_tokens (
+	token_hash,
+	user_id,
+	email,
+	created_at,
+	expires_at
+) VALUES (
+	$1,
+	$2,
+	$3,
+	$4,
+	$5
+);
+
+-- name: ConsumeEmailVerificationToken :one
+UPDATE email_verification_tokens
+SET used_at = $2
+WHERE token_hash = $1
+AND used_at IS NULL
+AND expires_at > $2
+RETURNING user_id, email;
+
+-- name: MarkEmailVerified :execrows
+UPDATE users
+SET
+	email_verified_at = $3,
+	updated_at = $3
+WHERE id = $1
+AND email = $2;
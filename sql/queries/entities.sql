@@ -0,0 +1,74 @@
+-- name: AddChirpTag :exec
+INSERT INTO chirp_tags (
+	chirp_id,
+	tag
+) VALUES (
+	$1,
+	$2
+) ON CONFLICT DO NOTHING;
+
+-- name: AddChirpMention :exec
+INSERT INTO chirp_mentions (
+	chirp_id,
+	user_id,
+	username
+) VALUES (
+	$1,
+	$2,
+	$3
+) ON CONFLICT DO NOTHING;
+
+-- name: GetChirpMentions :many
+SELECT *
+FROM chirp_mentions
+WHERE chirp_id = ANY(sqlc.arg('chirp_ids')::uuid[]);
+
+-- name: GetChirpsByTag :many
+SELECT chirps.*
+FROM chirps
+JOIN chirp_tags ON chirps.id = chirp_tags.chirp_id
+WHERE chirp_tags.tag = sqlc.arg('tag')
+AND chirps.deleted_at IS NULL
+AND (
+	sqlc.narg('cursor_created_at')::timestamp IS NULL
+	OR (chirps.created_at, chirps.id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg('page_limit');
+
+-- name: GetChirpsMentioningUser :many
+SELECT chirps.*
+FROM chirps
+JOIN chirp_mentions ON chirps.id = chirp_mentions.chirp_id
+WHERE chirp_mentions.user_id = sqlc.arg('user_id')
+AND chirps.deleted_at IS NULL
+AND (
+	sqlc.narg('cursor_created_at')::timestamp IS NULL
+	OR (chirps.created_at, chirps.id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg('page_limit');
+
+-- name: DeleteChirpTags :exec
+DELETE FROM chirp_tags
+WHERE chirp_id = $1;
+
+-- name: DeleteChirpMentions :exec
+DELETE FROM chirp_mentions
+WHERE chirp_id = $1;
+
+-- name: PurgeExpiredChirpTags :exec
+DELETE FROM chirp_tags
+WHERE chirp_id IN (
+	SELECT id
+	FROM chirps
+	WHERE deleted_at < $1
+);
+
+-- name: PurgeExpiredChirpMentions :exec
+DELETE FROM chirp_mentions
+WHERE chirp_id IN (
+	SELECT id
+	FROM chirps
+	WHERE deleted_at < $1
+);
@@ -0,0 +1,21 @@
+-- name: LikeChirp :execrows
+INSERT INTO chirp_likes (
+	chirp_id,
+	user_id,
+	created_at
+) VALUES (
+	$1,
+	$2,
+	$3
+) ON CONFLICT DO NOTHING;
+
+-- name: UnlikeChirp :execrows
+DELETE FROM chirp_likes
+WHERE chirp_id = $1
+AND user_id = $2;
+
+-- name: GetLikedChirpIDs :many
+SELECT chirp_id
+FROM chirp_likes
+WHERE user_id = sqlc.arg('user_id')
+AND chirp_id = ANY(sqlc.arg('chirp_ids')::uuid[]);
@@ -0,0 +1,79 @@
+-- name: SetPendingTOTPSecret :execrows
+UPDATE users
+SET
+	totp_secret = $2,
+	updated_at = $3
+WHERE id = $1
+AND totp_enabled_at IS NULL;
+
+-- name: EnableTOTP :exec
+UPDATE users
+SET
+	totp_enabled_at = $2,
+	updated_at = $2
+WHERE id = $1;
+
+-- name: DisableTOTP :exec
+UPDATE users
+SET
+	totp_secret = NULL,
+	totp_enabled_at = NULL,
+	totp_last_step = 0,
+	updated_at = $2
+WHERE id = $1;
+
+-- name: AdvanceTOTPStep :execrows
+UPDATE users
+SET totp_last_step = $2
+WHERE id = $1
+AND totp_last_step < $2;
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM mfa_recovery_codes
+WHERE user_id = $1;
+
+-- name: AddRecoveryCode :exec
+INSERT INTO mfa_recovery_codes (
+	code_hash,
+	user_id,
+	created_at
+) VALUES (
+	$1,
+	$2,
+	$3
+);
+
+-- name: UseRecoveryCode :execrows
+UPDATE mfa_recovery_codes
+SET used_at = $3
+WHERE code_hash = $1
+AND user_id = $2
+AND used_at IS NULL;
+
+-- name: CreateMFAChallenge :exec
+INSERT INTO mfa_challenges (
+	token_hash,
+	user_id,
+	created_at,
+	expires_at
+) VALUES (
+	$1,
+	$2,
+	$3,
+	$4
+);
+
+-- name: ClaimMFAAttempt :one
+UPDATE mfa_challenges
+SET attempts = attempts + 1
+WHERE token_hash = $1
+AND used_at IS NULL
+AND expires_at > $2
+AND attempts < sqlc.arg('max_attempts')
+RETURNING *;
+
+-- name: ConsumeMFAChallenge :execrows
+UPDATE mfa_challenges
+SET used_at = $2
+WHERE token_hash = $1
+AND used_at IS NULL;
@@ -0,0 +1,4 @@
+-- name: GetModerationWords :many
+SELECT word
+FROM moderation_words
+ORDER BY word ASC;
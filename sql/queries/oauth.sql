@@ -0,0 +1,104 @@
+-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (
+	id,
+	owner_id,
+	name,
+	secret_hash,
+	redirect_uris,
+	created_at
+) VALUES (
+	$1,
+	$2,
+	$3,
+	$4,
+	$5,
+	$6
+) RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT *
+FROM oauth_clients
+WHERE id = $1;
+
+-- name: UpsertOAuthConsent :one
+INSERT INTO oauth_consents (
+	user_id,
+	client_id,
+	scopes,
+	created_at,
+	updated_at
+) VALUES (
+	$1,
+	$2,
+	$3,
+	$4,
+	$4
+)
+ON CONFLICT (user_id, client_id) DO UPDATE
+SET
+	scopes = ARRAY(
+		SELECT DISTINCT s
+		FROM unnest(oauth_consents.scopes || EXCLUDED.scopes) AS s
+		ORDER BY s
+	),
+	updated_at = EXCLUDED.updated_at
+RETURNING *;
+
+-- name: GetOAuthConsent :one
+SELECT *
+FROM oauth_consents
+WHERE user_id = $1
+AND client_id = $2;
+
+-- name: ListOAuthConsents :many
+SELECT
+	oauth_consents.client_id,
+	oauth_clients.name AS client_name,
+	oauth_consents.scopes,
+	oauth_consents.created_at,
+	oauth_consents.updated_at
+FROM oauth_consents
+JOIN oauth_clients ON oauth_clients.id = oauth_consents.client_id
+WHERE oauth_consents.user_id = $1
+ORDER BY oauth_consents.updated_at DESC;
+
+-- name: DeleteOAuthConsent :execrows
+DELETE FROM oauth_consents
+WHERE user_id = $1
+AND client_id = $2;
+
+-- name: CreateAuthorizationCode :exec
+INSERT INTO oauth_authorization_codes (
+	code_hash,
+	client_id,
+	user_id,
+	redirect_uri,
+	scopes,
+	code_challenge,
+	created_at,
+	expires_at
+) VALUES (
+	$1,
+	$2,
+	$3,
+	$4,
+	$5,
+	$6,
+	$7,
+	$8
+);
+
+-- name: ConsumeAuthorizationCode :one
+UPDATE oauth_authorization_codes
+SET
+	used_at = $2,
+	family_id = $3
+WHERE code_hash = $1
+AND used_at IS NULL
+AND expires_at > $2
+RETURNING *;
+
+-- name: GetAuthorizationCodeAnyState :one
+SELECT *
+FROM oauth_authorization_codes
+WHERE code_hash = $1;
@@ -1,28 +1,108 @@
 -- name: AddRefreshToken :exec
 INSERT INTO refresh_tokens (
-	token,
+	token_hash,
 	created_at,
 	updated_at,
 	expires_at,
-	user_id
+	user_id,
+	family_id,
+	user_agent,
+	ip_address,
+	last_used_at,
+	client_id,
+	scopes
 ) VALUES (
 	$1,
 	$2,
 	$3,
 	$4,
-	$5
+	$5,
+	$6,
+	$7,
+	$8,
+	$9,
+	$10,
+	$11
 );
 
 -- name: GetToken :one
 SELECT * 
 FROM refresh_tokens
-WHERE token = $1
+WHERE token_hash = $1
 AND expires_at > $2
-AND revoked_at IS NULL;
+AND revoked_at IS NULL
+AND replaced_at IS NULL;
 
 -- name: RevokeToken :exec
 UPDATE refresh_tokens
 SET 
 	revoked_at = $2,
 	updated_at = $2
-WHERE token = $1;
+WHERE token_hash = $1;
+
+-- name: GetRefreshTokenAnyState :one
+SELECT *
+FROM refresh_tokens
+WHERE token_hash = $1;
+
+-- name: RotateRefreshToken :execrows
+UPDATE refresh_tokens
+SET
+	replaced_at = $2,
+	updated_at = $2
+WHERE token_hash = $1
+AND revoked_at IS NULL
+AND replaced_at IS NULL;
+
+-- name: RevokeTokenFamily :exec
+UPDATE refresh_tokens
+SET
+	revoked_at = $2,
+	updated_at = $2
+WHERE family_id = $1
+AND revoked_at IS NULL;
+
+-- name: GetActiveSessions :many
+SELECT
+	family_id,
+	(
+		SELECT MIN(f.created_at)
+		FROM refresh_tokens f
+		WHERE f.family_id = refresh_tokens.family_id
+	)::timestamp AS started_at,
+	last_used_at,
+	expires_at,
+	user_agent,
+	ip_address
+FROM refresh_tokens
+WHERE user_id = $1
+AND expires_at > $2
+AND revoked_at IS NULL
+AND replaced_at IS NULL
+ORDER BY last_used_at DESC;
+
+-- name: RevokeUserTokenFamily :execrows
+UPDATE refresh_tokens
+SET
+	revoked_at = $3,
+	updated_at = $3
+WHERE family_id = $1
+AND user_id = $2
+AND revoked_at IS NULL;
+
+-- name: RevokeAllUserTokens :exec
+UPDATE refresh_tokens
+SET
+	revoked_at = $2,
+	updated_at = $2
+WHERE user_id = $1
+AND revoked_at IS NULL;
+
+-- name: RevokeClientUserTokens :exec
+UPDATE refresh_tokens
+SET
+	revoked_at = $3,
+	updated_at = $3
+WHERE user_id = $1
+AND client_id = $2
+AND revoked_at IS NULL;
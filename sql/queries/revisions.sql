@@ -0,0 +1,28 @@
+-- name: AddChirpRevision :exec
+INSERT INTO chirp_revisions (
+	id,
+	chirp_id,
+	body,
+	created_at,
+	replaced_at
+) VALUES (
+	$1,
+	$2,
+	$3,
+	$4,
+	$5
+);
+
+-- name: GetChirpRevisions :many
+SELECT *
+FROM chirp_revisions
+WHERE chirp_id = $1
+ORDER BY replaced_at ASC, id ASC;
+
+-- name: PurgeExpiredChirpRevisions :exec
+DELETE FROM chirp_revisions
+WHERE chirp_id IN (
+	SELECT id
+	FROM chirps
+	WHERE deleted_at < $1
+);
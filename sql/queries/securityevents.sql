@@ -0,0 +1,14 @@
+-- name: AddSecurityEvent :exec
+INSERT INTO security_events (
+	id,
+	user_id,
+	event,
+	detail,
+	created_at
+) VALUES (
+	$1,
+	$2,
+	$3,
+	$4,
+	$5
+);
@@ -26,11 +26,94 @@ UPDATE users
 SET 
 	email = $1,
 	hashed_password = $2,
-	updated_at = $3
+	updated_at = $3,
+	token_version = token_version + 1,
+	email_verified_at = CASE WHEN email = $1 THEN email_verified_at ELSE NULL END
 WHERE id = $4
-RETURNING id, email, created_at, updated_at, is_chirpy_red;
+RETURNING id, email, created_at, updated_at, is_chirpy_red, username, token_version, email_verified_at, role;
 
 -- name: UpgradeUser :exec
 UPDATE users
 SET is_chirpy_red = TRUE
 WHERE id = $1;
+
+-- name: GetUserByID :one
+SELECT *
+FROM users
+WHERE id = $1;
+
+-- name: GetUsersByUsernames :many
+SELECT id, username
+FROM users
+WHERE username = ANY(sqlc.arg('usernames')::text[]);
+
+-- name: SetUsername :one
+UPDATE users
+SET
+	username = $1,
+	updated_at = $2
+WHERE id = $3
+RETURNING username;
+
+-- name: GetTokenVersion :one
+SELECT token_version
+FROM users
+WHERE id = $1;
+
+-- name: BumpTokenVersion :one
+UPDATE users
+SET token_version = token_version + 1
+WHERE id = $1
+RETURNING token_version;
+
+-- name: SetUserPassword :one
+UPDATE users
+SET
+	hashed_password = $1,
+	updated_at = $2,
+	token_version = token_version + 1
+WHERE id = $3
+RETURNING token_version;
+
+-- name: ClaimLoginAttempt :one
+UPDATE users
+SET
+	failed_logins = CASE
+		WHEN last_failed_login_at IS NULL OR last_failed_login_at < sqlc.arg('window_start')::timestamp THEN 1
+		ELSE failed_logins + 1
+	END,
+	last_failed_login_at = sqlc.arg('failed_at')::timestamp
+WHERE id = sqlc.arg('id')
+AND failed_logins = sqlc.arg('seen_failures')
+AND last_failed_login_at IS NOT DISTINCT FROM sqlc.narg('seen_failed_at')::timestamp
+RETURNING failed_logins;
+
+-- name: RefundLoginAttempt :exec
+UPDATE users
+SET failed_logins = GREATEST(failed_logins - 1, 0)
+WHERE id = $1;
+
+-- name: ResetFailedLogins :execrows
+UPDATE users
+SET
+	failed_logins = 0,
+	last_failed_login_at = NULL
+WHERE id = $1;
+
+-- name: GetUserRole :one
+SELECT role
+FROM users
+WHERE id = $1;
+
+-- name: LockAdmins :many
+SELECT id
+FROM users
+WHERE role = 'admin'
+FOR UPDATE;
+
+-- name: SetUserRole :execrows
+UPDATE users
+SET
+	role = $1,
+	updated_at = $2
+WHERE id = $3;
@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE follows (
+	follower_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+	followee_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (follower_id, followee_id),
+	CHECK (follower_id <> followee_id)
+);
+
+CREATE INDEX follows_followee_id_idx ON follows (followee_id);
+CREATE INDEX chirps_user_id_created_at_idx ON chirps (user_id, created_at, id);
+
+-- +goose Down
+DROP INDEX chirps_user_id_created_at_idx;
+DROP TABLE follows;
@@ -0,0 +1,41 @@
+-- +goose Up
+CREATE TABLE chirp_likes (
+	chirp_id UUID NOT NULL REFERENCES chirps ON DELETE CASCADE,
+	user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (chirp_id, user_id)
+);
+
+CREATE INDEX chirp_likes_user_id_idx ON chirp_likes (user_id);
+
+ALTER TABLE chirps
+ADD COLUMN like_count INTEGER NOT NULL DEFAULT 0;
+
+-- Keeps like_count in step with chirp_likes however a like row comes or goes, including the
+-- cascade when the liking user is deleted
+-- +goose StatementBegin
+CREATE FUNCTION chirp_likes_count() RETURNS TRIGGER AS $$
+BEGIN
+	IF TG_OP = 'INSERT' THEN
+		UPDATE chirps SET like_count = like_count + 1 WHERE id = NEW.chirp_id;
+	ELSE
+		UPDATE chirps SET like_count = like_count - 1 WHERE id = OLD.chirp_id;
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER chirp_likes_count
+AFTER INSERT OR DELETE ON chirp_likes
+FOR EACH ROW EXECUTE FUNCTION chirp_likes_count();
+
+-- +goose Down
+DROP TRIGGER chirp_likes_count ON chirp_likes;
+
+DROP FUNCTION chirp_likes_count();
+
+ALTER TABLE chirps
+DROP COLUMN like_count;
+
+DROP TABLE chirp_likes;
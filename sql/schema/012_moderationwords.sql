@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE moderation_words (
+	word TEXT PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO moderation_words (word) VALUES
+	('kerfuffle'),
+	('sharbert'),
+	('fornax');
+
+-- +goose Down
+DROP TABLE moderation_words;
@@ -0,0 +1,20 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD COLUMN deleted_at TIMESTAMP;
+
+UPDATE chirps
+SET deleted_at = updated_at
+WHERE tombstoned;
+
+CREATE INDEX chirps_deleted_at_idx ON chirps (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX chirps_deleted_at_idx;
+
+-- Keeps deleted chirps as tombstones, which is how the earlier schema hides them, rather than dropping them
+UPDATE chirps
+SET tombstoned = true
+WHERE deleted_at IS NOT NULL;
+
+ALTER TABLE chirps
+DROP COLUMN deleted_at;
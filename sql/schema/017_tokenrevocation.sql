@@ -0,0 +1,16 @@
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN token_version INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE denied_access_tokens (
+	jti TEXT PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+	expires_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE denied_access_tokens;
+
+ALTER TABLE users
+DROP COLUMN token_version;
@@ -0,0 +1,24 @@
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN email_verified_at TIMESTAMP;
+
+-- Accounts made before verification existed are treated as verified, so requiring it doesn't lock them out of posting
+UPDATE users
+SET email_verified_at = created_at;
+
+CREATE TABLE email_verification_tokens (
+	token_hash TEXT PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+	email TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP
+);
+
+CREATE INDEX email_verification_tokens_user_id_idx ON email_verification_tokens (user_id);
+
+-- +goose Down
+DROP TABLE email_verification_tokens;
+
+ALTER TABLE users
+DROP COLUMN email_verified_at;
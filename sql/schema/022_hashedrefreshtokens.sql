@@ -0,0 +1,15 @@
+-- +goose Up
+ALTER TABLE refresh_tokens
+RENAME COLUMN token TO token_hash;
+
+-- Existing tokens are replaced by a plain SHA-256 hash, since the key for the keyed hash isn't available here;
+-- the server still accepts them this way until they expire
+UPDATE refresh_tokens
+SET token_hash = encode(sha256(convert_to(token_hash, 'UTF8')), 'hex');
+
+-- +goose Down
+-- The original tokens can't be recovered from their hashes, so every session ends
+DELETE FROM refresh_tokens;
+
+ALTER TABLE refresh_tokens
+RENAME COLUMN token_hash TO token;
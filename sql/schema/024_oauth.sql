@@ -0,0 +1,47 @@
+-- +goose Up
+CREATE TABLE oauth_clients (
+	id UUID PRIMARY KEY,
+	owner_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	secret_hash TEXT,
+	redirect_uris TEXT[] NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE oauth_consents (
+	user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+	client_id UUID NOT NULL REFERENCES oauth_clients ON DELETE CASCADE,
+	scopes TEXT[] NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (user_id, client_id)
+);
+
+CREATE TABLE oauth_authorization_codes (
+	code_hash TEXT PRIMARY KEY,
+	client_id UUID NOT NULL REFERENCES oauth_clients ON DELETE CASCADE,
+	user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+	redirect_uri TEXT NOT NULL,
+	scopes TEXT[] NOT NULL,
+	code_challenge TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP,
+	family_id UUID
+);
+
+-- Tokens issued to a client carry its ID and the scopes the user granted; first-party sessions leave both NULL
+ALTER TABLE refresh_tokens
+ADD COLUMN client_id UUID REFERENCES oauth_clients ON DELETE CASCADE,
+ADD COLUMN scopes TEXT[];
+
+-- +goose Down
+ALTER TABLE refresh_tokens
+DROP COLUMN scopes,
+DROP COLUMN client_id;
+
+DROP TABLE oauth_authorization_codes;
+
+DROP TABLE oauth_consents;
+
+DROP TABLE oauth_clients;